@@ -6,7 +6,10 @@ import (
 	"log"
 	"time"
 
+	"github.com/nknorg/nconnect/audit"
 	"github.com/nknorg/nconnect/config"
+	"github.com/nknorg/nconnect/quota"
+	"github.com/nknorg/nconnect/ss"
 	"github.com/nknorg/nkn-sdk-go"
 )
 
@@ -47,13 +50,31 @@ func NewClient(account *nkn.Account, clientConfig *nkn.ClientConfig, identifier
 }
 
 func (c *Client) RPCCall(addr, method string, params interface{}, result interface{}) error {
+	return c.rpcCall(method, params, result, func(reqBytes []byte) (*nkn.Message, error) {
+		return c.SendData(addr, reqBytes, true)
+	})
+}
+
+// RPCCallOnce is like RPCCall, but sends the request only once and waits for the reply
+// within timeout, for methods which take long and should not be run twice.
+func (c *Client) RPCCallOnce(addr, method string, params interface{}, result interface{}, timeout time.Duration) error {
+	return c.rpcCall(method, params, result, func(reqBytes []byte) (*nkn.Message, error) {
+		return c.SendDataOnce(addr, reqBytes, timeout)
+	})
+}
+
+func (c *Client) rpcCall(method string, params interface{}, result interface{}, send func([]byte) (*nkn.Message, error)) error {
 	req := map[string]interface{}{
 		"id":     "nConnect",
 		"method": method,
 		"params": params,
 	}
 
-	reply, err := c.SendMsg(addr, req, true)
+	reqBytes, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	reply, err := send(reqBytes)
 	if err != nil {
 		return err
 	}
@@ -82,16 +103,65 @@ func (c *Client) GetInfo(addr string) (*GetInfoJSON, error) {
 	return res, nil
 }
 
-func (c *Client) SendMsg(address string, msg interface{}, waitResponse bool) (reply *nkn.Message, err error) {
-	if c.ReplyTimeout == 0 {
-		c.ReplyTimeout = replyTimeout
+func (c *Client) GetConnections(addr string) ([]*ss.ConnInfo, error) {
+	var res []*ss.ConnInfo
+	err := c.RPCCall(addr, "getConnections", nil, &res)
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (c *Client) KillConnection(addr string, id uint64) error {
+	return c.RPCCall(addr, "killConnection", &connectionJSON{ID: id}, nil)
+}
+
+func (c *Client) GetClientUsage(addr, client string) ([]*quota.ClientUsage, error) {
+	var res []*quota.ClientUsage
+	err := c.RPCCall(addr, "getClientUsage", &clientUsageJSON{Client: client}, &res)
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (c *Client) GetUsage(addr string, days int) (*UsageJSON, error) {
+	res := &UsageJSON{}
+	err := c.RPCCall(addr, "getUsage", &getUsageJSON{Days: days}, res)
+	if err != nil {
+		return nil, err
 	}
+	return res, nil
+}
 
+func (c *Client) GetAuditLog(addr string, filter *audit.Filter) ([]*audit.Record, error) {
+	var res []*audit.Record
+	err := c.RPCCall(addr, "getAuditLog", filter, &res)
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (c *Client) ResetClientUsage(addr, client string) error {
+	return c.RPCCall(addr, "resetClientUsage", &clientUsageJSON{Client: client}, nil)
+}
+
+func (c *Client) SendMsg(address string, msg interface{}, waitResponse bool) (reply *nkn.Message, err error) {
 	reqBytes, err := json.Marshal(msg)
 	if err != nil {
 		return nil, err
 	}
 
+	return c.SendData(address, reqBytes, waitResponse)
+}
+
+// SendData sends already encoded bytes to address, and waits for the reply if waitResponse is true.
+func (c *Client) SendData(address string, reqBytes []byte, waitResponse bool) (reply *nkn.Message, err error) {
+	if c.ReplyTimeout == 0 {
+		c.ReplyTimeout = replyTimeout
+	}
+
 	var onReply *nkn.OnMessage
 	for i := 0; i < 3; i++ {
 		onReply, err = c.Send(nkn.NewStringArray(address), reqBytes, nkn.GetDefaultMessageConfig())
@@ -118,3 +188,19 @@ func (c *Client) SendMsg(address string, msg interface{}, waitResponse bool) (re
 
 	return nil, err
 }
+
+// SendDataOnce sends already encoded bytes to address without retrying, and waits for the
+// reply within timeout. It's for requests which take long to handle and should not be repeated.
+func (c *Client) SendDataOnce(address string, reqBytes []byte, timeout time.Duration) (*nkn.Message, error) {
+	onReply, err := c.Send(nkn.NewStringArray(address), reqBytes, nkn.GetDefaultMessageConfig())
+	if err != nil {
+		return nil, err
+	}
+
+	select {
+	case reply := <-onReply.C:
+		return reply, nil
+	case <-time.After(timeout):
+		return nil, ErrReplyTimeout
+	}
+}
@@ -3,13 +3,21 @@ package admin
 import (
 	"errors"
 	"io/ioutil"
+	"log"
 	"net"
+	"strconv"
 
+	"github.com/nknorg/nconnect/audit"
 	"github.com/nknorg/nconnect/config"
+	"github.com/nknorg/nconnect/event"
+	"github.com/nknorg/nconnect/quota"
+	"github.com/nknorg/nconnect/ss"
 	"github.com/nknorg/nconnect/util"
 	"github.com/nknorg/nkn-sdk-go"
 	ts "github.com/nknorg/nkn-tuna-session"
 	tunnel "github.com/nknorg/nkn-tunnel"
+	"github.com/nknorg/nkn/v2/common"
+	"github.com/nknorg/tuna"
 	"github.com/nknorg/tuna/filter"
 	"github.com/nknorg/tuna/geo"
 )
@@ -26,23 +34,43 @@ var (
 	errUnknownMethod    = errors.New("unknown method")
 	errPermissionDenied = errors.New("permission denied")
 	resultSuccess       = "success"
+	defaultUsageDays    = 7
 )
 
 var (
 	rpcPermissions = map[string]permission{
-		"getAdminToken":   rpcPermissionAdminClient | rpcPermissionWeb,
-		"getAddrs":        rpcPermissionAdminClient | rpcPermissionWeb,
-		"setAddrs":        rpcPermissionAdminClient | rpcPermissionWeb,
-		"addAddrs":        rpcPermissionAdminClient | rpcPermissionWeb,
-		"removeAddrs":     rpcPermissionAdminClient | rpcPermissionWeb,
-		"getLocalIP":      rpcPermissionAcceptClient | rpcPermissionAdminClient | rpcPermissionWeb,
-		"getInfo":         rpcPermissionAcceptClient | rpcPermissionAdminClient | rpcPermissionWeb,
-		"getBalance":      rpcPermissionAcceptClient | rpcPermissionAdminClient | rpcPermissionWeb,
-		"setAdminHttpApi": rpcPermissionAdminClient | rpcPermissionWeb,
-		"getSeed":         rpcPermissionAdminClient | rpcPermissionWeb,
-		"setSeed":         rpcPermissionAdminClient | rpcPermissionWeb,
-		"setTunaConfig":   rpcPermissionAdminClient | rpcPermissionWeb,
-		"getLog":          rpcPermissionAdminClient | rpcPermissionWeb,
+		"getAdminToken":    rpcPermissionAdminClient | rpcPermissionWeb,
+		"getAddrs":         rpcPermissionAdminClient | rpcPermissionWeb,
+		"setAddrs":         rpcPermissionAdminClient | rpcPermissionWeb,
+		"addAddrs":         rpcPermissionAdminClient | rpcPermissionWeb,
+		"removeAddrs":      rpcPermissionAdminClient | rpcPermissionWeb,
+		"getLocalIP":       rpcPermissionAcceptClient | rpcPermissionAdminClient | rpcPermissionWeb,
+		"getInfo":          rpcPermissionAcceptClient | rpcPermissionAdminClient | rpcPermissionWeb,
+		"getBalance":       rpcPermissionAcceptClient | rpcPermissionAdminClient | rpcPermissionWeb,
+		"setAdminHttpApi":  rpcPermissionAdminClient | rpcPermissionWeb,
+		"getSeed":          rpcPermissionAdminClient | rpcPermissionWeb,
+		"setSeed":          rpcPermissionAdminClient | rpcPermissionWeb,
+		"setTunaConfig":    rpcPermissionAdminClient | rpcPermissionWeb,
+		"getLog":           rpcPermissionAdminClient | rpcPermissionWeb,
+		"getConnections":   rpcPermissionAdminClient | rpcPermissionWeb,
+		"killConnection":   rpcPermissionAdminClient | rpcPermissionWeb,
+		"getClientUsage":   rpcPermissionAdminClient | rpcPermissionWeb,
+		"resetClientUsage": rpcPermissionAdminClient | rpcPermissionWeb,
+		"getUsage":         rpcPermissionAdminClient | rpcPermissionWeb,
+		"getAuditLog":      rpcPermissionAdminClient | rpcPermissionWeb,
+	}
+
+	// methods that don't change anything or reveal secrets are not audited
+	unauditedMethods = map[string]bool{
+		"getAddrs":       true,
+		"getLocalIP":     true,
+		"getInfo":        true,
+		"getBalance":     true,
+		"getLog":         true,
+		"getConnections": true,
+		"getClientUsage": true,
+		"getUsage":       true,
+		"getAuditLog":    true,
 	}
 )
 
@@ -81,9 +109,11 @@ type GetInfoJSON struct {
 	Tuna                 bool         `json:"tuna"`
 	TunaServiceName      string       `json:"tunaServiceName,omitempty"`
 	TunaCountry          []string     `json:"tunaCountry,omitempty"`
+	TunaMaxPrice         string       `json:"tunaMaxPrice,omitempty"` // effective tuna max price, NKN/MB
 	InPrice              []string     `json:"inPrice,omitempty"`
 	OutPrice             []string     `json:"outPrice,omitempty"`
 	Tags                 []string     `json:"tags,omitempty"`
+	Protocols            []string     `json:"protocols,omitempty"` // accepted internal protocols, only aead if empty
 }
 
 type setSeedJSON struct {
@@ -107,9 +137,38 @@ type getLogJSON struct {
 	MaxSize int `json:"maxSize"`
 }
 
-func handleRequest(req *RpcReq, persistConf, mergedConf *config.Config, tun *tunnel.Tunnel, rpcPerm permission) *RpcResp {
+type connectionJSON struct {
+	ID uint64 `json:"id"`
+}
+
+type clientUsageJSON struct {
+	Client string `json:"client"`
+}
+
+type getUsageJSON struct {
+	Days int `json:"days"`
+}
+
+type UsageJSON struct {
+	*quota.Report
+	TunaNodes    []quota.TunaNode `json:"tunaNodes"`
+	Balance      string           `json:"balance"`
+	TunaMaxPrice string           `json:"tunaMaxPrice"`
+	MaxDailyCost float64          `json:"maxDailyCost"` // average daily tuna traffic at tuna max price
+	DaysLeft     float64          `json:"daysLeft"`     // days the balance lasts at max daily cost, -1 if no tuna traffic
+}
+
+func handleRequest(req *RpcReq, persistConf, mergedConf *config.Config, tun *tunnel.Tunnel, usage *quota.Store, rpcPerm permission, caller audit.Caller) *RpcResp {
 	resp := &RpcResp{}
 
+	if _, ok := rpcPermissions[req.Method]; ok && !unauditedMethods[req.Method] {
+		defer func() {
+			if err := audit.Add(caller, req.Method, req.Params, resp.Error); err != nil {
+				log.Println("Add audit record error:", err)
+			}
+		}()
+	}
+
 	if rpcPermissions[req.Method]&rpcPerm == 0 {
 		resp.Error = errPermissionDenied.Error()
 		return resp
@@ -133,6 +192,7 @@ func handleRequest(req *RpcReq, persistConf, mergedConf *config.Config, tun *tun
 			break
 		}
 		resp.Result = getAddrs(persistConf)
+		event.Emit(event.AcceptChanged, resp.Result)
 	case "addAddrs":
 		addrs := &addrsJSON{}
 		err := util.JSONConvert(req.Params, addrs)
@@ -146,6 +206,7 @@ func handleRequest(req *RpcReq, persistConf, mergedConf *config.Config, tun *tun
 			break
 		}
 		resp.Result = getAddrs(persistConf)
+		event.Emit(event.AcceptChanged, resp.Result)
 	case "removeAddrs":
 		addrs := &addrsJSON{}
 		err := util.JSONConvert(req.Params, addrs)
@@ -159,6 +220,7 @@ func handleRequest(req *RpcReq, persistConf, mergedConf *config.Config, tun *tun
 			break
 		}
 		resp.Result = getAddrs(persistConf)
+		event.Emit(event.AcceptChanged, resp.Result)
 	case "getLocalIP":
 		localIP, err := getLocalIP()
 		if err != nil {
@@ -234,6 +296,68 @@ func handleRequest(req *RpcReq, persistConf, mergedConf *config.Config, tun *tun
 			break
 		}
 		resp.Result = logContent
+	case "getConnections":
+		resp.Result = ss.Connections()
+	case "killConnection":
+		params := &connectionJSON{}
+		err := util.JSONConvert(req.Params, params)
+		if err != nil {
+			resp.Error = err.Error()
+			break
+		}
+		err = ss.KillConnection(params.ID)
+		if err != nil {
+			resp.Error = err.Error()
+			break
+		}
+		resp.Result = resultSuccess
+	case "getClientUsage":
+		params := &clientUsageJSON{}
+		err := util.JSONConvert(req.Params, params)
+		if err != nil {
+			resp.Error = err.Error()
+			break
+		}
+		resp.Result = usage.Usage(params.Client)
+	case "resetClientUsage":
+		params := &clientUsageJSON{}
+		err := util.JSONConvert(req.Params, params)
+		if err != nil {
+			resp.Error = err.Error()
+			break
+		}
+		err = usage.Reset(params.Client)
+		if err != nil {
+			resp.Error = err.Error()
+			break
+		}
+		resp.Result = resultSuccess
+	case "getAuditLog":
+		params := &audit.Filter{}
+		err := util.JSONConvert(req.Params, params)
+		if err != nil {
+			resp.Error = err.Error()
+			break
+		}
+		records, err := audit.Query(params)
+		if err != nil {
+			resp.Error = err.Error()
+			break
+		}
+		resp.Result = records
+	case "getUsage":
+		params := &getUsageJSON{Days: defaultUsageDays}
+		err := util.JSONConvert(req.Params, params)
+		if err != nil {
+			resp.Error = err.Error()
+			break
+		}
+		res, err := getUsage(mergedConf, tun, usage, params.Days)
+		if err != nil {
+			resp.Error = err.Error()
+			break
+		}
+		resp.Result = res
 	default:
 		resp.Error = errUnknownMethod.Error()
 	}
@@ -334,9 +458,10 @@ func getInfo(conf *config.Config, tun *tunnel.Tunnel) (*GetInfoJSON, error) {
 		Addr:                 tun.FromAddr(),
 		LocalIP:              localIP,
 		AdminHTTPAPIDisabled: conf.DisableAdminHTTPAPI,
-		Tuna:                 conf.Tuna,
+		Tuna:                 conf.IsTuna(),
 		TunaServiceName:      conf.TunaServiceName,
 		TunaCountry:          conf.TunaCountry,
+		TunaMaxPrice:         conf.GetTunaMaxPrice(),
 		Version:              config.Version,
 	}
 	tunaPubAddrs := tun.TunaPubAddrs()
@@ -353,9 +478,25 @@ func getInfo(conf *config.Config, tun *tunnel.Tunnel) (*GetInfoJSON, error) {
 	if len(conf.Tags) > 0 {
 		info.Tags = conf.Tags
 	}
+	if conf.Protocol == config.ProtocolRaw {
+		info.Protocols = []string{config.ProtocolAEAD, config.ProtocolRaw}
+	}
 	return info, nil
 }
 
+// SupportsProtocol returns whether the remote server accepts internal protocol.
+func (info *GetInfoJSON) SupportsProtocol(protocol string) bool {
+	if protocol == config.ProtocolAEAD {
+		return true
+	}
+	for _, p := range info.Protocols {
+		if p == protocol {
+			return true
+		}
+	}
+	return false
+}
+
 func getBalance(tun *tunnel.Tunnel) (string, error) {
 	balance, err := tun.MultiClient().Balance()
 	if err != nil {
@@ -364,6 +505,48 @@ func getBalance(tun *tunnel.Tunnel) (string, error) {
 	return balance.String(), nil
 }
 
+func getUsage(conf *config.Config, tun *tunnel.Tunnel, usage *quota.Store, days int) (*UsageJSON, error) {
+	balance, err := getBalance(tun)
+	if err != nil {
+		return nil, err
+	}
+	b, err := strconv.ParseFloat(balance, 64)
+	if err != nil {
+		return nil, err
+	}
+	tunaMaxPrice := conf.GetTunaMaxPrice()
+
+	res := &UsageJSON{
+		Report:       usage.Report(days),
+		TunaNodes:    usage.TunaNodes(),
+		Balance:      balance,
+		TunaMaxPrice: tunaMaxPrice,
+		DaysLeft:     -1,
+	}
+	res.MaxDailyCost, err = tunaCost(res.AvgDaily.In, res.AvgDaily.Out, tunaMaxPrice)
+	if err != nil {
+		return nil, err
+	}
+	if res.MaxDailyCost > 0 {
+		res.DaysLeft = b / res.MaxDailyCost
+	}
+
+	return res, nil
+}
+
+// tunaCost returns the NKN cost of in and out bytes at price. Price is in NKN/MB, either one
+// price of both directions or "in,out" like tuna node prices, where in is the traffic from
+// clients to server (entry to exit) and out the reply, so it's parsed by tuna instead of as
+// a float.
+func tunaCost(in, out uint64, price string) (float64, error) {
+	inPrice, outPrice, err := tuna.ParsePrice(price)
+	if err != nil {
+		return 0, err
+	}
+	cost := float64(in)*float64(inPrice) + float64(out)*float64(outPrice)
+	return cost / tuna.TrafficUnit / common.StorageFactor, nil
+}
+
 func setAdminHTTPAPI(persistConf, mergedConf *config.Config, params *adminHTTPAPIJSON) error {
 	err := persistConf.SetAdminHTTPAPI(params.Disable)
 	if err != nil {
@@ -0,0 +1,24 @@
+package admin
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// go test -v -run=TestTunaCost
+func TestTunaCost(t *testing.T) {
+	const mb = 1 << 20
+
+	cost, err := tunaCost(100*mb, 300*mb, "0.01")
+	require.NoError(t, err)
+	require.InDelta(t, 4, cost, 1e-9)
+
+	// in and out are charged at their own price
+	cost, err = tunaCost(100*mb, 300*mb, "0.01,0.02")
+	require.NoError(t, err)
+	require.InDelta(t, 7, cost, 1e-9)
+
+	_, err = tunaCost(mb, mb, "free")
+	require.Error(t, err)
+}
@@ -4,13 +4,15 @@ import (
 	"encoding/json"
 	"log"
 
+	"github.com/nknorg/nconnect/audit"
 	"github.com/nknorg/nconnect/config"
+	"github.com/nknorg/nconnect/quota"
 	"github.com/nknorg/nconnect/util"
 	"github.com/nknorg/nkn-sdk-go"
 	tunnel "github.com/nknorg/nkn-tunnel"
 )
 
-func StartNKNServer(account *nkn.Account, identifier string, clientConfig *nkn.ClientConfig, tun *tunnel.Tunnel, persistConf, mergedConf *config.Config) error {
+func StartNKNServer(account *nkn.Account, identifier string, clientConfig *nkn.ClientConfig, tun *tunnel.Tunnel, persistConf, mergedConf *config.Config, usage *quota.Store) error {
 	m, err := nkn.NewMultiClient(account, identifier, 4, false, clientConfig)
 	if err != nil {
 		return err
@@ -33,8 +35,10 @@ func StartNKNServer(account *nkn.Account, identifier string, clientConfig *nkn.C
 		isAcceptAddr := util.MatchRegex(persistConf.GetAcceptAddrs(), msg.Src)
 		isAdminAddr := util.MatchRegex(persistConf.GetAdminAddrs(), msg.Src)
 
+		caller := audit.Caller{Addr: msg.Src, Via: audit.ViaNKN}
 		if !isAdminAddr && tokenStore.IsValid(req.Token) {
 			isAdminAddr = true
+			caller.Via = audit.ViaToken
 		}
 
 		if !isAcceptAddr && !isAdminAddr {
@@ -50,7 +54,7 @@ func StartNKNServer(account *nkn.Account, identifier string, clientConfig *nkn.C
 			perm |= rpcPermissionAdminClient
 		}
 
-		resp := handleRequest(req, persistConf, mergedConf, tun, perm)
+		resp := handleRequest(req, persistConf, mergedConf, tun, usage, perm, caller)
 
 		b, err := json.Marshal(resp)
 		if err != nil {
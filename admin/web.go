@@ -7,7 +7,10 @@ import (
 
 	"github.com/gin-contrib/gzip"
 	"github.com/gin-gonic/gin"
+	"github.com/nknorg/nconnect/audit"
 	"github.com/nknorg/nconnect/config"
+	"github.com/nknorg/nconnect/event"
+	"github.com/nknorg/nconnect/quota"
 	tunnel "github.com/nknorg/nkn-tunnel"
 )
 
@@ -15,7 +18,7 @@ var (
 	errAdminHTTPAPIDisabled = errors.New("Web API is disabled")
 )
 
-func StartWebServer(listenAddr string, tun *tunnel.Tunnel, persistConf, mergedConf *config.Config) error {
+func StartWebServer(listenAddr string, tun *tunnel.Tunnel, persistConf, mergedConf *config.Config, usage *quota.Store) error {
 	gin.SetMode(gin.ReleaseMode)
 
 	r := gin.Default()
@@ -32,10 +35,18 @@ func StartWebServer(listenAddr string, tun *tunnel.Tunnel, persistConf, mergedCo
 			c.JSON(http.StatusOK, &RpcResp{Error: errAdminHTTPAPIDisabled.Error()})
 			return
 		}
-		resp := handleRequest(req, persistConf, mergedConf, tun, rpcPermissionWeb)
+		resp := handleRequest(req, persistConf, mergedConf, tun, usage, rpcPermissionWeb, audit.Caller{Addr: c.ClientIP(), Via: audit.ViaWeb})
 		c.JSON(http.StatusOK, resp)
 	})
 
+	r.GET("/events", func(c *gin.Context) {
+		if mergedConf.DisableAdminHTTPAPI {
+			c.JSON(http.StatusForbidden, gin.H{"error": errAdminHTTPAPIDisabled.Error()})
+			return
+		}
+		event.ServeSSE(c.Writer, c.Request)
+	})
+
 	r.StaticFile("/", path.Join(mergedConf.WebRootPath, "index.html"))
 	r.StaticFile("/favicon.ico", path.Join(mergedConf.WebRootPath, "favicon.ico"))
 	r.StaticFile("/sw.js", path.Join(mergedConf.WebRootPath, "sw.js"))
@@ -0,0 +1,25 @@
+package arch
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// runCmd runs the command and returns an error with its output if it fails.
+func runCmd(name string, args ...string) error {
+	return runCmdInput("", name, args...)
+}
+
+// runCmdInput runs the command with input as stdin.
+func runCmdInput(input, name string, args ...string) error {
+	c := exec.Command(name, args...)
+	if len(input) > 0 {
+		c.Stdin = strings.NewReader(input)
+	}
+	out, err := c.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s %s: %v: %s", name, strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package arch
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/eycorsican/go-tun2socks/core"
+)
+
+// directTCPHandler sends TCP sessions of the network stack through dial without a SOCKS proxy.
+type directTCPHandler struct {
+	dial func(source, target string) (net.Conn, error)
+}
+
+func (h *directTCPHandler) Handle(conn net.Conn, target *net.TCPAddr) error {
+	rc, err := h.dial(conn.LocalAddr().String(), target.String())
+	if err != nil {
+		return err
+	}
+	go relayConn(conn, rc)
+	return nil
+}
+
+// relayConn copies between two connections and closes both when either direction ends.
+func relayConn(left, right net.Conn) {
+	done := make(chan struct{})
+	go func() {
+		io.Copy(right, left)
+		right.Close()
+		left.Close()
+		close(done)
+	}()
+	io.Copy(left, right)
+	right.Close()
+	left.Close()
+	<-done
+}
+
+// directUDPHandler sends UDP sessions of the network stack through packet conns from
+// listenPacket without a SOCKS proxy.
+type directUDPHandler struct {
+	sync.Mutex
+	listenPacket func(source, target string) (net.PacketConn, error)
+	timeout      time.Duration
+	conns        map[core.UDPConn]net.PacketConn
+}
+
+func newDirectUDPHandler(listenPacket func(source, target string) (net.PacketConn, error), timeout time.Duration) *directUDPHandler {
+	return &directUDPHandler{
+		listenPacket: listenPacket,
+		timeout:      timeout,
+		conns:        make(map[core.UDPConn]net.PacketConn),
+	}
+}
+
+func (h *directUDPHandler) Connect(conn core.UDPConn, target *net.UDPAddr) error {
+	pc, err := h.listenPacket(conn.LocalAddr().String(), target.String())
+	if err != nil {
+		return err
+	}
+
+	h.Lock()
+	h.conns[conn] = pc
+	h.Unlock()
+
+	go h.fetchInput(conn, pc)
+	return nil
+}
+
+// fetchInput writes packets from pc to the network stack until no packet is received within timeout.
+func (h *directUDPHandler) fetchInput(conn core.UDPConn, pc net.PacketConn) {
+	defer h.close(conn)
+
+	buf := make([]byte, 64*1024)
+	for {
+		pc.SetReadDeadline(time.Now().Add(h.timeout))
+		n, addr, err := pc.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		udpAddr, ok := addr.(*net.UDPAddr)
+		if !ok {
+			continue
+		}
+		if _, err = conn.WriteFrom(buf[:n], udpAddr); err != nil {
+			log.Printf("Write UDP packet to TUN device error: %v", err)
+			return
+		}
+	}
+}
+
+func (h *directUDPHandler) ReceiveTo(conn core.UDPConn, data []byte, addr *net.UDPAddr) error {
+	h.Lock()
+	pc, ok := h.conns[conn]
+	h.Unlock()
+	if !ok {
+		return fmt.Errorf("UDP session %v -> %v doesn't exist", conn.LocalAddr(), addr)
+	}
+
+	_, err := pc.WriteTo(data, addr)
+	return err
+}
+
+func (h *directUDPHandler) close(conn core.UDPConn) {
+	conn.Close()
+
+	h.Lock()
+	pc, ok := h.conns[conn]
+	delete(h.conns, conn)
+	h.Unlock()
+
+	if ok {
+		pc.Close()
+	}
+}
@@ -0,0 +1,96 @@
+package arch
+
+import (
+	"encoding/binary"
+	"log"
+	"net"
+
+	"github.com/nknorg/nconnect/util"
+)
+
+const (
+	protocolICMP        = 1
+	icmpTypeEchoReply   = 0
+	icmpTypeEchoRequest = 8
+	icmpHeaderLen       = 8
+	defaultTTL          = 64
+
+	// maxICMPEchoes is the most echo requests from TUN device waiting for replies, which
+	// only come from this host.
+	maxICMPEchoes = 64
+)
+
+var icmpEchoes = util.NewLimiter(maxICMPEchoes)
+
+// parseICMPEcho returns source, destination and ICMP message of an IPv4 echo request packet.
+func parseICMPEcho(pkt []byte) (src, dst net.IP, msg []byte, ok bool) {
+	if len(pkt) < ipv4HeaderLen || pkt[0]>>4 != 4 {
+		return nil, nil, nil, false
+	}
+	ihl := int(pkt[0]&0x0f) * 4
+	totalLen := int(binary.BigEndian.Uint16(pkt[2:4]))
+	// fragmented requests are not handled
+	if ihl < ipv4HeaderLen || totalLen < ihl+icmpHeaderLen || totalLen > len(pkt) || pkt[9] != protocolICMP ||
+		binary.BigEndian.Uint16(pkt[6:8])&0x3fff != 0 || pkt[ihl] != icmpTypeEchoRequest {
+		return nil, nil, nil, false
+	}
+	return net.IP(pkt[12:16]), net.IP(pkt[16:20]), pkt[ihl:totalLen], true
+}
+
+// icmpPacket returns an IPv4 packet carrying ICMP message msg from src to dst.
+func icmpPacket(src, dst net.IP, msg []byte) []byte {
+	pkt := make([]byte, ipv4HeaderLen+len(msg))
+	pkt[0] = 0x45
+	binary.BigEndian.PutUint16(pkt[2:4], uint16(len(pkt)))
+	pkt[8] = defaultTTL
+	pkt[9] = protocolICMP
+	copy(pkt[12:16], src.To4())
+	copy(pkt[16:20], dst.To4())
+	binary.BigEndian.PutUint16(pkt[10:12], checksum(pkt[:ipv4HeaderLen]))
+	copy(pkt[ipv4HeaderLen:], msg)
+	return pkt
+}
+
+// checksum returns the internet checksum of b, RFC 1071.
+func checksum(b []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(b); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(b[i:]))
+	}
+	if len(b)%2 == 1 {
+		sum += uint32(b[len(b)-1]) << 8
+	}
+	for sum>>16 != 0 {
+		sum = (sum & 0xffff) + (sum >> 16)
+	}
+	return ^uint16(sum)
+}
+
+// goICMPEcho handles a copy of the echo request in pkt in a new goroutine. It returns false
+// and drops the request if maxICMPEchoes requests are waiting for replies.
+func goICMPEcho(pkt []byte, echo func(src, dst net.IP, msg []byte) ([]byte, error)) bool {
+	pkt = append([]byte(nil), pkt...)
+	return icmpEchoes.TryGo(func() { handleICMPEcho(pkt, echo) })
+}
+
+// handleICMPEcho sends the echo request in pkt by echo, and writes the reply to TUN device.
+func handleICMPEcho(pkt []byte, echo func(src, dst net.IP, msg []byte) ([]byte, error)) {
+	src, dst, msg, ok := parseICMPEcho(pkt)
+	if !ok {
+		return
+	}
+	reply, err := echo(src, dst, msg)
+	if err, ok := err.(net.Error); ok && err.Timeout() {
+		return // no reply in time, like a lost packet
+	}
+	if err != nil {
+		log.Printf("ICMP echo to %v error: %v", dst, err)
+		return
+	}
+	if len(reply) < icmpHeaderLen || reply[0] != icmpTypeEchoReply {
+		return
+	}
+	if err = WriteTunPacket(icmpPacket(dst, src, reply)); err != nil {
+		log.Printf("Write ICMP echo reply to TUN device error: %v", err)
+	}
+}
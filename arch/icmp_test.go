@@ -0,0 +1,57 @@
+package arch
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestICMPPacket(t *testing.T) {
+	msg := []byte{icmpTypeEchoRequest, 0, 0, 0, 0x12, 0x34, 0, 1, 'h', 'i'}
+	pkt := icmpPacket(net.ParseIP("10.0.86.2"), net.ParseIP("192.168.1.1"), msg)
+	if checksum(pkt[:ipv4HeaderLen]) != 0 {
+		t.Fatal("invalid IPv4 header checksum")
+	}
+
+	src, dst, got, ok := parseICMPEcho(pkt)
+	if !ok {
+		t.Fatal("echo request is not parsed")
+	}
+	if src.String() != "10.0.86.2" || dst.String() != "192.168.1.1" || !bytes.Equal(got, msg) {
+		t.Fatalf("unexpected echo request %v -> %v: %x", src, dst, got)
+	}
+
+	msg[0] = icmpTypeEchoReply
+	if _, _, _, ok = parseICMPEcho(icmpPacket(dst, src, msg)); ok {
+		t.Fatal("echo reply should not be parsed as request")
+	}
+}
+
+func TestGoICMPEcho(t *testing.T) {
+	pkt := icmpPacket(net.ParseIP("10.0.86.2"), net.ParseIP("192.168.1.1"), []byte{icmpTypeEchoRequest, 0, 0, 0, 0, 1, 0, 1})
+	sent := make(chan struct{}, 1)
+	echo := func(src, dst net.IP, msg []byte) ([]byte, error) {
+		sent <- struct{}{}
+		return nil, nil
+	}
+
+	for i := 0; i < maxICMPEchoes; i++ {
+		icmpEchoes <- struct{}{}
+	}
+	if goICMPEcho(pkt, echo) {
+		t.Fatal("echo request should be dropped when too many are waiting for replies")
+	}
+	for i := 0; i < maxICMPEchoes; i++ {
+		<-icmpEchoes
+	}
+
+	if !goICMPEcho(pkt, echo) {
+		t.Fatal("echo request is dropped")
+	}
+	select {
+	case <-sent:
+	case <-time.After(time.Second):
+		t.Fatal("echo request is not sent")
+	}
+}
@@ -0,0 +1,158 @@
+package arch
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"os"
+)
+
+// RouteJournalFile is the file name of the route journal, nConnect keeps it next to its config.
+const RouteJournalFile = "routes.json"
+
+// RouteJournal is the file recording routes installed by nConnect processes, so routes
+// left by a process which exited uncleanly can be removed when nConnect starts again.
+var RouteJournal = RouteJournalFile
+
+type routeEntry struct {
+	Dest     string `json:"dest,omitempty"`
+	Gateway  string `json:"gateway,omitempty"`
+	Dev      string `json:"dev,omitempty"`
+	Table    uint32 `json:"table,omitempty"`    // 0 for main table
+	Mark     uint32 `json:"mark,omitempty"`     // firewall mark of routing policy rules
+	Firewall string `json:"firewall,omitempty"` // name of firewall rules instead of a route
+	Pid      int    `json:"pid"`
+}
+
+// firewalls are firewall rules added by this process, keyed by name, guarded by routesLock
+var firewalls = make(map[string]*routeEntry)
+
+func routeKey(dest *net.IPNet, dev string) string {
+	return dev + " " + dest.String()
+}
+
+func readJournal() ([]*routeEntry, error) {
+	b, err := os.ReadFile(RouteJournal)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var entries []*routeEntry
+	if err = json.Unmarshal(b, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+func saveJournal(entries []*routeEntry) error {
+	if len(entries) == 0 {
+		err := os.Remove(RouteJournal)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	b, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(RouteJournal, b, 0600)
+}
+
+// writeJournal saves routes installed by this process, and keeps routes of other processes.
+// It should be called with routesLock held.
+func writeJournal() {
+	entries, err := readJournal()
+	if err != nil {
+		log.Println("Read route journal error:", err)
+	}
+
+	pid := os.Getpid()
+	res := make([]*routeEntry, 0, len(entries)+len(routes))
+	for _, e := range entries {
+		if e.Pid != pid {
+			res = append(res, e)
+		}
+	}
+	for _, e := range routes {
+		res = append(res, e)
+	}
+	for _, e := range firewalls {
+		res = append(res, e)
+	}
+
+	if err = saveJournal(res); err != nil {
+		log.Println("Save route journal error:", err)
+	}
+}
+
+// journalFirewall records firewall rules by name before they are added, so they are removed
+// by CleanStaleRoutes if the process doesn't exit cleanly, and forgets them after removed.
+func journalFirewall(name string, added bool) {
+	routesLock.Lock()
+	defer routesLock.Unlock()
+	if added {
+		firewalls[name] = &routeEntry{Firewall: name, Pid: os.Getpid()}
+	} else {
+		delete(firewalls, name)
+	}
+	writeJournal()
+}
+
+// CleanStaleRoutes removes routes and firewall rules in the journal which were added by
+// processes not running anymore, together with their routing policy rules. Those failed
+// to be removed are kept in the journal to try next time.
+func CleanStaleRoutes() error {
+	routesLock.Lock()
+	defer routesLock.Unlock()
+	return cleanStaleRoutes(deleteRoute, deletePolicyRules, deleteFirewall)
+}
+
+// cleanStaleRoutes removes stale routes by deleteRoute and deletePolicyRules, and stale
+// firewall rules by deleteFirewall. It should be called with routesLock held.
+func cleanStaleRoutes(deleteRoute func(dest *net.IPNet, gateway, devName string, table uint32) error, deletePolicyRules func(table, mark uint32) error, deleteFirewall func(name string) error) error {
+	entries, err := readJournal()
+	if err != nil {
+		return err
+	}
+
+	pid := os.Getpid()
+	res := make([]*routeEntry, 0, len(entries))
+	var errs []error
+	for _, e := range entries {
+		if e.Pid == pid || processAlive(e.Pid) {
+			res = append(res, e)
+			continue
+		}
+		if len(e.Firewall) > 0 {
+			log.Printf("Deleting stale firewall rules %s left by process %d", e.Firewall, e.Pid)
+			if err = deleteFirewall(e.Firewall); err != nil {
+				errs = append(errs, err)
+				res = append(res, e)
+			}
+			continue
+		}
+		if e.Table > 0 {
+			if err = deletePolicyRules(e.Table, e.Mark); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		_, dest, err := net.ParseCIDR(e.Dest)
+		if err != nil {
+			continue
+		}
+		log.Printf("Deleting stale route %s left by process %d", dest, e.Pid)
+		if err = deleteRoute(dest, e.Gateway, e.Dev, e.Table); err != nil {
+			errs = append(errs, &RouteError{Op: "delete", Dest: dest, Gateway: e.Gateway, Dev: e.Dev, Err: err})
+			res = append(res, e)
+		}
+	}
+
+	if err = saveJournal(res); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,69 @@
+// +build !windows
+
+package arch
+
+import (
+	"errors"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// go test -v -run=TestCleanStaleRoutes
+func TestCleanStaleRoutes(t *testing.T) {
+	defer func(path string) { RouteJournal = path }(RouteJournal)
+	RouteJournal = filepath.Join(t.TempDir(), RouteJournalFile)
+
+	cmd := exec.Command("true")
+	require.NoError(t, cmd.Run())
+	dead := cmd.Process.Pid
+
+	alive := &routeEntry{Dest: "10.0.1.0/24", Gateway: "10.0.0.1", Dev: "tun0", Pid: os.Getpid()}
+	stale := &routeEntry{Dest: "10.0.2.0/24", Gateway: "10.0.0.1", Dev: "tun0", Table: 100, Mark: 7, Pid: dead}
+	failed := &routeEntry{Dest: "10.0.3.0/24", Gateway: "10.0.0.1", Dev: "tun0", Pid: dead}
+	invalid := &routeEntry{Dest: "invalid", Dev: "tun0", Pid: dead}
+	firewall := &routeEntry{Firewall: "nconnect_killswitch", Pid: dead}
+	require.NoError(t, saveJournal([]*routeEntry{alive, stale, failed, invalid, firewall}))
+
+	var deleted []string
+	var rules [][2]uint32
+	deleteRoute := func(dest *net.IPNet, gateway, devName string, table uint32) error {
+		if dest.String() == failed.Dest {
+			return errors.New("operation not permitted")
+		}
+		deleted = append(deleted, dest.String())
+		return nil
+	}
+	deletePolicyRules := func(table, mark uint32) error {
+		rules = append(rules, [2]uint32{table, mark})
+		return nil
+	}
+	var firewalls []string
+	deleteFirewall := func(name string) error {
+		firewalls = append(firewalls, name)
+		return nil
+	}
+
+	err := cleanStaleRoutes(deleteRoute, deletePolicyRules, deleteFirewall)
+	var routeErr *RouteError
+	require.ErrorAs(t, err, &routeErr)
+	require.Equal(t, failed.Dest, routeErr.Dest.String())
+	require.Equal(t, []string{stale.Dest}, deleted)
+	require.Equal(t, [][2]uint32{{100, 7}}, rules)
+	require.Equal(t, []string{firewall.Firewall}, firewalls)
+
+	// routes of running processes and routes failed to be deleted are kept to try next time
+	entries, err := readJournal()
+	require.NoError(t, err)
+	require.Equal(t, []*routeEntry{alive, failed}, entries)
+
+	// journal is removed when no route is left
+	require.NoError(t, saveJournal([]*routeEntry{stale}))
+	require.NoError(t, cleanStaleRoutes(deleteRoute, deletePolicyRules, deleteFirewall))
+	_, err = os.Stat(RouteJournal)
+	require.True(t, os.IsNotExist(err))
+}
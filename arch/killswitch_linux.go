@@ -0,0 +1,170 @@
+package arch
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	killSwitchTable = "nconnect_killswitch" // nftables table
+	killSwitchChain = "NCONNECT_KILLSWITCH" // iptables chain
+)
+
+var (
+	killSwitchLock sync.Mutex
+	killSwitchCmd  string // "nft" or "iptables" when kill switch is enabled
+)
+
+// EnableKillSwitch drops outgoing packets except those through loopback and the TUN
+// device, nConnect's own connections, and packets to allowed networks. nConnect's own
+// connections are allowed by firewall mark, and by the group set by SetExemptGroup, or by
+// its user if it's not root, because tuna connections are not marked. It uses nftables, or
+// iptables if nft is not available. Rules are recorded in route journal, so they are
+// removed by CleanStaleRoutes if nConnect doesn't exit cleanly.
+func EnableKillSwitch(tunName string, mark uint32, allow []*net.IPNet) error {
+	killSwitchLock.Lock()
+	defer killSwitchLock.Unlock()
+
+	journalFirewall(killSwitchTable, true)
+	if _, err := exec.LookPath("nft"); err == nil {
+		deleteNftKillSwitch()
+		if err = runCmdInput(nftKillSwitchRules(tunName, mark, allow), "nft", "-f", "-"); err != nil {
+			journalFirewall(killSwitchTable, false)
+			return fmt.Errorf("enable kill switch: %v", err)
+		}
+		killSwitchCmd = "nft"
+	} else {
+		for _, cmd := range []string{"iptables", "ip6tables"} {
+			deleteIptablesKillSwitch(cmd)
+			if err = addIptablesKillSwitch(cmd, tunName, mark, allow); err != nil {
+				deleteIptablesKillSwitch("iptables")
+				deleteIptablesKillSwitch("ip6tables")
+				journalFirewall(killSwitchTable, false)
+				return fmt.Errorf("enable kill switch: %v", err)
+			}
+		}
+		killSwitchCmd = "iptables"
+	}
+
+	log.Printf("Kill switch enabled by %s, only traffic through %s is allowed", killSwitchCmd, tunName)
+	return nil
+}
+
+// DisableKillSwitch removes rules added by EnableKillSwitch.
+func DisableKillSwitch() error {
+	killSwitchLock.Lock()
+	defer killSwitchLock.Unlock()
+
+	var err error
+	switch killSwitchCmd {
+	case "nft":
+		err = deleteNftKillSwitch()
+	case "iptables":
+		err = errors.Join(deleteIptablesKillSwitch("iptables"), deleteIptablesKillSwitch("ip6tables"))
+	default:
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("disable kill switch: %v", err)
+	}
+
+	killSwitchCmd = ""
+	journalFirewall(killSwitchTable, false)
+	log.Println("Kill switch disabled")
+	return nil
+}
+
+// deleteKillSwitch removes kill switch rules left by another process, which might be added
+// by either nft or iptables.
+func deleteKillSwitch() error {
+	var errs []error
+	if _, err := exec.LookPath("nft"); err == nil {
+		errs = append(errs, deleteNftKillSwitch())
+	}
+	errs = append(errs, deleteIptablesKillSwitch("iptables"), deleteIptablesKillSwitch("ip6tables"))
+	return errors.Join(errs...)
+}
+
+func nftKillSwitchRules(tunName string, mark uint32, allow []*net.IPNet) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "table inet %s {\n", killSwitchTable)
+	fmt.Fprintf(&b, "\tchain output {\n")
+	fmt.Fprintf(&b, "\t\ttype filter hook output priority 0; policy drop;\n")
+	fmt.Fprintf(&b, "\t\toifname \"lo\" accept\n")
+	fmt.Fprintf(&b, "\t\toifname %q accept\n", tunName)
+	fmt.Fprintf(&b, "\t\tmeta mark %d accept\n", mark)
+	if exemptGroup > 0 {
+		fmt.Fprintf(&b, "\t\tmeta skgid %d accept\n", exemptGroup)
+	}
+	if uid := os.Getuid(); uid != 0 {
+		fmt.Fprintf(&b, "\t\tmeta skuid %d accept\n", uid)
+	}
+	for _, ipNet := range allow {
+		if ipNet.IP.To4() != nil {
+			fmt.Fprintf(&b, "\t\tip daddr %s accept\n", ipNet)
+		} else {
+			fmt.Fprintf(&b, "\t\tip6 daddr %s accept\n", ipNet)
+		}
+	}
+	fmt.Fprintf(&b, "\t}\n}\n")
+	return b.String()
+}
+
+func deleteNftKillSwitch() error {
+	err := runCmd("nft", "delete", "table", "inet", killSwitchTable)
+	if err != nil && strings.Contains(err.Error(), "No such file or directory") {
+		return nil
+	}
+	return err
+}
+
+func addIptablesKillSwitch(cmd, tunName string, mark uint32, allow []*net.IPNet) error {
+	rules := [][]string{
+		{"-N", killSwitchChain},
+		{"-A", killSwitchChain, "-o", "lo", "-j", "RETURN"},
+		{"-A", killSwitchChain, "-o", tunName, "-j", "RETURN"},
+		{"-A", killSwitchChain, "-m", "mark", "--mark", strconv.FormatUint(uint64(mark), 10), "-j", "RETURN"},
+	}
+	if exemptGroup > 0 {
+		rules = append(rules, []string{"-A", killSwitchChain, "-m", "owner", "--gid-owner", strconv.FormatUint(uint64(exemptGroup), 10), "-j", "RETURN"})
+	}
+	if uid := os.Getuid(); uid != 0 {
+		rules = append(rules, []string{"-A", killSwitchChain, "-m", "owner", "--uid-owner", strconv.Itoa(uid), "-j", "RETURN"})
+	}
+	for _, ipNet := range allow {
+		if (ipNet.IP.To4() != nil) == (cmd == "iptables") {
+			rules = append(rules, []string{"-A", killSwitchChain, "-d", ipNet.String(), "-j", "RETURN"})
+		}
+	}
+	rules = append(rules,
+		[]string{"-A", killSwitchChain, "-j", "DROP"},
+		[]string{"-I", "OUTPUT", "-j", killSwitchChain},
+	)
+
+	for _, args := range rules {
+		if err := runCmd(cmd, args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteIptablesKillSwitch removes the kill switch chain, it's not an error if it doesn't exist.
+func deleteIptablesKillSwitch(cmd string) error {
+	if runCmd(cmd, "-n", "-L", killSwitchChain) != nil {
+		return nil
+	}
+	for runCmd(cmd, "-D", "OUTPUT", "-j", killSwitchChain) == nil {
+	}
+	if err := runCmd(cmd, "-F", killSwitchChain); err != nil {
+		return err
+	}
+	return runCmd(cmd, "-X", killSwitchChain)
+}
@@ -0,0 +1,17 @@
+// +build !linux
+
+package arch
+
+import (
+	"errors"
+	"net"
+)
+
+// EnableKillSwitch is only supported on Linux.
+func EnableKillSwitch(tunName string, mark uint32, allow []*net.IPNet) error {
+	return errors.New("kill switch is only supported on Linux")
+}
+
+func DisableKillSwitch() error {
+	return nil
+}
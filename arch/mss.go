@@ -0,0 +1,85 @@
+package arch
+
+import (
+	"encoding/binary"
+)
+
+const (
+	ipv4HeaderLen = 20
+	ipv6HeaderLen = 40
+	tcpHeaderLen  = 20
+
+	protocolTCP = 6
+	tcpFlagSYN  = 0x02
+	tcpOptEnd   = 0
+	tcpOptNOP   = 1
+	tcpOptMSS   = 2
+)
+
+// clampMSS lowers MSS option of TCP SYN packet so TCP segments fit in mtu. It returns true
+// if the packet is modified.
+func clampMSS(pkt []byte, mtu int) bool {
+	if len(pkt) == 0 {
+		return false
+	}
+
+	var tcp []byte
+	var mss int
+	switch pkt[0] >> 4 {
+	case 4:
+		ihl := int(pkt[0]&0x0f) * 4
+		// skip non-TCP packets and fragments other than the first one
+		if ihl < ipv4HeaderLen || len(pkt) < ihl+tcpHeaderLen || pkt[9] != protocolTCP ||
+			binary.BigEndian.Uint16(pkt[6:8])&0x1fff != 0 {
+			return false
+		}
+		tcp = pkt[ihl:]
+		mss = mtu - ipv4HeaderLen - tcpHeaderLen
+	case 6:
+		// packets with extension headers are not clamped
+		if len(pkt) < ipv6HeaderLen+tcpHeaderLen || pkt[6] != protocolTCP {
+			return false
+		}
+		tcp = pkt[ipv6HeaderLen:]
+		mss = mtu - ipv6HeaderLen - tcpHeaderLen
+	default:
+		return false
+	}
+
+	if tcp[13]&tcpFlagSYN == 0 || mss <= 0 {
+		return false
+	}
+	dataOffset := int(tcp[12]>>4) * 4
+	if dataOffset < tcpHeaderLen || dataOffset > len(tcp) {
+		return false
+	}
+
+	opts := tcp[tcpHeaderLen:dataOffset]
+	for i := 0; i < len(opts); {
+		switch opts[i] {
+		case tcpOptEnd:
+			return false
+		case tcpOptNOP:
+			i++
+			continue
+		}
+		if i+1 >= len(opts) || opts[i+1] < 2 || i+int(opts[i+1]) > len(opts) {
+			return false
+		}
+		if opts[i] == tcpOptMSS && opts[i+1] == 4 {
+			old := binary.BigEndian.Uint16(opts[i+2 : i+4])
+			if int(old) <= mss {
+				return false
+			}
+			binary.BigEndian.PutUint16(opts[i+2:i+4], uint16(mss))
+			// incremental checksum update, RFC 1624
+			sum := uint32(^binary.BigEndian.Uint16(tcp[16:18])) + uint32(^old) + uint32(mss)
+			sum = (sum & 0xffff) + (sum >> 16)
+			sum = (sum & 0xffff) + (sum >> 16)
+			binary.BigEndian.PutUint16(tcp[16:18], ^uint16(sum))
+			return true
+		}
+		i += int(opts[i+1])
+	}
+	return false
+}
@@ -0,0 +1,67 @@
+package arch
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func tcpChecksum(src, dst, tcp []byte) uint16 {
+	var sum uint32
+	add := func(b []byte) {
+		for i := 0; i+1 < len(b); i += 2 {
+			sum += uint32(binary.BigEndian.Uint16(b[i:]))
+		}
+		if len(b)%2 == 1 {
+			sum += uint32(b[len(b)-1]) << 8
+		}
+	}
+	add(src)
+	add(dst)
+	sum += protocolTCP + uint32(len(tcp))
+	add(tcp)
+	for sum > 0xffff {
+		sum = (sum & 0xffff) + (sum >> 16)
+	}
+	return ^uint16(sum)
+}
+
+func synPacket(mss uint16) []byte {
+	pkt := make([]byte, ipv4HeaderLen+tcpHeaderLen+8)
+	pkt[0] = 0x45
+	pkt[9] = protocolTCP
+	copy(pkt[12:16], []byte{10, 0, 86, 2})
+	copy(pkt[16:20], []byte{10, 0, 86, 1})
+	tcp := pkt[ipv4HeaderLen:]
+	tcp[12] = byte(tcpHeaderLen+8) / 4 << 4
+	tcp[13] = tcpFlagSYN
+	copy(tcp[tcpHeaderLen:], []byte{tcpOptNOP, tcpOptNOP, tcpOptMSS, 4, 0, 0, tcpOptNOP, tcpOptEnd})
+	binary.BigEndian.PutUint16(tcp[tcpHeaderLen+4:], mss)
+	binary.BigEndian.PutUint16(tcp[16:18], tcpChecksum(pkt[12:16], pkt[16:20], tcp))
+	return pkt
+}
+
+func TestClampMSS(t *testing.T) {
+	pkt := synPacket(1460)
+	if !clampMSS(pkt, 1400) {
+		t.Fatal("SYN packet is not clamped")
+	}
+	tcp := pkt[ipv4HeaderLen:]
+	if mss := binary.BigEndian.Uint16(tcp[tcpHeaderLen+4:]); mss != 1360 {
+		t.Fatalf("expect MSS 1360, got %d", mss)
+	}
+	check := binary.BigEndian.Uint16(tcp[16:18])
+	tcp[16], tcp[17] = 0, 0
+	if expected := tcpChecksum(pkt[12:16], pkt[16:20], tcp); check != expected {
+		t.Fatalf("expect checksum %x, got %x", expected, check)
+	}
+
+	if clampMSS(synPacket(1200), 1400) {
+		t.Fatal("smaller MSS should not be changed")
+	}
+
+	pkt = synPacket(1460)
+	pkt[ipv4HeaderLen+13] = 0x10 // ACK
+	if clampMSS(pkt, 1400) {
+		t.Fatal("non-SYN packet should not be changed")
+	}
+}
@@ -0,0 +1,269 @@
+package arch
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"os"
+	"sync/atomic"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// A minimal rtnetlink client, so routes and addresses can be managed without iproute2.
+
+var (
+	nativeEndian binary.ByteOrder
+	netlinkSeq   uint32
+)
+
+func init() {
+	i := uint16(1)
+	if *(*byte)(unsafe.Pointer(&i)) == 1 {
+		nativeEndian = binary.LittleEndian
+	} else {
+		nativeEndian = binary.BigEndian
+	}
+}
+
+type netlinkMsg struct {
+	typ   uint16
+	flags uint16
+	data  []byte
+}
+
+func newNetlinkMsg(typ, flags uint16, header []byte) *netlinkMsg {
+	return &netlinkMsg{typ: typ, flags: flags, data: header}
+}
+
+// addAttr appends a route attribute with 4 bytes alignment.
+func (m *netlinkMsg) addAttr(typ uint16, value []byte) {
+	l := unix.SizeofRtAttr + len(value)
+	b := make([]byte, rtaAlign(l))
+	nativeEndian.PutUint16(b[0:2], uint16(l))
+	nativeEndian.PutUint16(b[2:4], typ)
+	copy(b[unix.SizeofRtAttr:], value)
+	m.data = append(m.data, b...)
+}
+
+func (m *netlinkMsg) addUint32Attr(typ uint16, value uint32) {
+	b := make([]byte, 4)
+	nativeEndian.PutUint32(b, value)
+	m.addAttr(typ, b)
+}
+
+func rtaAlign(l int) int {
+	return (l + unix.RTA_ALIGNTO - 1) & ^(unix.RTA_ALIGNTO - 1)
+}
+
+// do sends the message and waits for kernel acknowledgement. Errors returned by kernel
+// are *os.SyscallError wrapping syscall.Errno, so callers can check them by errors.Is.
+func (m *netlinkMsg) do(op string) error {
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW|unix.SOCK_CLOEXEC, unix.NETLINK_ROUTE)
+	if err != nil {
+		return os.NewSyscallError("netlink socket", err)
+	}
+	defer unix.Close(fd)
+
+	sa := &unix.SockaddrNetlink{Family: unix.AF_NETLINK}
+	if err = unix.Bind(fd, sa); err != nil {
+		return os.NewSyscallError("netlink bind", err)
+	}
+
+	seq := atomic.AddUint32(&netlinkSeq, 1)
+	b := make([]byte, unix.SizeofNlMsghdr+len(m.data))
+	nativeEndian.PutUint32(b[0:4], uint32(len(b)))
+	nativeEndian.PutUint16(b[4:6], m.typ)
+	nativeEndian.PutUint16(b[6:8], m.flags|unix.NLM_F_REQUEST|unix.NLM_F_ACK)
+	nativeEndian.PutUint32(b[8:12], seq)
+	copy(b[unix.SizeofNlMsghdr:], m.data)
+
+	if err = unix.Sendto(fd, b, 0, sa); err != nil {
+		return os.NewSyscallError("netlink send", err)
+	}
+
+	buf := make([]byte, os.Getpagesize())
+	for {
+		n, _, err := unix.Recvfrom(fd, buf, 0)
+		if err != nil {
+			return os.NewSyscallError("netlink receive", err)
+		}
+		msgs, err := syscall.ParseNetlinkMessage(buf[:n])
+		if err != nil {
+			return os.NewSyscallError("netlink parse", err)
+		}
+		for _, msg := range msgs {
+			if msg.Header.Seq != seq || msg.Header.Type != unix.NLMSG_ERROR {
+				continue
+			}
+			if len(msg.Data) < 4 {
+				return errors.New("netlink: short error message")
+			}
+			errno := -int32(nativeEndian.Uint32(msg.Data[0:4]))
+			if errno == 0 {
+				return nil
+			}
+			return os.NewSyscallError(op, syscall.Errno(errno))
+		}
+	}
+}
+
+func ipFamily(ip net.IP) (uint8, net.IP) {
+	if ip4 := ip.To4(); ip4 != nil {
+		return unix.AF_INET, ip4
+	}
+	return unix.AF_INET6, ip.To16()
+}
+
+func rtMsg(family, dstLen uint8, table uint32, scope, typ uint8) []byte {
+	b := make([]byte, unix.SizeofRtMsg)
+	b[0] = family
+	b[1] = dstLen
+	if table < 256 {
+		b[4] = uint8(table)
+	} else {
+		b[4] = unix.RT_TABLE_UNSPEC
+	}
+	b[5] = unix.RTPROT_STATIC
+	b[6] = scope
+	b[7] = typ
+	return b
+}
+
+func newRouteMsg(typ, flags uint16, dest *net.IPNet, gateway net.IP, ifIndex int, table uint32) *netlinkMsg {
+	family, dst := ipFamily(dest.IP)
+	ones, _ := dest.Mask.Size()
+	m := newNetlinkMsg(typ, flags, rtMsg(family, uint8(ones), table, unix.RT_SCOPE_UNIVERSE, unix.RTN_UNICAST))
+	m.addAttr(unix.RTA_DST, dst)
+	if gateway != nil {
+		_, gw := ipFamily(gateway)
+		m.addAttr(unix.RTA_GATEWAY, gw)
+	}
+	if ifIndex > 0 {
+		m.addUint32Attr(unix.RTA_OIF, uint32(ifIndex))
+	}
+	if table >= 256 {
+		m.addUint32Attr(unix.RTA_TABLE, table)
+	}
+	return m
+}
+
+// netlinkRouteReplace adds the route, or replaces the existing route to the same destination.
+func netlinkRouteReplace(dest *net.IPNet, gateway net.IP, ifIndex int, table uint32) error {
+	return newRouteMsg(unix.RTM_NEWROUTE, unix.NLM_F_CREATE|unix.NLM_F_REPLACE, dest, gateway, ifIndex, table).do("add route")
+}
+
+// netlinkRouteDelete deletes the route, it's not an error if the route doesn't exist.
+func netlinkRouteDelete(dest *net.IPNet, gateway net.IP, ifIndex int, table uint32) error {
+	err := newRouteMsg(unix.RTM_DELROUTE, 0, dest, gateway, ifIndex, table).do("delete route")
+	if errors.Is(err, syscall.ESRCH) {
+		return nil
+	}
+	return err
+}
+
+func newLocalRouteMsg(typ, flags uint16, table uint32) (*netlinkMsg, error) {
+	lo, err := net.InterfaceByName("lo")
+	if err != nil {
+		return nil, err
+	}
+	m := newNetlinkMsg(typ, flags, rtMsg(unix.AF_INET, 0, table, unix.RT_SCOPE_HOST, unix.RTN_LOCAL))
+	m.addUint32Attr(unix.RTA_OIF, uint32(lo.Index))
+	if table >= 256 {
+		m.addUint32Attr(unix.RTA_TABLE, table)
+	}
+	return m, nil
+}
+
+// netlinkLocalRouteReplace routes all IPv4 addresses to loopback as local addresses in the table.
+func netlinkLocalRouteReplace(table uint32) error {
+	m, err := newLocalRouteMsg(unix.RTM_NEWROUTE, unix.NLM_F_CREATE|unix.NLM_F_REPLACE, table)
+	if err != nil {
+		return err
+	}
+	return m.do("add local route")
+}
+
+// netlinkLocalRouteDelete deletes the route added by netlinkLocalRouteReplace, it's not an
+// error if the route doesn't exist.
+func netlinkLocalRouteDelete(table uint32) error {
+	m, err := newLocalRouteMsg(unix.RTM_DELROUTE, 0, table)
+	if err != nil {
+		return err
+	}
+	err = m.do("delete local route")
+	if errors.Is(err, syscall.ESRCH) {
+		return nil
+	}
+	return err
+}
+
+// netlinkAddrReplace sets the address of the interface, it's not an error if it's set already.
+func netlinkAddrReplace(ifIndex int, ip net.IP, prefixLen int) error {
+	family, addr := ipFamily(ip)
+	b := make([]byte, unix.SizeofIfAddrmsg)
+	b[0] = family
+	b[1] = uint8(prefixLen)
+	b[3] = unix.RT_SCOPE_UNIVERSE
+	nativeEndian.PutUint32(b[4:8], uint32(ifIndex))
+
+	m := newNetlinkMsg(unix.RTM_NEWADDR, unix.NLM_F_CREATE|unix.NLM_F_REPLACE, b)
+	m.addAttr(unix.IFA_LOCAL, addr)
+	m.addAttr(unix.IFA_ADDRESS, addr)
+	return m.do("set address")
+}
+
+// netlinkLinkUp brings the interface up.
+func netlinkLinkUp(ifIndex int) error {
+	b := make([]byte, unix.SizeofIfInfomsg)
+	b[0] = unix.AF_UNSPEC
+	nativeEndian.PutUint32(b[4:8], uint32(ifIndex))
+	nativeEndian.PutUint32(b[8:12], unix.IFF_UP)
+	nativeEndian.PutUint32(b[12:16], unix.IFF_UP)
+	return newNetlinkMsg(unix.RTM_NEWLINK, 0, b).do("set link up")
+}
+
+// netlinkLinkSetMTU sets MTU of the interface.
+func netlinkLinkSetMTU(ifIndex, mtu int) error {
+	b := make([]byte, unix.SizeofIfInfomsg)
+	b[0] = unix.AF_UNSPEC
+	nativeEndian.PutUint32(b[4:8], uint32(ifIndex))
+	m := newNetlinkMsg(unix.RTM_NEWLINK, 0, b)
+	m.addUint32Attr(unix.IFLA_MTU, uint32(mtu))
+	return m.do("set MTU")
+}
+
+func newRuleMsg(typ, flags uint16, family uint8, table, mark, priority uint32) *netlinkMsg {
+	m := newNetlinkMsg(typ, flags, rtMsg(family, 0, table, 0, unix.FR_ACT_TO_TBL))
+	// fib rule header has no protocol, use 0 instead of static
+	m.data[5] = 0
+	m.addUint32Attr(unix.FRA_TABLE, table)
+	if mark > 0 {
+		m.addUint32Attr(unix.FRA_FWMARK, mark)
+	}
+	if priority > 0 {
+		m.addUint32Attr(unix.FRA_PRIORITY, priority)
+	}
+	return m
+}
+
+// netlinkRuleAdd adds a policy rule looking up table for packets with the firewall mark,
+// or all packets if mark is 0. It's not an error if the same rule exists.
+func netlinkRuleAdd(family uint8, table, mark, priority uint32) error {
+	err := newRuleMsg(unix.RTM_NEWRULE, unix.NLM_F_CREATE|unix.NLM_F_EXCL, family, table, mark, priority).do("add rule")
+	if errors.Is(err, syscall.EEXIST) {
+		return nil
+	}
+	return err
+}
+
+// netlinkRuleDelete deletes the policy rule, it's not an error if the rule doesn't exist.
+func netlinkRuleDelete(family uint8, table, mark, priority uint32) error {
+	err := newRuleMsg(unix.RTM_DELRULE, 0, family, table, mark, priority).do("delete rule")
+	if errors.Is(err, syscall.ENOENT) {
+		return nil
+	}
+	return err
+}
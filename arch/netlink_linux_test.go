@@ -0,0 +1,69 @@
+package arch
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+// Expected encodings are laid out by hand from struct rtmsg (fib_rule_hdr for rules) followed
+// by struct rtattr attributes, in little endian.
+
+func requireNetlinkData(t *testing.T, expected string, m *netlinkMsg) {
+	if nativeEndian != binary.LittleEndian {
+		t.Skip("expected encodings are little endian")
+	}
+	require.Equal(t, strings.ReplaceAll(expected, " ", ""), hex.EncodeToString(m.data))
+}
+
+// go test -v -run=TestNetlinkAttr
+func TestNetlinkAttr(t *testing.T) {
+	m := newNetlinkMsg(unix.RTM_NEWROUTE, 0, nil)
+	m.addAttr(1, []byte{1, 2, 3, 4, 5})
+	m.addUint32Attr(2, 6)
+	// length of attr doesn't count the padding, the next attr starts aligned
+	requireNetlinkData(t, "0900 0100 0102030405 000000 0800 0200 06000000", m)
+}
+
+// go test -v -run=TestNetlinkRouteMsg
+func TestNetlinkRouteMsg(t *testing.T) {
+	// ip route replace 10.0.0.0/8 via 192.168.1.1 dev <index 2> table 1000
+	_, dest, _ := net.ParseCIDR("10.0.0.0/8")
+	m := newRouteMsg(unix.RTM_NEWROUTE, unix.NLM_F_CREATE|unix.NLM_F_REPLACE, dest, net.ParseIP("192.168.1.1"), 2, 1000)
+	require.Equal(t, uint16(unix.RTM_NEWROUTE), m.typ)
+	require.Equal(t, uint16(unix.NLM_F_CREATE|unix.NLM_F_REPLACE), m.flags)
+	requireNetlinkData(t, "02 08 00 00 00 04 00 01 00000000"+
+		"0800 0100 0a000000"+
+		"0800 0500 c0a80101"+
+		"0800 0400 02000000"+
+		"0800 0f00 e8030000", m)
+
+	// ip route del fd00::/64 dev <index 3>, main table fits in header
+	_, dest, _ = net.ParseCIDR("fd00::/64")
+	m = newRouteMsg(unix.RTM_DELROUTE, 0, dest, nil, 3, unix.RT_TABLE_MAIN)
+	require.Equal(t, uint16(unix.RTM_DELROUTE), m.typ)
+	requireNetlinkData(t, "0a 40 00 00 fe 04 00 01 00000000"+
+		"1400 0100 fd000000000000000000000000000000"+
+		"0800 0400 03000000", m)
+}
+
+// go test -v -run=TestNetlinkRuleMsg
+func TestNetlinkRuleMsg(t *testing.T) {
+	// ip rule add fwmark 0x1234 lookup 100 priority 1000
+	m := newRuleMsg(unix.RTM_NEWRULE, unix.NLM_F_CREATE|unix.NLM_F_EXCL, unix.AF_INET, 100, 0x1234, 1000)
+	require.Equal(t, uint16(unix.RTM_NEWRULE), m.typ)
+	requireNetlinkData(t, "02 00 00 00 64 00 00 01 00000000"+
+		"0800 0f00 64000000"+
+		"0800 0a00 34120000"+
+		"0800 0600 e8030000", m)
+
+	// ip -6 rule del lookup 1000, table doesn't fit in header
+	m = newRuleMsg(unix.RTM_DELRULE, 0, unix.AF_INET6, 1000, 0, 0)
+	requireNetlinkData(t, "0a 00 00 00 00 00 00 01 00000000"+
+		"0800 0f00 e8030000", m)
+}
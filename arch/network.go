@@ -1,78 +1,265 @@
 package arch
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net"
 	"os"
+	"sync"
 	"time"
 
 	"github.com/eycorsican/go-tun2socks/core"
 	"github.com/eycorsican/go-tun2socks/proxy/socks"
-	"github.com/nknorg/nconnect/util"
 )
 
 const (
-	mtu = 1500
+	DefaultTunMTU        = 1500
+	DefaultTunUDPTimeout = 30 * time.Second
 )
 
-func OpenTun(tunName, ip, gateway, mask, dns, socksAddr string) error {
-	tunDevice, err := openTunDevice(tunName, ip, gateway, mask, []string{dns}, false)
-	if err != nil {
-		return fmt.Errorf("failed to open TUN device: %v", err)
+// TunConfig is the config of TUN device and the userspace network stack on it.
+type TunConfig struct {
+	Name       string
+	Addr       string
+	Gateway    string
+	Mask       string
+	DNS        []string      // DNS servers, only used on Windows
+	MTU        int           // DefaultTunMTU if not positive, TCP MSS is clamped to fit it
+	UDPTimeout time.Duration // DefaultTunUDPTimeout if not positive
+	SocksAddr  string        // SOCKS proxy which TCP and UDP sessions are sent to
+
+	// If Dial and ListenPacket are set, TCP and UDP sessions are sent through them instead
+	// of the SOCKS proxy. Source and target are addresses of the session.
+	Dial         func(source, target string) (net.Conn, error)
+	ListenPacket func(source, target string) (net.PacketConn, error)
+
+	// If Intercept is set, it's called with every packet read from the TUN device, packets
+	// it returns true for are not passed to the network stack. The packet buffer is reused
+	// after it returns.
+	Intercept func(pkt []byte) bool
+
+	// If ICMPEcho is set, IPv4 ICMP echo requests on the TUN device are sent by it, and the
+	// echo reply message it returns is written back to the TUN device.
+	ICMPEcho func(src, dst net.IP, msg []byte) ([]byte, error)
+}
+
+// openedTun is the opened TUN device, packets can be written to it by WriteTunPacket.
+var openedTun struct {
+	sync.RWMutex
+	device io.Writer
+	mtu    int
+}
+
+// WriteTunPacket writes an IP packet to the TUN device opened by OpenTun.
+func WriteTunPacket(pkt []byte) error {
+	openedTun.RLock()
+	defer openedTun.RUnlock()
+	if openedTun.device == nil {
+		return errors.New("TUN device is not opened")
 	}
+	clampMSS(pkt, openedTun.mtu)
+	_, err := openedTun.device.Write(pkt)
+	return err
+}
 
-	core.RegisterOutputFn(tunDevice.Write)
+// OpenTun opens the TUN device and sends TCP and UDP sessions on it to the SOCKS proxy,
+// or directly through Dial and ListenPacket if they are set. If reading the TUN device
+// fails, the device and network stack are closed.
+func OpenTun(conf *TunConfig) error {
+	mtu := conf.MTU
+	if mtu <= 0 {
+		mtu = DefaultTunMTU
+	}
+	tcpHandler, udpHandler, err := NewTunHandlers(conf)
+	if err != nil {
+		return err
+	}
 
-	proxyAddr, err := net.ResolveTCPAddr("tcp", socksAddr)
+	tunDevice, err := openTunDevice(conf.Name, conf.Addr, conf.Gateway, conf.Mask, conf.DNS, false)
 	if err != nil {
-		return fmt.Errorf("invalid proxy server address %v err: %v", socksAddr, err)
+		return fmt.Errorf("failed to open TUN device: %v", err)
+	}
+	if err = setTunMTU(conf.Name, mtu); err != nil {
+		log.Printf("Set TUN device MTU to %d error: %v", mtu, err)
 	}
-	proxyHost := proxyAddr.IP.String()
-	proxyPort := uint16(proxyAddr.Port)
 
-	core.RegisterTCPConnHandler(socks.NewTCPHandler(proxyHost, proxyPort))
-	core.RegisterUDPConnHandler(socks.NewUDPHandler(proxyHost, proxyPort, 30*time.Second))
+	core.RegisterOutputFn(func(b []byte) (int, error) {
+		clampMSS(b, mtu)
+		return tunDevice.Write(b)
+	})
+	core.RegisterTCPConnHandler(tcpHandler)
+	core.RegisterUDPConnHandler(udpHandler)
 
 	lwipWriter := core.NewLWIPStack()
 
+	openedTun.Lock()
+	openedTun.device = tunDevice
+	openedTun.mtu = mtu
+	openedTun.Unlock()
+
 	go func() {
-		_, err := io.CopyBuffer(lwipWriter, tunDevice, make([]byte, mtu))
-		if err != nil {
-			log.Fatalf("Failed to write data to network stack: %v", err)
+		defer func() {
+			openedTun.Lock()
+			openedTun.device = nil
+			openedTun.Unlock()
+			lwipWriter.Close()
+			tunDevice.Close()
+		}()
+
+		buf := make([]byte, mtu)
+		for {
+			n, err := tunDevice.Read(buf)
+			if err != nil {
+				log.Printf("Read TUN device error: %v, TUN device is closed", err)
+				return
+			}
+			clampMSS(buf[:n], mtu)
+			if conf.Intercept != nil && conf.Intercept(buf[:n]) {
+				continue
+			}
+			if conf.ICMPEcho != nil {
+				if _, _, _, ok := parseICMPEcho(buf[:n]); ok {
+					goICMPEcho(buf[:n], conf.ICMPEcho)
+					continue
+				}
+			}
+			// packets the network stack can't handle are dropped
+			lwipWriter.Write(buf[:n])
 		}
 	}()
 
 	return nil
 }
 
+// NewTunHandlers returns the handlers OpenTun passes TCP and UDP sessions of the network
+// stack to, sending them to the SOCKS proxy, or directly through Dial and ListenPacket if
+// they are set.
+func NewTunHandlers(conf *TunConfig) (core.TCPConnHandler, core.UDPConnHandler, error) {
+	udpTimeout := conf.UDPTimeout
+	if udpTimeout <= 0 {
+		udpTimeout = DefaultTunUDPTimeout
+	}
+
+	if conf.Dial != nil && conf.ListenPacket != nil {
+		return &directTCPHandler{dial: conf.Dial}, newDirectUDPHandler(conf.ListenPacket, udpTimeout), nil
+	}
+
+	proxyAddr, err := net.ResolveTCPAddr("tcp", conf.SocksAddr)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid proxy server address %v err: %v", conf.SocksAddr, err)
+	}
+	proxyHost := proxyAddr.IP.String()
+	proxyPort := uint16(proxyAddr.Port)
+	return socks.NewTCPHandler(proxyHost, proxyPort), socks.NewUDPHandler(proxyHost, proxyPort, udpTimeout), nil
+}
+
+// RouteError records a failed route operation.
+type RouteError struct {
+	Op      string // "add" or "delete"
+	Dest    *net.IPNet
+	Gateway string
+	Dev     string
+	Err     error
+}
+
+func (e *RouteError) Error() string {
+	return fmt.Sprintf("%s route %v via %s dev %s: %v", e.Op, e.Dest, e.Gateway, e.Dev, e.Err)
+}
+
+func (e *RouteError) Unwrap() error {
+	return e.Err
+}
+
+var (
+	routesLock sync.Mutex
+	routes     = make(map[string]*routeEntry) // routes installed by this process
+	routeTable uint32                         // routing table set by SetRoutingPolicy, 0 for main table
+	routeMark  uint32                         // firewall mark set by SetRoutingPolicy
+)
+
+// SetVPNRoutes routes cidrs to gateway through the TUN device. Adding a route which
+// exists already is not an error. Installed routes are recorded in RouteJournal. It
+// returns routes installed before any error, and the error is a *RouteError.
 func SetVPNRoutes(tunName, gateway string, cidrs []*net.IPNet) ([]*net.IPNet, error) {
+	routesLock.Lock()
+	defer routesLock.Unlock()
+	defer writeJournal()
+
+	installed := make([]*net.IPNet, 0, len(cidrs))
 	for _, dest := range cidrs {
 		log.Printf("Adding route %s by %s", dest, gateway)
-		out, err := addRouteCmd(dest, gateway, tunName)
-		if len(out) > 0 {
-			os.Stdout.Write(out)
+		if err := addRoute(dest, gateway, tunName, routeTable); err != nil {
+			return installed, &RouteError{Op: "add", Dest: dest, Gateway: gateway, Dev: tunName, Err: err}
 		}
-		if err != nil {
-			os.Stdout.Write([]byte(util.ParseExecError(err)))
-			os.Exit(1)
+		routes[routeKey(dest, tunName)] = &routeEntry{
+			Dest:    dest.String(),
+			Gateway: gateway,
+			Dev:     tunName,
+			Table:   routeTable,
+			Mark:    routeMark,
+			Pid:     os.Getpid(),
 		}
+		installed = append(installed, dest)
 	}
 
-	return cidrs, nil
+	return installed, nil
 }
 
+// RemoveVPNRoutes removes routes added by SetVPNRoutes. Removing a route which doesn't
+// exist is not an error. It tries all routes and returns all errors joined.
 func RemoveVPNRoutes(tunName, gateway string, cidrs []*net.IPNet) error {
+	routesLock.Lock()
+	defer routesLock.Unlock()
+	defer writeJournal()
+
+	var errs []error
 	for _, dest := range cidrs {
 		log.Printf("Deleting route %s", dest)
-		out, err := deleteRouteCmd(dest, gateway, tunName)
-		if len(out) > 0 {
-			os.Stdout.Write(out)
+		if err := deleteRoute(dest, gateway, tunName, routeTable); err != nil {
+			errs = append(errs, &RouteError{Op: "delete", Dest: dest, Gateway: gateway, Dev: tunName, Err: err})
+			continue
 		}
+		delete(routes, routeKey(dest, tunName))
+	}
+	return errors.Join(errs...)
+}
+
+// RemoveInstalledRoutes removes all routes installed by this process, it should be
+// called before exit.
+func RemoveInstalledRoutes() error {
+	routesLock.Lock()
+	defer routesLock.Unlock()
+	defer writeJournal()
+
+	var errs []error
+	for key, e := range routes {
+		_, dest, err := net.ParseCIDR(e.Dest)
 		if err != nil {
-			os.Stdout.Write([]byte(util.ParseExecError(err)))
+			delete(routes, key)
+			continue
+		}
+		log.Printf("Deleting route %s", dest)
+		if err = deleteRoute(dest, e.Gateway, e.Dev, e.Table); err != nil {
+			errs = append(errs, &RouteError{Op: "delete", Dest: dest, Gateway: e.Gateway, Dev: e.Dev, Err: err})
+			continue
 		}
+		delete(routes, key)
 	}
-	return nil
+	return errors.Join(errs...)
+}
+
+// InstalledRoutes returns routes installed by SetVPNRoutes and not removed yet.
+func InstalledRoutes() []*net.IPNet {
+	routesLock.Lock()
+	defer routesLock.Unlock()
+
+	res := make([]*net.IPNet, 0, len(routes))
+	for _, e := range routes {
+		if _, dest, err := net.ParseCIDR(e.Dest); err == nil {
+			res = append(res, dest)
+		}
+	}
+	return res
 }
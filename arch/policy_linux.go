@@ -0,0 +1,136 @@
+package arch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// Policy rules are added before the main table rule (32766). Packets with nConnect's
+	// firewall mark look up main table first, so nConnect's own connections never go
+	// into the tunnel, then other packets look up nConnect's table.
+	markRulePriority  = 9000
+	tableRulePriority = 9001
+)
+
+var (
+	ruleFamilies = []uint8{unix.AF_INET, unix.AF_INET6}
+	exemptGroup  uint32 // group of nConnect's own connections set by SetExemptGroup, 0 if not set
+)
+
+// SetExemptGroup switches the process to group gid if it runs as root, so firewall rules of
+// kill switch and transparent proxy let all its connections through by the group, including
+// tuna connections which can't be marked. The process keeps its user and privileges. If it
+// doesn't run as root, connections are let through by its user instead.
+func SetExemptGroup(gid uint32) error {
+	if os.Getuid() != 0 {
+		return nil
+	}
+	if gid == 0 {
+		return errors.New("exempt group should not be 0")
+	}
+	if err := syscall.Setgid(int(gid)); err != nil {
+		return os.NewSyscallError("setgid", err)
+	}
+	exemptGroup = gid
+	log.Printf("Using group %d for nConnect's own connections", gid)
+	return nil
+}
+
+// deleteFirewall removes firewall rules recorded in route journal by name.
+func deleteFirewall(name string) error {
+	switch name {
+	case killSwitchTable:
+		return deleteKillSwitch()
+	case tproxyTable:
+		return deleteTproxy()
+	}
+	return nil
+}
+
+// SetRoutingPolicy makes SetVPNRoutes add routes to table instead of main table, and
+// adds policy rules to look up the table. Connections dialed by MarkedDialContext carry
+// firewall mark and bypass the table.
+func SetRoutingPolicy(table, mark uint32) error {
+	if table == 0 || table == unix.RT_TABLE_MAIN || table == unix.RT_TABLE_LOCAL || table == unix.RT_TABLE_DEFAULT {
+		return fmt.Errorf("invalid routing table %d", table)
+	}
+	if mark == 0 {
+		return errors.New("firewall mark should not be 0")
+	}
+
+	routesLock.Lock()
+	defer routesLock.Unlock()
+
+	for _, family := range ruleFamilies {
+		err := netlinkRuleAdd(family, unix.RT_TABLE_MAIN, mark, markRulePriority)
+		if err == nil {
+			err = netlinkRuleAdd(family, table, 0, tableRulePriority)
+		}
+		if err != nil {
+			if family == unix.AF_INET6 && errors.Is(err, syscall.EAFNOSUPPORT) {
+				continue
+			}
+			deletePolicyRules(table, mark)
+			return err
+		}
+	}
+
+	log.Printf("Using routing table %d, firewall mark %d", table, mark)
+	routeTable, routeMark = table, mark
+	return nil
+}
+
+// RemoveRoutingPolicy removes policy rules added by SetRoutingPolicy.
+func RemoveRoutingPolicy() error {
+	routesLock.Lock()
+	defer routesLock.Unlock()
+
+	if routeTable == 0 {
+		return nil
+	}
+	err := deletePolicyRules(routeTable, routeMark)
+	if err == nil {
+		routeTable, routeMark = 0, 0
+	}
+	return err
+}
+
+func deletePolicyRules(table, mark uint32) error {
+	var errs []error
+	for _, family := range ruleFamilies {
+		if err := netlinkRuleDelete(family, unix.RT_TABLE_MAIN, mark, markRulePriority); err != nil && !errors.Is(err, syscall.EAFNOSUPPORT) {
+			errs = append(errs, err)
+		}
+		if err := netlinkRuleDelete(family, table, 0, tableRulePriority); err != nil && !errors.Is(err, syscall.EAFNOSUPPORT) {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// MarkedDialContext returns a dial function setting firewall mark on connections,
+// including DNS queries to resolve the address.
+func MarkedDialContext(mark uint32) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	d := &net.Dialer{
+		Control: func(network, address string, c syscall.RawConn) error {
+			var err error
+			cerr := c.Control(func(fd uintptr) {
+				err = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_MARK, int(mark))
+			})
+			if cerr != nil {
+				return cerr
+			}
+			return err
+		},
+	}
+	d.Resolver = &net.Resolver{PreferGo: true, Dial: (&net.Dialer{Control: d.Control}).DialContext}
+	return d.DialContext
+}
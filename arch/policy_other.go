@@ -0,0 +1,36 @@
+// +build !linux
+
+package arch
+
+import (
+	"context"
+	"errors"
+	"net"
+)
+
+// SetRoutingPolicy is only supported on Linux.
+func SetRoutingPolicy(table, mark uint32) error {
+	return errors.New("routing table is only supported on Linux")
+}
+
+func RemoveRoutingPolicy() error {
+	return nil
+}
+
+func deletePolicyRules(table, mark uint32) error {
+	return nil
+}
+
+// SetExemptGroup does nothing, firewall rules are only supported on Linux.
+func SetExemptGroup(gid uint32) error {
+	return nil
+}
+
+func deleteFirewall(name string) error {
+	return nil
+}
+
+// MarkedDialContext returns a plain dial function, firewall mark is only supported on Linux.
+func MarkedDialContext(mark uint32) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return (&net.Dialer{}).DialContext
+}
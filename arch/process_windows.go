@@ -0,0 +1,14 @@
+package arch
+
+import (
+	"os"
+)
+
+func processAlive(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	p.Release()
+	return true
+}
@@ -2,17 +2,16 @@ package arch
 
 import (
 	"net"
-	"os/exec"
 )
 
-func addRouteCmd(dest *net.IPNet, gateway, devName string) ([]byte, error) {
-	b, err := exec.Command("route", "-n", "add", "-net", dest.String(), gateway).Output()
+func addRoute(dest *net.IPNet, gateway, devName string, table uint32) error {
+	err := runCmd("route", "-n", "add", "-net", dest.String(), gateway)
 	if err == nil {
-		return b, nil
+		return nil
 	}
-	return exec.Command("route", "-n", "change", "-net", dest.String(), gateway).Output()
+	return runCmd("route", "-n", "change", "-net", dest.String(), gateway)
 }
 
-func deleteRouteCmd(dest *net.IPNet, gateway, devName string) ([]byte, error) {
-	return exec.Command("route", "-n", "delete", "-net", dest.String(), gateway).Output()
+func deleteRoute(dest *net.IPNet, gateway, devName string, table uint32) error {
+	return runCmd("route", "-n", "delete", "-net", dest.String(), gateway)
 }
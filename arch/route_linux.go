@@ -1,30 +1,36 @@
 package arch
 
 import (
+	"fmt"
 	"net"
-	"os/exec"
+
+	"golang.org/x/sys/unix"
 )
 
-func addRouteCmd(dest *net.IPNet, gateway, devName string) ([]byte, error) {
-	out, err := exec.Command("ip", "route", "add", dest.String(), "via", gateway, "dev", devName).Output()
-	if err == nil {
-		return out, nil
+func addRoute(dest *net.IPNet, gateway, devName string, table uint32) error {
+	gw := net.ParseIP(gateway)
+	if gw == nil {
+		return fmt.Errorf("invalid gateway %q", gateway)
 	}
-	out, err = exec.Command("ip", "route", "change", dest.String(), "via", gateway, "dev", devName).Output()
-	if err == nil {
-		return out, nil
+	iface, err := net.InterfaceByName(devName)
+	if err != nil {
+		return err
 	}
-	out, err = exec.Command("route", "-n", "add", dest.String(), "gw", gateway).Output()
-	if err == nil {
-		return out, nil
+	return netlinkRouteReplace(dest, gw, iface.Index, routeTableOrMain(table))
+}
+
+func routeTableOrMain(table uint32) uint32 {
+	if table == 0 {
+		return unix.RT_TABLE_MAIN
 	}
-	return exec.Command("route", "-n", "change", dest.String(), "gw", gateway).Output()
+	return table
 }
 
-func deleteRouteCmd(dest *net.IPNet, gateway, devName string) ([]byte, error) {
-	out, err := exec.Command("ip", "route", "del", dest.String(), "via", gateway, "dev", devName).Output()
-	if err == nil {
-		return out, nil
+func deleteRoute(dest *net.IPNet, gateway, devName string, table uint32) error {
+	// route is removed by kernel together with the device, so device is optional here
+	ifIndex := 0
+	if iface, err := net.InterfaceByName(devName); err == nil {
+		ifIndex = iface.Index
 	}
-	return exec.Command("route", "-n", "del", dest.String(), "gw", gateway).Output()
+	return netlinkRouteDelete(dest, net.ParseIP(gateway), ifIndex, routeTableOrMain(table))
 }
@@ -2,17 +2,16 @@ package arch
 
 import (
 	"net"
-	"os/exec"
 )
 
-func addRouteCmd(dest *net.IPNet, gateway, devName string) ([]byte, error) {
-	out, err := exec.Command("netsh", "interface", "ipv4", "add", "route", dest.String(), "nexthop="+gateway, "interface="+devName, "metric=0", "store=active").Output()
+func addRoute(dest *net.IPNet, gateway, devName string, table uint32) error {
+	err := runCmd("netsh", "interface", "ipv4", "add", "route", dest.String(), "nexthop="+gateway, "interface="+devName, "metric=0", "store=active")
 	if err == nil {
-		return out, nil
+		return nil
 	}
-	return exec.Command("netsh", "interface", "ipv4", "set", "route", dest.String(), "nexthop="+gateway, "interface="+devName, "metric=0", "store=active").Output()
+	return runCmd("netsh", "interface", "ipv4", "set", "route", dest.String(), "nexthop="+gateway, "interface="+devName, "metric=0", "store=active")
 }
 
-func deleteRouteCmd(dest *net.IPNet, gateway, devName string) ([]byte, error) {
-	return exec.Command("netsh", "interface", "ipv4", "delete", "route", dest.String(), "interface="+devName).Output()
+func deleteRoute(dest *net.IPNet, gateway, devName string, table uint32) error {
+	return runCmd("netsh", "interface", "ipv4", "delete", "route", dest.String(), "interface="+devName)
 }
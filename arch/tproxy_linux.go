@@ -0,0 +1,291 @@
+package arch
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	tproxyTable = "nconnect_tproxy" // nftables table
+	tproxyChain = "NCONNECT_TPROXY" // iptables chain in nat and mangle table
+
+	// UDP packets are marked and routed to loopback by a local route in a dedicated
+	// routing table, so they can be taken by TPROXY.
+	tproxyMark         = 0x6e64
+	tproxyRouteTable   = 1087
+	tproxyRulePriority = 9002
+)
+
+// reserved networks are not proxied when all traffic is proxied
+var reservedNets = []string{
+	"0.0.0.0/8", "10.0.0.0/8", "100.64.0.0/10", "127.0.0.0/8", "169.254.0.0/16",
+	"172.16.0.0/12", "192.168.0.0/16", "224.0.0.0/4", "240.0.0.0/4",
+}
+
+// TproxyConfig is the config of transparent proxy rules.
+type TproxyConfig struct {
+	Port    int          // port of nConnect's redirect TCP listener and TPROXY UDP listener
+	UDP     bool         // whether to proxy UDP
+	Routes  []*net.IPNet // networks to proxy, all traffic if empty
+	Exclude []*net.IPNet // networks not to proxy when Routes is empty
+	Mark    uint32       // firewall mark of nConnect's own connections, they are not proxied
+}
+
+// tproxyAddr is the address TPROXY sends UDP packets to, the UDP listener only binds it.
+const tproxyAddr = "127.0.0.1"
+
+var (
+	tproxyLock sync.Mutex
+	tproxyCmd  string // "nft" or "iptables" when transparent proxy is enabled
+)
+
+// EnableTproxy redirects IPv4 TCP traffic to the port, and sends IPv4 UDP traffic to the
+// port of loopback address by TPROXY, for both forwarded and local traffic. nConnect's own
+// connections are skipped by firewall mark, and by the group set by SetExemptGroup, or by
+// its user if it's not root. It uses nftables, or iptables if nft is not available. Rules
+// are recorded in route journal, so they are removed by CleanStaleRoutes if nConnect
+// doesn't exit cleanly.
+func EnableTproxy(conf *TproxyConfig) error {
+	tproxyLock.Lock()
+	defer tproxyLock.Unlock()
+
+	var routes, exclude []string
+	for _, ipNet := range conf.Routes {
+		if ipNet.IP.To4() != nil {
+			routes = append(routes, ipNet.String())
+		}
+	}
+	if len(routes) == 0 {
+		exclude = append(exclude, reservedNets...)
+		for _, ipNet := range conf.Exclude {
+			if ipNet.IP.To4() != nil {
+				exclude = append(exclude, ipNet.String())
+			}
+		}
+	}
+
+	journalFirewall(tproxyTable, true)
+	if conf.UDP {
+		err := netlinkRuleAdd(unix.AF_INET, tproxyRouteTable, tproxyMark, tproxyRulePriority)
+		if err == nil {
+			err = netlinkLocalRouteReplace(tproxyRouteTable)
+		}
+		if err != nil {
+			deleteTproxyRoute()
+			journalFirewall(tproxyTable, false)
+			return fmt.Errorf("enable tproxy: %v", err)
+		}
+	}
+
+	if _, err := exec.LookPath("nft"); err == nil {
+		deleteNftTproxy()
+		err = runCmdInput(nftTproxyRules(conf, routes, exclude), "nft", "-f", "-")
+		if err != nil {
+			deleteTproxyRoute()
+			journalFirewall(tproxyTable, false)
+			return fmt.Errorf("enable tproxy: %v", err)
+		}
+		tproxyCmd = "nft"
+	} else {
+		deleteIptablesTproxy()
+		if err = addIptablesTproxy(conf, routes, exclude); err != nil {
+			deleteIptablesTproxy()
+			deleteTproxyRoute()
+			journalFirewall(tproxyTable, false)
+			return fmt.Errorf("enable tproxy: %v", err)
+		}
+		tproxyCmd = "iptables"
+	}
+
+	log.Printf("Transparent proxy enabled by %s on port %d", tproxyCmd, conf.Port)
+	return nil
+}
+
+// DisableTproxy removes rules added by EnableTproxy.
+func DisableTproxy() error {
+	tproxyLock.Lock()
+	defer tproxyLock.Unlock()
+
+	var err error
+	switch tproxyCmd {
+	case "nft":
+		err = deleteNftTproxy()
+	case "iptables":
+		err = deleteIptablesTproxy()
+	default:
+		return nil
+	}
+	err = errors.Join(err, deleteTproxyRoute())
+	if err != nil {
+		return fmt.Errorf("disable tproxy: %v", err)
+	}
+
+	tproxyCmd = ""
+	journalFirewall(tproxyTable, false)
+	log.Println("Transparent proxy disabled")
+	return nil
+}
+
+// deleteTproxy removes tproxy rules left by another process, which might be added by
+// either nft or iptables.
+func deleteTproxy() error {
+	var errs []error
+	if _, err := exec.LookPath("nft"); err == nil {
+		errs = append(errs, deleteNftTproxy())
+	}
+	errs = append(errs, deleteIptablesTproxy(), deleteTproxyRoute())
+	return errors.Join(errs...)
+}
+
+// TproxyUDPAddr returns the address of the TPROXY UDP listener.
+func TproxyUDPAddr(port int) string {
+	return net.JoinHostPort(tproxyAddr, strconv.Itoa(port))
+}
+
+func deleteTproxyRoute() error {
+	return errors.Join(
+		netlinkRuleDelete(unix.AF_INET, tproxyRouteTable, tproxyMark, tproxyRulePriority),
+		netlinkLocalRouteDelete(tproxyRouteTable),
+	)
+}
+
+func nftTproxyRules(conf *TproxyConfig, routes, exclude []string) string {
+	match := "ip daddr != { " + strings.Join(exclude, ", ") + " }"
+	if len(routes) > 0 {
+		match = "ip daddr { " + strings.Join(routes, ", ") + " }"
+	}
+	skip := fmt.Sprintf("\t\tmeta mark %d return\n", conf.Mark)
+	if exemptGroup > 0 {
+		skip += fmt.Sprintf("\t\tmeta skgid %d return\n", exemptGroup)
+	}
+	if uid := os.Getuid(); uid != 0 {
+		skip += fmt.Sprintf("\t\tmeta skuid %d return\n", uid)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "table ip %s {\n", tproxyTable)
+	fmt.Fprintf(&b, "\tchain nat_prerouting {\n")
+	fmt.Fprintf(&b, "\t\ttype nat hook prerouting priority dstnat; policy accept;\n")
+	fmt.Fprintf(&b, "\t\tfib daddr type local return\n")
+	fmt.Fprintf(&b, "\t\t%s meta l4proto tcp redirect to :%d\n", match, conf.Port)
+	fmt.Fprintf(&b, "\t}\n")
+	fmt.Fprintf(&b, "\tchain nat_output {\n")
+	fmt.Fprintf(&b, "\t\ttype nat hook output priority -100; policy accept;\n")
+	b.WriteString(skip)
+	fmt.Fprintf(&b, "\t\t%s meta l4proto tcp redirect to :%d\n", match, conf.Port)
+	fmt.Fprintf(&b, "\t}\n")
+	if conf.UDP {
+		fmt.Fprintf(&b, "\tchain mangle_prerouting {\n")
+		fmt.Fprintf(&b, "\t\ttype filter hook prerouting priority mangle; policy accept;\n")
+		fmt.Fprintf(&b, "\t\tfib daddr type local return\n")
+		fmt.Fprintf(&b, "\t\t%s meta l4proto udp tproxy to %s:%d meta mark set %d accept\n", match, tproxyAddr, conf.Port, tproxyMark)
+		fmt.Fprintf(&b, "\t}\n")
+		fmt.Fprintf(&b, "\tchain mangle_output {\n")
+		fmt.Fprintf(&b, "\t\ttype route hook output priority mangle; policy accept;\n")
+		b.WriteString(skip)
+		fmt.Fprintf(&b, "\t\t%s meta l4proto udp meta mark set %d\n", match, tproxyMark)
+		fmt.Fprintf(&b, "\t}\n")
+	}
+	fmt.Fprintf(&b, "}\n")
+	return b.String()
+}
+
+func deleteNftTproxy() error {
+	err := runCmd("nft", "delete", "table", "ip", tproxyTable)
+	if err != nil && strings.Contains(err.Error(), "No such file or directory") {
+		return nil
+	}
+	return err
+}
+
+// iptablesChainRules returns rules of a chain, which jump to action for matched packets.
+func iptablesChainRules(table, chain string, skipLocal bool, conf *TproxyConfig, routes, exclude []string, action ...string) [][]string {
+	rules := [][]string{{"-t", table, "-N", chain}}
+	add := func(args ...string) {
+		rules = append(rules, append([]string{"-t", table, "-A", chain}, args...))
+	}
+	if skipLocal {
+		add("-m", "addrtype", "--dst-type", "LOCAL", "-j", "RETURN")
+	} else {
+		add("-m", "mark", "--mark", strconv.FormatUint(uint64(conf.Mark), 10), "-j", "RETURN")
+		if exemptGroup > 0 {
+			add("-m", "owner", "--gid-owner", strconv.FormatUint(uint64(exemptGroup), 10), "-j", "RETURN")
+		}
+		if uid := os.Getuid(); uid != 0 {
+			add("-m", "owner", "--uid-owner", strconv.Itoa(uid), "-j", "RETURN")
+		}
+	}
+	if len(routes) > 0 {
+		for _, cidr := range routes {
+			add(append([]string{"-d", cidr}, action...)...)
+		}
+	} else {
+		for _, cidr := range exclude {
+			add("-d", cidr, "-j", "RETURN")
+		}
+		add(action...)
+	}
+	return rules
+}
+
+func addIptablesTproxy(conf *TproxyConfig, routes, exclude []string) error {
+	port := strconv.Itoa(conf.Port)
+	mark := strconv.Itoa(tproxyMark)
+	redirect := []string{"-p", "tcp", "-j", "REDIRECT", "--to-ports", port}
+
+	var rules [][]string
+	rules = append(rules, iptablesChainRules("nat", tproxyChain, true, conf, routes, exclude, redirect...)...)
+	rules = append(rules, iptablesChainRules("nat", tproxyChain+"_OUT", false, conf, routes, exclude, redirect...)...)
+	rules = append(rules,
+		[]string{"-t", "nat", "-I", "PREROUTING", "-j", tproxyChain},
+		[]string{"-t", "nat", "-I", "OUTPUT", "-j", tproxyChain + "_OUT"},
+	)
+	if conf.UDP {
+		tproxyAction := []string{"-p", "udp", "-j", "TPROXY", "--on-ip", tproxyAddr, "--on-port", port, "--tproxy-mark", mark}
+		markAction := []string{"-p", "udp", "-j", "MARK", "--set-mark", mark}
+		rules = append(rules, iptablesChainRules("mangle", tproxyChain, true, conf, routes, exclude, tproxyAction...)...)
+		rules = append(rules, iptablesChainRules("mangle", tproxyChain+"_OUT", false, conf, routes, exclude, markAction...)...)
+		rules = append(rules,
+			[]string{"-t", "mangle", "-I", "PREROUTING", "-j", tproxyChain},
+			[]string{"-t", "mangle", "-I", "OUTPUT", "-j", tproxyChain + "_OUT"},
+		)
+	}
+
+	for _, args := range rules {
+		if err := runCmd("iptables", args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteIptablesTproxy removes tproxy chains, it's not an error if they don't exist.
+func deleteIptablesTproxy() error {
+	var errs []error
+	for _, table := range []string{"nat", "mangle"} {
+		for _, c := range [][2]string{{"PREROUTING", tproxyChain}, {"OUTPUT", tproxyChain + "_OUT"}} {
+			if runCmd("iptables", "-t", table, "-n", "-L", c[1]) != nil {
+				continue
+			}
+			for runCmd("iptables", "-t", table, "-D", c[0], "-j", c[1]) == nil {
+			}
+			if err := runCmd("iptables", "-t", table, "-F", c[1]); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			if err := runCmd("iptables", "-t", table, "-X", c[1]); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
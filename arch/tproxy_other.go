@@ -0,0 +1,32 @@
+// +build !linux
+
+package arch
+
+import (
+	"errors"
+	"fmt"
+	"net"
+)
+
+// TproxyConfig is the config of transparent proxy rules.
+type TproxyConfig struct {
+	Port    int
+	UDP     bool
+	Routes  []*net.IPNet
+	Exclude []*net.IPNet
+	Mark    uint32
+}
+
+// TproxyUDPAddr returns the address of the TPROXY UDP listener.
+func TproxyUDPAddr(port int) string {
+	return fmt.Sprintf("127.0.0.1:%d", port)
+}
+
+// EnableTproxy is only supported on Linux.
+func EnableTproxy(conf *TproxyConfig) error {
+	return errors.New("transparent proxy is only supported on Linux")
+}
+
+func DisableTproxy() error {
+	return nil
+}
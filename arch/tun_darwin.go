@@ -6,6 +6,7 @@ import (
 	"io"
 	"net"
 	"os/exec"
+	"strconv"
 	"strings"
 
 	"github.com/eycorsican/go-tun2socks/tun"
@@ -22,6 +23,13 @@ func openTunDevice(name, addr, gw, mask string, dnsServers []string, persist boo
 	return rwc, err
 }
 
+func setTunMTU(name string, mtu int) error {
+	if tundev == nil {
+		return errors.New("tun device is not open")
+	}
+	return runCmd("ifconfig", tundev.Name(), "mtu", strconv.Itoa(mtu))
+}
+
 func SetTunIp(tunName, addr, mask, gw string) error {
 
 	var params string
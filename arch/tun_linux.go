@@ -1,17 +1,12 @@
 package arch
 
 import (
-	"errors"
 	"fmt"
 	"io"
-	"log"
 	"net"
-	"os/exec"
 	"strconv"
-	"strings"
 
 	"github.com/eycorsican/go-tun2socks/tun"
-	"github.com/nknorg/nconnect/util"
 )
 
 func openTunDevice(name, addr, gw, mask string, dnsServers []string, persist bool) (io.ReadWriteCloser, error) {
@@ -25,43 +20,47 @@ func openTunDevice(name, addr, gw, mask string, dnsServers []string, persist boo
 	return tunDev, err
 }
 
+// SetTunIp sets the address of the TUN device and brings it up. mask is a netmask like
+// 255.255.255.0 for IPv4 address, or a prefix length for IPv6 address.
 func SetTunIp(tapName, ip, mask, gw string) error {
-	out, err := func() ([]byte, error) {
-		out, err := exec.Command("ip", "addr", "replace", ip+"/"+mask, "dev", tapName).Output()
-		if err != nil {
-			return out, err
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return fmt.Errorf("invalid IP address %q", ip)
+	}
+
+	var prefixLen int
+	if addr.To4() != nil {
+		m := net.ParseIP(mask).To4()
+		if m == nil {
+			return fmt.Errorf("invalid netmask %q", mask)
 		}
-		return exec.Command("ip", "link", "set", "dev", tapName, "up").Output()
-	}()
-	if err != nil {
-		if len(out) > 0 {
-			log.Print(string(out))
+		ones, bits := net.IPMask(m).Size()
+		if bits == 0 {
+			return fmt.Errorf("non-canonical netmask %q", mask)
 		}
-		log.Println(util.ParseExecError(err))
-
-		ip := net.ParseIP(ip)
-		if ip == nil {
-			return errors.New("invalid IP address")
+		prefixLen = ones
+	} else {
+		var err error
+		prefixLen, err = strconv.Atoi(mask)
+		if err != nil || prefixLen < 0 || prefixLen > 128 {
+			return fmt.Errorf("invalid IPv6 prefix length %q", mask)
 		}
+	}
 
-		var params string
-		if ip.To4() != nil {
-			params = fmt.Sprintf("%s inet %s netmask %s up", tapName, ip, mask)
-		} else {
-			prefixlen, err := strconv.Atoi(mask)
-			if err != nil {
-				return fmt.Errorf("parse IPv6 prefixlen failed: %v", err)
-			}
-			params = fmt.Sprintf("%s inet6 %s/%d up", tapName, ip, prefixlen)
-		}
+	iface, err := net.InterfaceByName(tapName)
+	if err != nil {
+		return err
+	}
+	if err = netlinkAddrReplace(iface.Index, addr, prefixLen); err != nil {
+		return err
+	}
+	return netlinkLinkUp(iface.Index)
+}
 
-		out, err := exec.Command("ifconfig", strings.Split(params, " ")...).Output()
-		if err != nil {
-			if len(out) > 0 {
-				log.Print(string(out))
-			}
-			return errors.New(util.ParseExecError(err))
-		}
+func setTunMTU(name string, mtu int) error {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return err
 	}
-	return nil
+	return netlinkLinkSetMTU(iface.Index, mtu)
 }
@@ -4,6 +4,7 @@ import (
 	"io"
 	"log"
 	"os/exec"
+	"strconv"
 
 	"github.com/eycorsican/go-tun2socks/tun"
 )
@@ -20,6 +21,10 @@ func openTunDevice(name, addr, gw, mask string, dnsServers []string, persist boo
 	return wintapdev, err
 }
 
+func setTunMTU(name string, mtu int) error {
+	return runCmd("netsh", "interface", "ipv4", "set", "subinterface", name, "mtu="+strconv.Itoa(mtu), "store=active")
+}
+
 func SetTunIp(name, addr, mask, gw string) error {
 	// if wintapdev != nil {
 	// 	wintapdev.Close()
@@ -0,0 +1,208 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultPath  = "audit.log" // file name of the default log, next to config file
+	DefaultLimit = 100
+
+	redacted    = "***"
+	maxLineSize = 1 << 20
+)
+
+// How a caller is authorized
+const (
+	ViaNKN     = "nkn"     // NKN address in admin or accept addresses
+	ViaToken   = "token"   // NKN address with a valid admin token
+	ViaWeb     = "web"     // admin or manager web server
+	ViaControl = "control" // local control socket
+)
+
+// Caller is who calls an administrative method.
+type Caller struct {
+	Addr string `json:"addr"` // NKN address, or IP of web client
+	Via  string `json:"via"`
+}
+
+// Record is an administrative action.
+type Record struct {
+	Time   time.Time              `json:"time"`
+	Caller Caller                 `json:"caller"`
+	Method string                 `json:"method"`
+	Params map[string]interface{} `json:"params,omitempty"` // secrets are redacted
+	Error  string                 `json:"error,omitempty"`  // empty if action succeeded
+}
+
+// Filter selects records, zero value fields match all records.
+type Filter struct {
+	Method string    `json:"method"`
+	Caller string    `json:"caller"` // caller address
+	Since  time.Time `json:"since"`
+	Limit  int       `json:"limit"` // max number of latest records, DefaultLimit if not positive
+}
+
+func (f *Filter) match(r *Record) bool {
+	if len(f.Method) > 0 && r.Method != f.Method {
+		return false
+	}
+	if len(f.Caller) > 0 && r.Caller.Addr != f.Caller {
+		return false
+	}
+	if !f.Since.IsZero() && r.Time.Before(f.Since) {
+		return false
+	}
+	return true
+}
+
+// Log is an append-only audit log file, one json record per line.
+type Log struct {
+	sync.Mutex
+	path string
+	f    *os.File
+}
+
+func NewLog(path string) *Log {
+	return &Log{path: path}
+}
+
+// Add appends a record to the log, the file is created on first record.
+func (l *Log) Add(r *Record) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	l.Lock()
+	defer l.Unlock()
+
+	if l.f == nil {
+		l.f, err = os.OpenFile(l.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = l.f.Write(append(b, '\n'))
+	return err
+}
+
+// Query returns latest records matching filter, in time order.
+func (l *Log) Query(filter *Filter) ([]*Record, error) {
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = DefaultLimit
+	}
+
+	l.Lock()
+	defer l.Unlock()
+
+	records := make([]*Record, 0)
+	f, err := os.Open(l.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return records, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), maxLineSize)
+	for scanner.Scan() {
+		r := &Record{}
+		if err := json.Unmarshal(scanner.Bytes(), r); err != nil {
+			continue // skip broken line, e.g. partially written one
+		}
+		if !filter.match(r) {
+			continue
+		}
+		if len(records) == limit {
+			copy(records, records[1:])
+			records = records[:limit-1]
+		}
+		records = append(records, r)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return records, nil
+}
+
+// sensitive returns whether a param with key should be redacted.
+func sensitive(key string) bool {
+	key = strings.ToLower(key)
+	for _, s := range []string{"seed", "password", "secret", "token", "key"} {
+		if strings.Contains(key, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// Redact returns a copy of params with values of sensitive keys replaced, in nested objects
+// and arrays as well.
+func Redact(params map[string]interface{}) map[string]interface{} {
+	if params == nil {
+		return nil
+	}
+	res := make(map[string]interface{}, len(params))
+	for k, v := range params {
+		if sensitive(k) {
+			res[k] = redacted
+			continue
+		}
+		res[k] = redactValue(v)
+	}
+	return res
+}
+
+func redactValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		return Redact(v)
+	case []interface{}:
+		res := make([]interface{}, len(v))
+		for i := range v {
+			res[i] = redactValue(v[i])
+		}
+		return res
+	}
+	return v
+}
+
+// Administrative actions of the process are recorded in the default log.
+var defaultLog = NewLog(DefaultPath)
+
+// SetPath sets the file of the default log.
+func SetPath(path string) {
+	defaultLog.Lock()
+	defer defaultLog.Unlock()
+	if defaultLog.f != nil {
+		defaultLog.f.Close()
+		defaultLog.f = nil
+	}
+	defaultLog.path = path
+}
+
+// Add records an action to the default log, params are redacted.
+func Add(caller Caller, method string, params map[string]interface{}, errMsg string) error {
+	return defaultLog.Add(&Record{
+		Time:   time.Now(),
+		Caller: caller,
+		Method: method,
+		Params: Redact(params),
+		Error:  errMsg,
+	})
+}
+
+// Query returns latest records of the default log matching filter.
+func Query(filter *Filter) ([]*Record, error) {
+	return defaultLog.Query(filter)
+}
@@ -0,0 +1,57 @@
+package audit
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// go test -v -run=TestLog
+func TestLog(t *testing.T) {
+	l := NewLog(filepath.Join(t.TempDir(), "audit.log"))
+
+	records, err := l.Query(&Filter{})
+	require.NoError(t, err)
+	require.Empty(t, records)
+
+	alice := Caller{Addr: "alice", Via: ViaNKN}
+	web := Caller{Addr: "127.0.0.1", Via: ViaWeb}
+	for i := 0; i < 3; i++ {
+		require.NoError(t, l.Add(&Record{Time: time.Now(), Caller: alice, Method: "setAddrs"}))
+	}
+	require.NoError(t, l.Add(&Record{Time: time.Now(), Caller: web, Method: "setSeed", Error: "invalid seed"}))
+
+	records, err = l.Query(&Filter{Caller: "alice", Limit: 2})
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+	require.Equal(t, "setAddrs", records[1].Method)
+
+	records, err = l.Query(&Filter{Method: "setSeed"})
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	require.Equal(t, web, records[0].Caller)
+	require.Equal(t, "invalid seed", records[0].Error)
+}
+
+// go test -v -run=TestRedact
+func TestRedact(t *testing.T) {
+	params := map[string]interface{}{
+		"seed":   "abc",
+		"addr":   "alice",
+		"nested": map[string]interface{}{"adminToken": "xyz"},
+		"webhooks": []interface{}{
+			map[string]interface{}{"url": "https://example.com", "secret": "s"},
+		},
+	}
+	res := Redact(params)
+	require.Equal(t, redacted, res["seed"])
+	require.Equal(t, "alice", res["addr"])
+	require.Equal(t, redacted, res["nested"].(map[string]interface{})["adminToken"])
+	hook := res["webhooks"].([]interface{})[0].(map[string]interface{})
+	require.Equal(t, redacted, hook["secret"])
+	require.Equal(t, "https://example.com", hook["url"])
+	require.Equal(t, "s", params["webhooks"].([]interface{})[0].(map[string]interface{})["secret"])
+	require.Equal(t, "abc", params["seed"])
+}
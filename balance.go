@@ -0,0 +1,120 @@
+package nconnect
+
+import (
+	"log"
+	"time"
+
+	"github.com/nknorg/nconnect/event"
+	"github.com/nknorg/nconnect/util"
+	"github.com/nknorg/nkn-sdk-go"
+	"github.com/nknorg/nkn/v2/common"
+)
+
+// balanceAlert is posted to balance webhook as json, and emitted as event data.
+type balanceAlert struct {
+	Event     event.Type `json:"event"`
+	Addr      string     `json:"addr"` // server tunnel address
+	Balance   string     `json:"balance"`
+	Threshold string     `json:"threshold"`
+	Time      time.Time  `json:"time"`
+}
+
+// startBalanceMonitor checks wallet balance periodically. It alerts when balance is lower than
+// LowBalance, stops offering tuna to clients starting later when balance is lower than
+// TunaMinBalance, and offers tuna again when balance is restored. Tuna sessions of clients
+// already using tuna are still accepted, they can't switch to NKN sessions until restarted.
+func (nc *nconnect) startBalanceMonitor(tunaConfigured bool) {
+	interval := time.Duration(nc.opts.BalanceCheckInterval) * time.Second
+	if interval <= 0 {
+		return
+	}
+
+	var lowBalance, tunaMinBalance common.Fixed64
+	var err error
+	if len(nc.opts.LowBalance) > 0 {
+		lowBalance, err = common.StringToFixed64(nc.opts.LowBalance)
+		if err != nil {
+			log.Println("Parse low balance error:", err)
+			return
+		}
+	}
+	if tunaConfigured {
+		tunaMinBalance, err = common.StringToFixed64(nc.opts.TunaMinBalance)
+		if err != nil {
+			log.Println("Parse tuna min balance error:", err)
+			return
+		}
+	}
+	if lowBalance <= 0 && tunaMinBalance <= 0 {
+		return
+	}
+
+	w, err := nkn.NewWallet(nc.account, nc.walletConfig)
+	if err != nil {
+		log.Println("Create wallet for balance monitor error:", err)
+		return
+	}
+
+	// tuna can be turned on at runtime only if tuna session client is created at start
+	tunaAvailable := nc.serverTunnel.TunaSessionClient() != nil
+	low := false
+	for !nc.serverTunnel.IsClosed() {
+		time.Sleep(interval)
+
+		amount, err := w.Balance()
+		if err != nil {
+			log.Println("Fetch balance error:", err)
+			continue
+		}
+		balance := amount.ToFixed64()
+
+		if lowBalance > 0 {
+			if !low && balance < lowBalance {
+				low = true
+				log.Printf("ALERT: wallet balance %s is lower than %s", amount.String(), nc.opts.LowBalance)
+				nc.balanceAlert(event.LowBalance, amount.String(), nc.opts.LowBalance)
+			} else if low && balance >= lowBalance {
+				low = false
+				log.Printf("Wallet balance %s is restored", amount.String())
+				nc.balanceAlert(event.BalanceRestored, amount.String(), nc.opts.LowBalance)
+			}
+		}
+
+		if tunaMinBalance > 0 {
+			tuna := nc.opts.IsTuna()
+			if tuna && balance < tunaMinBalance {
+				nc.opts.SetTuna(false)
+				log.Printf("Wallet balance %s is less than minimal balance to enable tuna %s, new clients will use non-tuna sessions",
+					amount.String(), nc.opts.TunaMinBalance)
+				nc.balanceAlert(event.TunaDisabled, amount.String(), nc.opts.TunaMinBalance)
+			} else if !tuna && balance >= tunaMinBalance {
+				if !tunaAvailable {
+					log.Printf("Wallet balance %s is enough to enable tuna now, restart nConnect to enable it", amount.String())
+					tunaMinBalance = 0
+					continue
+				}
+				nc.opts.SetTuna(true)
+				log.Printf("Wallet balance %s is restored, tuna sessions are enabled", amount.String())
+				nc.balanceAlert(event.TunaEnabled, amount.String(), nc.opts.TunaMinBalance)
+			}
+		}
+	}
+}
+
+func (nc *nconnect) balanceAlert(typ event.Type, balance, threshold string) {
+	alert := &balanceAlert{
+		Event:     typ,
+		Addr:      nc.serverTunnel.FromAddr(),
+		Balance:   balance,
+		Threshold: threshold,
+		Time:      time.Now(),
+	}
+	event.Emit(typ, alert)
+
+	if len(nc.opts.BalanceWebhook) == 0 {
+		return
+	}
+	if err := util.PostJSON(nc.opts.BalanceWebhook, alert); err != nil {
+		log.Println("Post balance alert error:", err)
+	}
+}
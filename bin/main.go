@@ -1,15 +1,17 @@
 package main
 
 import (
+	"encoding/hex"
 	"fmt"
 	"log"
 	"os"
-	"strings"
 
 	"github.com/jessevdk/go-flags"
 	"github.com/nknorg/nconnect"
+	"github.com/nknorg/nconnect/admin"
 	"github.com/nknorg/nconnect/config"
 	"github.com/nknorg/nconnect/network"
+	"github.com/nknorg/nkn-sdk-go"
 )
 
 func main() {
@@ -19,8 +21,16 @@ func main() {
 		}
 	}()
 
+	if len(os.Args) > 1 && os.Args[1] == "manager" {
+		if err := managerCli(os.Args[2:]); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
 	var opts = &config.Opts{}
-	_, err := flags.Parse(opts)
+	args, err := flags.ParseArgs(opts, followArgs(os.Args[1:]))
 	if err != nil {
 		if flagsErr, ok := err.(*flags.Error); ok && flagsErr.Type == flags.ErrHelp {
 			os.Exit(0)
@@ -34,7 +44,11 @@ func main() {
 	}
 
 	if opts.Info != "" {
-		cli(opts.Info)
+		c := network.NewControlClient(controlSocketPath(opts.ConfigFile, opts.ControlSocket))
+		if err := nconnect.RunCli(c, opts.Info, args, opts.JSON, opts.Follow); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
 		os.Exit(0)
 	}
 
@@ -76,29 +90,97 @@ func main() {
 	}
 }
 
-const help = `
-nConnect -i <cmd>, to get nConnect information. The cmd can be:
-help: 	this help
-join: 	join network
-leave: 	leave network
-status: get network status
-list: 	list nodes I can access and nodes which can access me
-`
-
-func cli(cmd string) {
-	cmd = strings.ToLower(strings.TrimSpace(cmd))
-	switch cmd {
-	case "help":
-		fmt.Print(help)
-	case "join":
-		network.CliJoin()
-	case "leave":
-		network.CliLeave()
-	case "status":
-		network.CliStatus()
-	case "list":
-		network.CliList()
-	default:
-		fmt.Print("Unknown command: ", cmd, "\n", help)
+// followArgs rewrites -f after "-i logs" to --follow, as -f is short for --config-file otherwise.
+func followArgs(args []string) []string {
+	res := make([]string, len(args))
+	copy(res, args)
+	for i := 0; i < len(res)-1; i++ {
+		if (res[i] == "-i" || res[i] == "--info") && res[i+1] == "logs" {
+			for j := i + 2; j < len(res); j++ {
+				if res[j] == "-f" {
+					res[j] = "--follow"
+				}
+			}
+			break
+		}
 	}
+	return res
+}
+
+type managerCliOpts struct {
+	ControlSocket string `long:"control-socket" description:"Control socket path of the local network manager. Default is the config file path with .sock extension."`
+	Remote        string `long:"remote" description:"Network manager NKN address, send commands through NKN instead of local control socket. This node's address should be in manager's admin addresses."`
+	ConfigFile    string `short:"f" long:"config-file" default:"config.json" description:"Config file path of the local network manager, or the config file whose seed is used to send commands through NKN"`
+	JSON          bool   `long:"json" description:"Print output in json format"`
+}
+
+func managerCli(args []string) error {
+	opts := &managerCliOpts{}
+	parser := flags.NewParser(opts, flags.Default)
+	parser.Usage = "manager [options] <cmd>, run nConnect manager help for commands"
+	args, err := parser.ParseArgs(args)
+	if err != nil {
+		if flagsErr, ok := err.(*flags.Error); ok && flagsErr.Type == flags.ErrHelp {
+			return nil
+		}
+		return err
+	}
+
+	var caller network.RPCCaller
+	if opts.Remote == "" {
+		caller = network.NewControlClient(controlSocketPath(opts.ConfigFile, opts.ControlSocket))
+	} else {
+		c, err := newAdminClient(opts.ConfigFile)
+		if err != nil {
+			return err
+		}
+		defer c.Close()
+		caller = network.NewNKNClient(c, opts.Remote)
+	}
+
+	return network.ManagerCli(caller, args, opts.JSON)
+}
+
+// controlSocketPath returns the control socket path of the nConnect started with configFile.
+func controlSocketPath(configFile, controlSocket string) string {
+	opts := &config.Opts{ConfigFile: configFile}
+	opts.ControlSocket = controlSocket
+	if len(opts.ControlSocket) == 0 {
+		if _, err := os.Stat(configFile); err == nil {
+			if conf, err := config.LoadOrNewConfig(configFile); err == nil {
+				opts.ControlSocket = conf.ControlSocket
+			}
+		}
+	}
+	return opts.ControlSocketPath()
+}
+
+// newAdminClient creates a NKN client with the seed in config file.
+func newAdminClient(configFile string) (*admin.Client, error) {
+	if _, err := os.Stat(configFile); err != nil {
+		return nil, err
+	}
+	conf, err := config.LoadOrNewConfig(configFile)
+	if err != nil {
+		return nil, err
+	}
+	if conf.Seed == "" {
+		return nil, fmt.Errorf("no seed in config file %v", configFile)
+	}
+
+	seed, err := hex.DecodeString(conf.Seed)
+	if err != nil {
+		return nil, err
+	}
+	account, err := nkn.NewAccount(seed)
+	if err != nil {
+		return nil, err
+	}
+
+	clientConfig := &nkn.ClientConfig{}
+	if len(conf.SeedRPCServerAddr) > 0 {
+		clientConfig.SeedRPCServerAddr = nkn.NewStringArray(conf.SeedRPCServerAddr...)
+	}
+
+	return admin.NewClient(account, clientConfig, "")
 }
@@ -0,0 +1,294 @@
+package nconnect
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/nknorg/nconnect/network"
+	"github.com/nknorg/nconnect/ss"
+)
+
+const CliHelp = `
+nConnect -i <cmd> [args], to get nConnect information. The cmd can be:
+help: 	this help
+join: 	join network
+leave: 	leave network
+status: get network status
+list: 	list nodes I can access and nodes which can access me
+watch: 	print network status every time it changes
+peers: 	list peers with their latency and tunnel state
+routes: list routes to peers
+tunnels: list tunnels
+ping <name|ip|address>: ping a network member, or a remote server admin address
+diag <name|ip> [seconds]: measure latency and throughput to a network member through tunnel
+connections: list proxied connections
+kill <id>: close a proxied connection
+logs [lines] [-f]: print latest logs, -f to keep printing new logs
+config get [key]: print running config, or one item of it
+config set <key> <value>: set config and save it to config file, slice values are comma separated
+reconnect: reconnect tunnels and join the network again
+Add --json to print output in json format.
+Use -f (--config-file) before -i to select the nConnect instance started with that config file, or --control-socket to give its control socket path.
+`
+
+var errCliUsage = errors.New("invalid command arguments, run nConnect -i help for usage")
+
+// RunCli runs command line cmd with args on the running nConnect through control socket c.
+func RunCli(c *network.ControlClient, cmd string, args []string, jsonOutput, follow bool) error {
+	cmd = strings.ToLower(strings.TrimSpace(cmd))
+
+	if !jsonOutput {
+		switch cmd {
+		case "help":
+			fmt.Print(CliHelp)
+			return nil
+		case "join":
+			network.CliJoin(c)
+			return nil
+		case "leave":
+			network.CliLeave(c)
+			return nil
+		case "status":
+			network.CliStatus(c)
+			return nil
+		case "list":
+			network.CliList(c)
+			return nil
+		case "watch":
+			network.CliWatch(c)
+			return nil
+		}
+	}
+
+	var method string
+	var params interface{}
+	switch cmd {
+	case "join", "leave", "status", "list", "peers", "routes", "tunnels", "connections", "reconnect":
+		method = cmd
+
+	case "watch":
+		return c.RPCStream(network.Cli_Watch, nil, printJSONLine)
+
+	case "ping":
+		if len(args) != 1 {
+			return errCliUsage
+		}
+		method, params = "ping", &pingParams{Target: args[0]}
+
+	case "diag":
+		if len(args) < 1 || len(args) > 2 {
+			return errCliUsage
+		}
+		p := &diagParams{Target: args[0]}
+		if len(args) == 2 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil {
+				return errCliUsage
+			}
+			p.Seconds = n
+		}
+		method, params = "diag", p
+
+	case "kill":
+		if len(args) != 1 {
+			return errCliUsage
+		}
+		id, err := strconv.ParseUint(args[0], 10, 64)
+		if err != nil {
+			return errCliUsage
+		}
+		method, params = "kill", &killParams{ID: id}
+
+	case "logs":
+		p := &logsParams{Follow: follow}
+		if len(args) > 0 {
+			n, err := strconv.Atoi(args[0])
+			if err != nil {
+				return errCliUsage
+			}
+			p.Lines = n
+		}
+		if follow {
+			return c.RPCStream("logs", p, func(res json.RawMessage, more bool) error {
+				if !more {
+					return nil
+				}
+				if jsonOutput {
+					return printJSONLine(res, more)
+				}
+				return printLines(res)
+			})
+		}
+		method, params = "logs", p
+
+	case "config":
+		if len(args) == 2 && args[0] == "get" {
+			method, params = "getConfig", &configParams{Key: args[1]}
+		} else if len(args) == 1 && args[0] == "get" {
+			method, params = "getConfig", &configParams{}
+		} else if len(args) == 3 && args[0] == "set" {
+			method, params = "setConfig", &configParams{Key: args[1], Value: args[2]}
+		} else {
+			return errCliUsage
+		}
+
+	default:
+		return fmt.Errorf("unknown command: %v, run nConnect -i help for usage", cmd)
+	}
+
+	var res json.RawMessage
+	if err := c.RPCCall(method, params, &res); err != nil {
+		return err
+	}
+	if jsonOutput {
+		var out bytes.Buffer
+		if err := json.Indent(&out, res, "", "  "); err != nil {
+			return err
+		}
+		fmt.Println(out.String())
+		return nil
+	}
+
+	switch method {
+	case "peers":
+		return printPeers(res)
+	case "routes":
+		return printRoutes(res)
+	case "tunnels":
+		return printTunnels(res)
+	case "connections":
+		return printConnections(res)
+	case "ping":
+		r := &PingResult{}
+		if err := json.Unmarshal(res, r); err != nil {
+			return err
+		}
+		fmt.Printf("Reply from %v (%v): time=%v ms\n", r.Target, r.Address, r.LatencyMs)
+	case "diag":
+		r := &network.DiagResult{}
+		if err := json.Unmarshal(res, r); err != nil {
+			return err
+		}
+		network.PrintDiagResult(r)
+	case "logs":
+		return printLines(res)
+	case "getConfig":
+		return printConfig(res)
+	default:
+		var s string
+		if err := json.Unmarshal(res, &s); err != nil {
+			return err
+		}
+		fmt.Println(s)
+	}
+
+	return nil
+}
+
+func printJSONLine(res json.RawMessage, more bool) error {
+	if !more {
+		return nil
+	}
+	fmt.Println(string(res))
+	return nil
+}
+
+func printLines(res json.RawMessage) error {
+	var lines []string
+	if err := json.Unmarshal(res, &lines); err != nil {
+		return err
+	}
+	for _, line := range lines {
+		fmt.Println(line)
+	}
+	return nil
+}
+
+func printPeers(res json.RawMessage) error {
+	var peers []*PeerInfo
+	if err := json.Unmarshal(res, &peers); err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tIP\tONLINE\tTUNNEL\tLATENCY\tADDRESS")
+	for _, p := range peers {
+		latency := "-"
+		if p.LatencyMs >= 0 {
+			latency = fmt.Sprintf("%v ms", p.LatencyMs)
+		}
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\n", p.Name, p.IP, p.Online, p.Tunnel, latency, p.Address)
+	}
+	return w.Flush()
+}
+
+func printRoutes(res json.RawMessage) error {
+	var routes []*RouteInfo
+	if err := json.Unmarshal(res, &routes); err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "DESTINATION\tTYPE\tLOCAL\tVIA")
+	for _, r := range routes {
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\n", r.Destination, r.Type, r.Local, r.Via)
+	}
+	return w.Flush()
+}
+
+func printTunnels(res json.RawMessage) error {
+	var tunnels []*TunnelInfo
+	if err := json.Unmarshal(res, &tunnels); err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TYPE\tTUNA\tCLOSED\tFROM\tTO")
+	for _, t := range tunnels {
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\n", t.Type, t.Tuna, t.Closed, t.From, t.To)
+	}
+	return w.Flush()
+}
+
+func printConnections(res json.RawMessage) error {
+	var conns []*ss.ConnInfo
+	if err := json.Unmarshal(res, &conns); err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNETWORK\tSOURCE\tDESTINATION\tTUNNEL\tSENT\tRECEIVED\tDURATION")
+	for _, c := range conns {
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\n", c.ID, c.Network, c.Source, c.Destination, c.Tunnel,
+			c.BytesSent, c.BytesReceived, time.Since(c.StartTime).Round(time.Second))
+	}
+	return w.Flush()
+}
+
+func printConfig(res json.RawMessage) error {
+	conf := make(map[string]interface{})
+	if err := json.Unmarshal(res, &conf); err != nil {
+		// a single config item
+		fmt.Println(string(res))
+		return nil
+	}
+
+	keys := make([]string, 0, len(conf))
+	for k := range conf {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		b, _ := json.Marshal(conf[k])
+		fmt.Printf("%v = %s\n", k, b)
+	}
+	return nil
+}
@@ -8,7 +8,12 @@ import (
 	"fmt"
 	"math/rand"
 	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
 	"runtime"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -23,6 +28,9 @@ const (
 	DefaultTunNameNonLinux = "nConnect-tap0"
 	FallbackTunaMaxPrice   = "0.01"
 	DefaultUDPTimeout      = time.Hour * 720
+
+	ProtocolAEAD = "aead"
+	ProtocolRaw  = "raw"
 )
 
 var (
@@ -45,7 +53,9 @@ type Opts struct {
 	Address       bool   `long:"address" description:"Print client address (client mode) or admin address (server mode)"`
 	WalletAddress bool   `long:"wallet-address" description:"Print wallet address (server only)"`
 	Version       bool   `long:"version" description:"Print version"`
-	Info          string `short:"i" long:"info" description:"nConnect information"`
+	Info          string `short:"i" long:"info" description:"nConnect information, run nConnect -i help for commands"`
+	JSON          bool   `long:"json" description:"Print nConnect information in json format"`
+	Follow        bool   `long:"follow" description:"Keep printing new logs (-i logs only)"`
 }
 
 type Config struct {
@@ -62,6 +72,7 @@ type Config struct {
 	// Cipher config
 	Cipher   string `json:"cipher,omitempty" long:"cipher" description:"Socks proxy cipher. Dummy (no cipher) will not reduce security because NKN tunnel already has end to end encryption." choice:"dummy" choice:"chacha20-ietf-poly1305" choice:"aes-128-gcm" choice:"aes-256-gcm" default:"chacha20-ietf-poly1305"`
 	Password string `json:"password,omitempty" long:"password" description:"Socks proxy password"`
+	Protocol string `json:"protocol,omitempty" long:"protocol" description:"Internal protocol between local proxy and tunnel. Raw skips the cipher because NKN tunnel already has end to end encryption. Servers using raw accept both, clients use raw only if all remote servers support it." choice:"aead" choice:"raw" default:"aead"`
 
 	// Session config
 	DialTimeout       int32 `json:"dialTimeout,omitempty" long:"dial-timeout" description:"dial timeout in milliseconds"`
@@ -87,15 +98,38 @@ type Config struct {
 	TunMask    string   `json:"tunMask,omitempty" long:"tun-mask" description:"(client only) TUN device network mask, should be a prefixlen (a number) for IPv6 address" default:"255.255.255.0"`
 	TunDNS     []string `json:"tunDNS,omitempty" long:"tun-dns" description:"(client only) DNS resolvers for the TUN device (Windows only)" default:"1.1.1.1" default:"8.8.8.8"`
 	TunName    string   `json:"tunName,omitempty" long:"tun-name" description:"(client only) TUN device name, will be ignored on MacOS. Default is nConnect-tun0 on Linux and nConnect-tap0 on Windows."`
+	TunMTU     int      `json:"tunMTU,omitempty" long:"tun-mtu" description:"(client only) TUN device MTU, TCP MSS is clamped to fit it" default:"1500"`
+
+	TunUDPTimeout int  `json:"tunUDPTimeout,omitempty" long:"tun-udp-timeout" description:"(client only) UDP session timeout of TUN device (in seconds)" default:"30"`
+	TunDirect     bool `json:"tunDirect,omitempty" long:"tun-direct" description:"(client only) Send TUN device traffic to tunnels directly instead of through the local socks proxy, without cipher if remote servers use raw protocol"`
 
 	// VPN mode config
 	VPN      bool     `json:"vpn,omitempty" long:"vpn" description:"(client only) Enable VPN mode, might require root privilege. TUN device will be enabled when VPN mode is enabled."`
 	VPNRoute []string `json:"vpnRoute,omitempty" long:"vpn-route" description:"(client only) VPN routing table destinations, each item should be a valid CIDR. If not given, remote server's local IP addresses will be used."`
 
+	// Policy routing config, Linux only
+	RouteTable uint32 `json:"routeTable,omitempty" long:"route-table" description:"(client only, Linux only) Add VPN routes to this routing table selected by policy rules instead of main table, 0 to use main table"`
+	FwMark     uint32 `json:"fwMark,omitempty" long:"fwmark" description:"(client only, Linux only) Firewall mark of nConnect's own NKN connections when --route-table or --kill-switch is set, they are routed by main table to avoid loops" default:"28259"`
+
+	// Kill switch config, Linux only
+	KillSwitch      bool     `json:"killSwitch,omitempty" long:"kill-switch" description:"(client only, Linux only) In VPN mode, block all outgoing traffic except through TUN device and nConnect's own connections while nConnect is running"`
+	KillSwitchAllow []string `json:"killSwitchAllow,omitempty" long:"kill-switch-allow" description:"(client only, Linux only) CIDR allowed by kill switch, e.g. local network"`
+	FwGroup         uint32   `json:"fwGroup,omitempty" long:"fwgroup" description:"(client only, Linux only) Group ID nConnect switches to when it runs as root with --kill-switch or --tproxy, so firewall rules allow its tuna connections, which can't be marked, by the group" default:"28259"`
+
+	// Transparent proxy config, Linux only
+	Tproxy        bool     `json:"tproxy,omitempty" long:"tproxy" description:"(client only, Linux only) Transparent proxy mode, redirect IPv4 TCP traffic (and UDP traffic if --udp is set) to nConnect by nftables or iptables, an alternative to TUN device"`
+	TproxyPort    int      `json:"tproxyPort,omitempty" long:"tproxy-port" description:"(client only, Linux only) Port of transparent proxy" default:"1082"`
+	TproxyRoute   []string `json:"tproxyRoute,omitempty" long:"tproxy-route" description:"(client only, Linux only) CIDR to proxy in transparent proxy mode. If not given, all traffic except private networks and --tproxy-exclude will be proxied."`
+	TproxyExclude []string `json:"tproxyExclude,omitempty" long:"tproxy-exclude" description:"(client only, Linux only) CIDR not to proxy in transparent proxy mode when --tproxy-route is not given"`
+
 	// Tuna config
 	Tuna                        bool     `json:"tuna,omitempty" short:"t" long:"tuna" description:"Enable tuna sessions"`
 	TunaMinBalance              string   `json:"tunaMinBalance,omitempty" long:"tuna-min-balance" description:"(server only) Minimal balance to enable tuna sessions" default:"0.01"`
-	TunaMaxPrice                string   `json:"tunaMaxPrice,omitempty" long:"tuna-max-price" description:"(server only) Tuna max price in unit of NKN/MB. Can also be a url where the price will be get dynamically at launch." default:"0.01"`
+	TunaMaxPrice                string   `json:"tunaMaxPrice,omitempty" long:"tuna-max-price" description:"(server only) Tuna max price in unit of NKN/MB. Can also be a url where the price is fetched at launch and refreshed every --tuna-price-refresh-interval seconds." default:"0.01"`
+	TunaPriceRefreshInterval    int32    `json:"tunaPriceRefreshInterval,omitempty" long:"tuna-price-refresh-interval" description:"(server only) Interval in seconds to refresh tuna max price when it's a url, 0 to disable" default:"3600"`
+	TunaPriceFloor              string   `json:"tunaPriceFloor,omitempty" long:"tuna-price-floor" description:"(server only) Lower bound of tuna max price got from url"`
+	TunaPriceCeil               string   `json:"tunaPriceCeil,omitempty" long:"tuna-price-ceil" description:"(server only) Upper bound of tuna max price got from url"`
+	TunaPriceMaxChange          float64  `json:"tunaPriceMaxChange,omitempty" long:"tuna-price-max-change" description:"(server only) Max ratio tuna max price can change on each refresh, e.g. 0.2 for 20%. 0 is for no limit"`
 	TunaMinFee                  string   `json:"tunaMinFee,omitempty" long:"tuna-min-fee" description:"(server only) Tuna nanopay minimal txn fee" default:"0.00001"`
 	TunaFeeRatio                float64  `json:"tunaFeeRatio,omitempty" long:"tuna-fee-ratio" description:"(server only) Tuna nanopay txn fee ratio" default:"0.1"`
 	TunaCountry                 []string `json:"tunaCountry,omitempty" long:"tuna-country" description:"(server only) Tuna service node allowed country code, e.g. US. All countries will be allowed if not provided"`
@@ -110,6 +144,14 @@ type Config struct {
 	TunaMeasureStoragePath      string   `json:"tunaMeasureStoragePath,omitempty" long:"tuna-measure-storage-path" description:"(server only) Path to store Tuna measurement results" default:"."`
 	TunaMeasureBandwidthBytes   int32    `json:"tunaMeasureBandwidthBytes,omitempty" long:"tuna-measure-bandwidth-bytes" description:"(server only) Tuna measure bandwidth bytes to transmit when selecting service nodes" default:"1"`
 
+	// Balance monitor config
+	BalanceCheckInterval int32  `json:"balanceCheckInterval,omitempty" long:"balance-check-interval" description:"(server and network manager only) Interval in seconds to check wallet balance, 0 to disable" default:"600"`
+	LowBalance           string `json:"lowBalance,omitempty" long:"low-balance" description:"(server only) Alert when wallet balance is lower than this value"`
+	BalanceWebhook       string `json:"balanceWebhook,omitempty" long:"balance-webhook" description:"(server only) URL that balance alerts will be posted to as json"`
+	TopUpBalance         string `json:"topUpBalance,omitempty" long:"top-up-balance" description:"(network manager only) Send NKN from manager wallet to server members whose balance is lower than this value"`
+	TopUpAmount          string `json:"topUpAmount,omitempty" long:"top-up-amount" description:"(network manager only) Amount of NKN to send to a server member each time its balance is low" default:"1"`
+	TopUpLimit           string `json:"topUpLimit,omitempty" long:"top-up-limit" description:"(network manager only) Max total amount of NKN sent to a server member by top up" default:"10"`
+
 	// UDP config
 	UDP         bool  `json:"udp,omitempty" long:"udp" description:"Support udp proxy"`
 	UDPIdleTime int32 `json:"udpIdleTime,omitempty" long:"udp-idle-time" description:"UDP connections will be purged after idle time (in seconds). 0 is for no purge" default:"0"`
@@ -127,9 +169,43 @@ type Config struct {
 	AcceptAddrs []string `json:"acceptAddrs"`
 	AdminAddrs  []string `json:"adminAddrs"`
 
+	// (server only) Bandwidth and traffic limits of clients, the first limit whose addr pattern matches a client applies to it
+	ClientLimits []ClientLimit `json:"clientLimits,omitempty"`
+
+	// Webhooks that events of server or network manager are posted to
+	Webhooks       []Webhook `json:"webhooks,omitempty"`
+	EventNknNotify bool      `json:"eventNknNotify,omitempty" long:"event-nkn-notify" description:"(server and network manager only) Send events by NKN message to admin addresses that are plain NKN addresses"`
+
 	// nconnect network
 	NodeName       string `json:"nodeName,omitempty" long:"node-name" description:"(network member only) Node name that will be used as to join a network"`
 	ManagerAddress string `json:"managerAddress,omitempty" long:"manager-address" description:"(network member only) Manager address to connect to when joining a network"`
+
+	NodeDescription string   `json:"nodeDescription,omitempty" long:"node-description" description:"(network member only) Node description that will be shown to network manager and peers"`
+	NodeTags        []string `json:"nodeTags,omitempty" long:"node-tags" description:"(network member only) Node tags that will be shown to network manager and peers"`
+	NodeRoutes      []string `json:"nodeRoutes,omitempty" long:"node-routes" description:"(network member only) CIDRs reachable through this node that will be advertised to peers"`
+	IPPacket        bool     `json:"ipPacket,omitempty" long:"ip-packet" description:"(network member only) Exchange raw IP packets with members which enable it too, so ICMP and all IP protocols work between them"`
+
+	HeartbeatInterval    int32 `json:"heartbeatInterval,omitempty" long:"heartbeat-interval" description:"(network member only) Interval in seconds to send heartbeat to network manager" default:"30"`
+	MemberOfflineTimeout int32 `json:"memberOfflineTimeout,omitempty" long:"member-offline-timeout" description:"(network manager only) A member is considered offline if no heartbeat is received within this many seconds" default:"90"`
+	DiagPort             int32 `json:"diagPort,omitempty" long:"diag-port" description:"(network member only) TCP port on member IP to serve peer diagnostics through tunnel, 0 to disable" default:"40086"`
+
+	ControlSocket string `json:"controlSocket,omitempty" long:"control-socket" description:"Local control socket path used by nConnect command line, only the user running nConnect can access it. Default is the config file path with .sock extension."`
+}
+
+// ClientLimit limits the bandwidth and traffic of clients whose NKN address matches Addr.
+// A zero value means unlimited.
+type ClientLimit struct {
+	Addr         string `json:"addr"`         // client NKN address regex pattern
+	RateLimit    int64  `json:"rateLimit"`    // KB/s of both directions
+	DailyQuota   int64  `json:"dailyQuota"`   // MB per day
+	MonthlyQuota int64  `json:"monthlyQuota"` // MB per month
+}
+
+// Webhook posts events to URL as json, signed with Secret if it's not empty.
+type Webhook struct {
+	URL    string   `json:"url"`
+	Secret string   `json:"secret,omitempty"` // HMAC-SHA256 key of X-Nconnect-Signature header
+	Events []string `json:"events,omitempty"` // types of events to post, all events if empty
 }
 
 func NewConfig() *Config {
@@ -198,6 +274,57 @@ func (c *Config) VerifyServer() error {
 	if err != nil {
 		return fmt.Errorf("parse TunaMinFee error: %v", err)
 	}
+	if len(c.LowBalance) > 0 {
+		_, err = common.StringToFixed64(c.LowBalance)
+		if err != nil {
+			return fmt.Errorf("parse LowBalance error: %v", err)
+		}
+	}
+	return nil
+}
+
+var choiceTag = regexp.MustCompile(`choice:"([^"]*)"`)
+
+// verify checks values which have a fixed set of choices or are NKN amounts, so SetField
+// doesn't save invalid values. Empty values are defaults and not checked.
+func (c *Config) verify() error {
+	v := reflect.ValueOf(c).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Type().Field(i)
+		value := v.Field(i)
+		if value.Kind() != reflect.String || value.Len() == 0 {
+			continue
+		}
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		var choices []string
+		for _, m := range choiceTag.FindAllStringSubmatch(string(f.Tag), -1) {
+			if m[1] == value.String() {
+				choices = nil
+				break
+			}
+			choices = append(choices, m[1])
+		}
+		if len(choices) > 0 {
+			return fmt.Errorf("invalid %v %v, it should be one of %v", name, value, strings.Join(choices, ", "))
+		}
+	}
+
+	amounts := []struct{ name, value string }{
+		{"tunaMinBalance", c.TunaMinBalance},
+		{"tunaMinFee", c.TunaMinFee},
+		{"lowBalance", c.LowBalance},
+	}
+	if !util.IsValidUrl(c.TunaMaxPrice) {
+		amounts = append(amounts, struct{ name, value string }{"tunaMaxPrice", c.TunaMaxPrice})
+	}
+	for _, a := range amounts {
+		if len(a.value) == 0 {
+			continue
+		}
+		if _, err := common.StringToFixed64(a.value); err != nil {
+			return fmt.Errorf("parse %v error: %v", a.name, err)
+		}
+	}
 	return nil
 }
 
@@ -262,6 +389,32 @@ func (c *Config) SetAdminHTTPAPI(disable bool) error {
 	return c.save()
 }
 
+// SetTunaMaxPrice sets the effective tuna max price at runtime, it's not saved to config file.
+func (c *Config) SetTunaMaxPrice(price string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.TunaMaxPrice = price
+}
+
+func (c *Config) GetTunaMaxPrice() string {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.TunaMaxPrice
+}
+
+// SetTuna turns tuna sessions on or off at runtime, it's not saved to config file.
+func (c *Config) SetTuna(tuna bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.Tuna = tuna
+}
+
+func (c *Config) IsTuna() bool {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.Tuna
+}
+
 func (c *Config) SetSeed(s string) error {
 	seed, err := hex.DecodeString(s)
 	if err != nil {
@@ -290,6 +443,72 @@ func (c *Config) SetTunaConfig(serviceName string, country []string, allowNknAdd
 	return c.save()
 }
 
+// SetField sets the field whose json name is key from a string value and saves config.
+// String slice fields take comma separated values. Invalid values are not set.
+func (c *Config) SetField(key, value string) error {
+	if key == "seed" {
+		return c.SetSeed(value)
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	v := reflect.ValueOf(c).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		name := strings.Split(v.Type().Field(i).Tag.Get("json"), ",")[0]
+		if name != key || name == "" || name == "-" {
+			continue
+		}
+
+		f := v.Field(i)
+		old := reflect.New(f.Type()).Elem()
+		old.Set(f)
+		switch f.Kind() {
+		case reflect.String:
+			f.SetString(value)
+		case reflect.Bool:
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return err
+			}
+			f.SetBool(b)
+		case reflect.Int, reflect.Int32, reflect.Int64:
+			n, err := strconv.ParseInt(value, 10, f.Type().Bits())
+			if err != nil {
+				return err
+			}
+			f.SetInt(n)
+		case reflect.Float64:
+			n, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return err
+			}
+			f.SetFloat(n)
+		case reflect.Slice:
+			if f.Type().Elem().Kind() != reflect.String {
+				return fmt.Errorf("config %v can not be set", key)
+			}
+			items := reflect.MakeSlice(f.Type(), 0, 0)
+			for _, item := range strings.Split(value, ",") {
+				if item = strings.TrimSpace(item); len(item) > 0 {
+					items = reflect.Append(items, reflect.ValueOf(item).Convert(f.Type().Elem()))
+				}
+			}
+			f.Set(items)
+		default:
+			return fmt.Errorf("config %v can not be set", key)
+		}
+
+		if err := c.verify(); err != nil {
+			f.Set(old)
+			return err
+		}
+		return c.save()
+	}
+
+	return fmt.Errorf("unknown config %v", key)
+}
+
 func (c *Config) Save() error {
 	c.lock.Lock()
 	defer c.lock.Unlock()
@@ -314,6 +533,21 @@ func (c *Config) save() error {
 	return nil
 }
 
+// ControlSocketPath returns the control socket path. By default it's next to the config
+// file, so nConnect instances started with different config files don't conflict.
+func (o *Opts) ControlSocketPath() string {
+	if len(o.ControlSocket) > 0 {
+		return o.ControlSocket
+	}
+	return strings.TrimSuffix(o.ConfigFile, filepath.Ext(o.ConfigFile)) + ".sock"
+}
+
+// DataPath returns the path of a file nConnect writes besides config, e.g. audit log. It's
+// next to the config file, so it doesn't depend on the working directory.
+func (o *Opts) DataPath(name string) string {
+	return filepath.Join(filepath.Dir(o.ConfigFile), name)
+}
+
 func RandomIdentifier() string {
 	b := make([]byte, RandomIdentifierLength)
 	for i := range b {
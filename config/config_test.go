@@ -0,0 +1,37 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// go test -v -run=TestSetField
+func TestSetField(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	c, err := LoadOrNewConfig(path)
+	require.NoError(t, err)
+
+	require.NoError(t, c.SetField("cipher", "aes-128-gcm"))
+	require.NoError(t, c.SetField("tunDNS", "1.1.1.1, 8.8.8.8"))
+	require.Equal(t, []string{"1.1.1.1", "8.8.8.8"}, c.TunDNS)
+	require.EqualError(t, c.SetField("foo", "bar"), "unknown config foo")
+
+	// slices of structs can't be set from a string
+	require.EqualError(t, c.SetField("clientLimits", "a,b"), "config clientLimits can not be set")
+	require.EqualError(t, c.SetField("webhooks", "a"), "config webhooks can not be set")
+
+	// invalid values are neither set nor saved
+	require.Error(t, c.SetField("cipher", "foo"))
+	require.Error(t, c.SetField("tunaMinFee", "foo"))
+	require.Equal(t, "aes-128-gcm", c.Cipher)
+	require.Empty(t, c.TunaMinFee)
+	require.NoError(t, c.SetField("tunaMaxPrice", "https://example.com/price"))
+
+	b, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Contains(t, string(b), `"aes-128-gcm"`)
+	require.NotContains(t, string(b), `"foo"`)
+}
@@ -0,0 +1,501 @@
+package nconnect
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/nknorg/nconnect/admin"
+	"github.com/nknorg/nconnect/audit"
+	"github.com/nknorg/nconnect/network"
+	"github.com/nknorg/nconnect/ss"
+	"github.com/nknorg/nconnect/util"
+	"github.com/nknorg/nkn-sdk-go"
+	ts "github.com/nknorg/nkn-tuna-session"
+	tunnel "github.com/nknorg/nkn-tunnel"
+)
+
+const (
+	pingTimeout     = 5 * time.Second
+	defaultLogLines = 100
+	maxLogLines     = 1000
+)
+
+var (
+	errTargetNotFound = errors.New("ping target not found")
+	redactedConfig    = []string{"seed", "password"}
+	redactedWebhook   = []string{"secret"} // redacted in every item of webhooks
+
+	// control methods changing the running nConnect are audited
+	auditedControlMethods = map[string]bool{
+		"kill":      true,
+		"setConfig": true,
+		"reconnect": true,
+	}
+)
+
+// PeerInfo is a node this nConnect can access, either a network member or a remote server.
+type PeerInfo struct {
+	Name          string `json:"name,omitempty"`
+	IP            string `json:"ip,omitempty"`
+	Address       string `json:"address,omitempty"`
+	ServerAddress string `json:"serverAddress,omitempty"`
+	Online        bool   `json:"online"`
+	Tunnel        string `json:"tunnel"`    // tunnel state: connected, closed or none
+	LatencyMs     int64  `json:"latencyMs"` // -1 if peer doesn't respond
+}
+
+type RouteInfo struct {
+	Destination string `json:"destination"`
+	Via         string `json:"via"`             // tunnel remote address, or device name for vpn routes
+	Local       string `json:"local,omitempty"` // local address of the tunnel
+	Type        string `json:"type"`            // tunnel, default or vpn
+}
+
+type TunnelInfo struct {
+	Type   string `json:"type"` // client, network or server
+	From   string `json:"from"`
+	To     string `json:"to"`
+	Tuna   bool   `json:"tuna"`
+	Closed bool   `json:"closed"`
+}
+
+type PingResult struct {
+	Target    string `json:"target"`
+	Address   string `json:"address"`
+	LatencyMs int64  `json:"latencyMs"`
+}
+
+type pingParams struct {
+	Target string `json:"target"`
+}
+
+type logsParams struct {
+	Lines  int  `json:"lines"`
+	Follow bool `json:"follow"`
+}
+
+type killParams struct {
+	ID uint64 `json:"id"`
+}
+
+type configParams struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// StartControlService serves command line requests on the local control socket,
+// it only starts once even if both member and client are started.
+func (nc *nconnect) StartControlService() {
+	nc.controlOnce.Do(func() {
+		go func() {
+			if err := network.ServeControl(nc.opts.ControlSocketPath(), nc.handleControlRequest); err != nil {
+				log.Println("Start control service error:", err)
+			}
+		}()
+	})
+}
+
+func (nc *nconnect) handleControlRequest(req *admin.RpcReq, stream *network.ControlStream) *admin.RpcResp {
+	resp := &admin.RpcResp{}
+	var err error
+
+	if auditedControlMethods[req.Method] {
+		defer func() {
+			caller := audit.Caller{Addr: nc.opts.ControlSocketPath(), Via: audit.ViaControl}
+			if err := audit.Add(caller, req.Method, controlAuditParams(req), resp.Error); err != nil {
+				log.Println("Add audit record error:", err)
+			}
+		}()
+	}
+
+	switch req.Method {
+	case "peers":
+		resp.Result = nc.getPeers()
+
+	case "routes":
+		resp.Result = nc.getRoutes()
+
+	case "tunnels":
+		resp.Result = nc.getTunnels()
+
+	case "ping":
+		params := &pingParams{}
+		if err = util.JSONConvert(req.Params, params); err != nil {
+			break
+		}
+		resp.Result, err = nc.ping(params.Target)
+
+	case "diag":
+		params := &diagParams{}
+		if err = util.JSONConvert(req.Params, params); err != nil {
+			break
+		}
+		resp.Result, err = nc.diagnose(params)
+
+	case "connections":
+		resp.Result = ss.Connections()
+
+	case "kill":
+		params := &killParams{}
+		if err = util.JSONConvert(req.Params, params); err != nil {
+			break
+		}
+		if err = ss.KillConnection(params.ID); err != nil {
+			break
+		}
+		resp.Result = "success"
+
+	case "logs":
+		params := &logsParams{}
+		if err = util.JSONConvert(req.Params, params); err != nil {
+			break
+		}
+		if params.Follow {
+			err = nc.followLogs(params.Lines, stream)
+		} else {
+			resp.Result = nc.logs.Lines(logLines(params.Lines))
+		}
+
+	case "getConfig":
+		params := &configParams{}
+		if err = util.JSONConvert(req.Params, params); err != nil {
+			break
+		}
+		resp.Result, err = nc.getConfig(params.Key)
+
+	case "setConfig":
+		params := &configParams{}
+		if err = util.JSONConvert(req.Params, params); err != nil {
+			break
+		}
+		if err = nc.persistConf.SetField(params.Key, params.Value); err != nil {
+			break
+		}
+		resp.Result = "success, restart nConnect to apply the change"
+
+	case "reconnect":
+		if err = nc.reconnect(); err != nil {
+			break
+		}
+		resp.Result = "success"
+
+	default:
+		if nc.networkMember != nil {
+			return nc.networkMember.HandleControlRequest(req, stream)
+		}
+		err = fmt.Errorf("unknown method %v, it might be available in network member mode only", req.Method)
+	}
+
+	if err != nil {
+		resp.Error = err.Error()
+	}
+
+	return resp
+}
+
+// tunnelsByType returns client, network and server tunnels.
+func (nc *nconnect) tunnelsByType() map[string][]*tunnel.Tunnel {
+	nc.RLock()
+	defer nc.RUnlock()
+
+	tunnels := map[string][]*tunnel.Tunnel{
+		"client": append([]*tunnel.Tunnel{}, nc.clientTunnels...),
+	}
+	for _, t := range nc.networkTunnels {
+		tunnels["network"] = append(tunnels["network"], t)
+	}
+	if nc.serverTunnel != nil {
+		tunnels["server"] = []*tunnel.Tunnel{nc.serverTunnel}
+	}
+
+	return tunnels
+}
+
+func tunnelState(t *tunnel.Tunnel) string {
+	if t == nil {
+		return "none"
+	}
+	if t.IsClosed() {
+		return "closed"
+	}
+	return "connected"
+}
+
+func (nc *nconnect) getTunnels() []*TunnelInfo {
+	list := make([]*TunnelInfo, 0)
+	for _, typ := range []string{"client", "network", "server"} {
+		for _, t := range nc.tunnelsByType()[typ] {
+			list = append(list, &TunnelInfo{
+				Type:   typ,
+				From:   t.FromAddr(),
+				To:     t.ToAddr(),
+				Tuna:   t.TunaSessionClient() != nil,
+				Closed: t.IsClosed(),
+			})
+		}
+	}
+	return list
+}
+
+func (nc *nconnect) getPeers() []*PeerInfo {
+	peers := make([]*PeerInfo, 0)
+	pings := make(map[*PeerInfo]func() (time.Duration, error))
+
+	tunnels := nc.tunnelsByType()
+	tunnelTo := make(map[string]*tunnel.Tunnel)
+	for _, t := range append(tunnels["client"], tunnels["network"]...) {
+		tunnelTo[t.ToAddr()] = t
+	}
+
+	if nc.networkMember != nil {
+		for _, n := range nc.networkMember.GetNodesICanAccess() {
+			p := &PeerInfo{
+				Name:          n.Name,
+				IP:            n.IP,
+				Address:       n.Address,
+				ServerAddress: n.ServerAddress,
+				Online:        n.Online,
+				Tunnel:        tunnelState(tunnelTo[n.ServerAddress]),
+			}
+			address := n.Address
+			pings[p] = func() (time.Duration, error) { return nc.networkMember.Ping(address, pingTimeout) }
+			peers = append(peers, p)
+		}
+	}
+
+	nc.RLock()
+	adminAddrs := make(map[string]string, len(nc.remoteInfoCache))
+	for adminAddr, info := range nc.remoteInfoCache {
+		adminAddrs[info.Addr] = adminAddr
+	}
+	nc.RUnlock()
+	for _, t := range tunnels["client"] {
+		p := &PeerInfo{Address: t.ToAddr(), ServerAddress: t.ToAddr(), Online: !t.IsClosed(), Tunnel: tunnelState(t), LatencyMs: -1}
+		if adminAddr, ok := adminAddrs[t.ToAddr()]; ok {
+			pings[p] = func() (time.Duration, error) { return nc.pingAdmin(adminAddr) }
+		}
+		peers = append(peers, p)
+	}
+
+	var wg sync.WaitGroup
+	for p, ping := range pings {
+		wg.Add(1)
+		go func(p *PeerInfo, ping func() (time.Duration, error)) {
+			defer wg.Done()
+			p.LatencyMs = -1
+			if rtt, err := ping(); err == nil {
+				p.LatencyMs = rtt.Milliseconds()
+			}
+		}(p, ping)
+	}
+	wg.Wait()
+
+	return peers
+}
+
+// pingAdmin measures the round trip time of a getInfo rpc call to a remote server.
+func (nc *nconnect) pingAdmin(adminAddr string) (time.Duration, error) {
+	c, err := nc.getAdminClient()
+	if err != nil {
+		return 0, err
+	}
+
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		_, err := c.GetInfo(adminAddr)
+		done <- err
+	}()
+
+	select {
+	case err = <-done:
+		return time.Since(start), err
+	case <-time.After(pingTimeout):
+		return 0, admin.ErrReplyTimeout
+	}
+}
+
+// ping pings a network member by name, IP or address, or a remote server by admin address.
+func (nc *nconnect) ping(target string) (*PingResult, error) {
+	res := &PingResult{Target: target}
+	var rtt time.Duration
+	var err error
+
+	if nc.networkMember != nil {
+		nodes := append(nc.networkMember.GetNodesICanAccess(), nc.networkMember.GetNodesIAccept()...)
+		for _, n := range nodes {
+			if n.Name == target || n.IP == target || n.Address == target {
+				res.Address = n.Address
+				break
+			}
+		}
+	}
+
+	if res.Address != "" {
+		rtt, err = nc.networkMember.Ping(res.Address, pingTimeout)
+	} else {
+		for _, addr := range nc.opts.RemoteAdminAddr {
+			if addr == target {
+				res.Address = addr
+				break
+			}
+		}
+		if res.Address == "" {
+			return nil, errTargetNotFound
+		}
+		rtt, err = nc.pingAdmin(res.Address)
+	}
+	if err != nil {
+		return nil, err
+	}
+	res.LatencyMs = rtt.Milliseconds()
+
+	return res, nil
+}
+
+func (nc *nconnect) getRoutes() []*RouteInfo {
+	localToRemote := make(map[string]string)
+	tunnels := nc.tunnelsByType()
+	for _, t := range append(tunnels["client"], tunnels["network"]...) {
+		localToRemote[t.FromAddr()] = t.ToAddr()
+	}
+
+	routes := make([]*RouteInfo, 0)
+	nc.RLock()
+	for dest, local := range nc.ssClientConfig.TargetToClient {
+		routes = append(routes, &RouteInfo{Destination: dest, Via: localToRemote[local], Local: local, Type: "tunnel"})
+	}
+	for dest, local := range nc.networkCIDRToClient {
+		routes = append(routes, &RouteInfo{Destination: dest, Via: localToRemote[local], Local: local, Type: "tunnel"})
+	}
+	sort.Slice(routes, func(i, j int) bool { return routes[i].Destination < routes[j].Destination })
+	if local := nc.ssClientConfig.DefaultClient; local != "" {
+		routes = append(routes, &RouteInfo{Destination: "default", Via: localToRemote[local], Local: local, Type: "default"})
+	}
+	for _, cidr := range nc.routeCIDRs {
+		routes = append(routes, &RouteInfo{Destination: cidr.String(), Via: nc.opts.TunName, Type: "vpn"})
+	}
+	nc.RUnlock()
+
+	return routes
+}
+
+func logLines(n int) int {
+	if n <= 0 {
+		return defaultLogLines
+	}
+	if n > maxLogLines {
+		return maxLogLines
+	}
+	return n
+}
+
+// followLogs streams the latest lines, then each new line until the peer stops following.
+func (nc *nconnect) followLogs(lines int, stream *network.ControlStream) error {
+	c := nc.logs.Subscribe()
+	defer nc.logs.Unsubscribe(c)
+
+	if err := stream.Send(nc.logs.Lines(logLines(lines))); err != nil {
+		return err
+	}
+	for {
+		select {
+		case line := <-c:
+			if err := stream.Send([]string{line}); err != nil {
+				return err
+			}
+		case <-stream.Done():
+			return nil
+		}
+	}
+}
+
+// getConfig returns the running config, or one item of it if key is not empty.
+func (nc *nconnect) getConfig(key string) (interface{}, error) {
+	b, err := json.Marshal(&nc.opts.Config)
+	if err != nil {
+		return nil, err
+	}
+	conf := make(map[string]interface{})
+	if err = json.Unmarshal(b, &conf); err != nil {
+		return nil, err
+	}
+	redactConfig(conf, redactedConfig)
+	if hooks, ok := conf["webhooks"].([]interface{}); ok {
+		for _, hook := range hooks {
+			if hook, ok := hook.(map[string]interface{}); ok {
+				redactConfig(hook, redactedWebhook)
+			}
+		}
+	}
+
+	if key == "" {
+		return conf, nil
+	}
+	if v, ok := conf[key]; ok {
+		return v, nil
+	}
+	return nil, fmt.Errorf("config %v is not set", key)
+}
+
+// controlAuditParams returns params of req to be audited. Value of setConfig is keyed by the
+// config key and parsed if it's json, so secrets in it are redacted like other params.
+func controlAuditParams(req *admin.RpcReq) map[string]interface{} {
+	if req.Method != "setConfig" {
+		return req.Params
+	}
+	params := &configParams{}
+	if err := util.JSONConvert(req.Params, params); err != nil {
+		return req.Params
+	}
+	var value interface{}
+	if err := json.Unmarshal([]byte(params.Value), &value); err != nil {
+		value = params.Value
+	}
+	return map[string]interface{}{params.Key: value}
+}
+
+func redactConfig(conf map[string]interface{}, keys []string) {
+	for _, k := range keys {
+		if _, ok := conf[k]; ok {
+			conf[k] = "******"
+		}
+	}
+}
+
+// reconnect forces tunnels to reconnect to NKN and tuna, and rejoins the network if it's a member.
+func (nc *nconnect) reconnect() error {
+	// tunnels created together share the same clients
+	multiClients := make(map[*nkn.MultiClient]struct{})
+	tsClients := make(map[*ts.TunaSessionClient]struct{})
+	for _, list := range nc.tunnelsByType() {
+		for _, t := range list {
+			if t.IsClosed() {
+				continue
+			}
+			if mc := t.MultiClient(); mc != nil {
+				multiClients[mc] = struct{}{}
+			}
+			if tsClient := t.TunaSessionClient(); tsClient != nil {
+				tsClients[tsClient] = struct{}{}
+			}
+		}
+	}
+	for mc := range multiClients {
+		mc.Reconnect()
+	}
+	for tsClient := range tsClients {
+		go tsClient.RotateAll()
+	}
+
+	if nc.networkMember != nil {
+		return nc.networkMember.Reconnect()
+	}
+
+	return nil
+}
@@ -0,0 +1,90 @@
+package nconnect
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/nknorg/nconnect/network"
+	"golang.org/x/net/proxy"
+)
+
+const (
+	diagDialTimeout = 5 * time.Second
+	diagListenRetry = 5 * time.Second // member IP is not on TUN device until authorized
+)
+
+var errDiagNeedsClient = errors.New("client mode is required to run diagnostics through tunnel")
+
+type diagParams struct {
+	Target  string `json:"target"`
+	Count   int    `json:"count"`   // number of echo requests
+	Seconds int    `json:"seconds"` // duration of each throughput test
+}
+
+// startDiagServer serves diagnostics of peers reaching this member through its nConnect server.
+// It only listens on member IP, which peers connect to through tunnel, once the IP is set on
+// TUN device.
+func (nc *nconnect) startDiagServer() {
+	if nc.opts.DiagPort <= 0 {
+		return
+	}
+
+	go func() {
+		for {
+			if node := nc.networkMember.GetNodeInfo(); node != nil && node.IP != "" {
+				address := net.JoinHostPort(node.IP, strconv.Itoa(int(nc.opts.DiagPort)))
+				if l, err := net.Listen("tcp", address); err == nil {
+					if err = network.ServeDiag(l); err != nil {
+						log.Println("Diagnostics server error:", err)
+					}
+					return
+				}
+			}
+			time.Sleep(diagListenRetry)
+		}
+	}()
+}
+
+// diagnoseNode measures latency and throughput to node through the same socks proxy and tunnel
+// used by applications.
+func (nc *nconnect) diagnoseNode(node *network.NodeInfo, count int, duration time.Duration) (*network.DiagResult, error) {
+	if !nc.opts.Client {
+		return nil, errDiagNeedsClient
+	}
+	if node.IP == "" {
+		return nil, fmt.Errorf("node %v has no IP", node.Name)
+	}
+
+	dialer, err := proxy.SOCKS5("tcp", nc.opts.LocalSocksAddr, nil, &net.Dialer{Timeout: diagDialTimeout})
+	if err != nil {
+		return nil, err
+	}
+	address := net.JoinHostPort(node.IP, strconv.Itoa(int(nc.opts.DiagPort)))
+	res, err := network.Diagnose(func() (net.Conn, error) { return dialer.Dial("tcp", address) }, count, duration)
+	if err != nil {
+		return nil, err
+	}
+	res.Target = node.Name
+	res.IP = node.IP
+
+	return res, nil
+}
+
+// diagnose runs diagnostics to a member I can access by name, IP or address.
+func (nc *nconnect) diagnose(params *diagParams) (*network.DiagResult, error) {
+	if nc.networkMember == nil {
+		return nil, errors.New("diagnostics is available in network member mode only")
+	}
+
+	for _, n := range nc.networkMember.GetNodesICanAccess() {
+		if n.Name == params.Target || n.IP == params.Target || n.Address == params.Target {
+			return nc.diagnoseNode(n, params.Count, time.Duration(params.Seconds)*time.Second)
+		}
+	}
+
+	return nil, errTargetNotFound
+}
@@ -0,0 +1,102 @@
+package event
+
+import (
+	"sync"
+	"time"
+)
+
+// Type is the type of an event.
+type Type string
+
+const (
+	JoinRequest      Type = "joinRequest"      // a node is waiting for authorization to join network
+	MemberAuthorized Type = "memberAuthorized" // a node is authorized to be network member
+	MemberRemoved    Type = "memberRemoved"    // a member is removed by manager
+	MemberLeft       Type = "memberLeft"       // a node left network
+	MemberOnline     Type = "memberOnline"
+	MemberOffline    Type = "memberOffline"
+	AcceptChanged    Type = "acceptChanged" // accept addresses of a server or network member are changed
+	LowBalance       Type = "lowBalance"
+	BalanceRestored  Type = "balanceRestored"
+	TunaDisabled     Type = "tunaDisabled" // tuna sessions fall back to NKN sessions because of low balance
+	TunaEnabled      Type = "tunaEnabled"
+	TopUp            Type = "topUp"       // manager sent NKN to a server member with low balance
+	TopUpFailed      Type = "topUpFailed" // manager failed to send NKN to a server member, or reached top up limit
+)
+
+// Event is something happened on a server or network manager.
+type Event struct {
+	ID     uint64      `json:"id"` // increasing ID since the process starts
+	Type   Type        `json:"type"`
+	Time   time.Time   `json:"time"`
+	Source string      `json:"source,omitempty"` // NKN address of the node emitting the event
+	Data   interface{} `json:"data,omitempty"`
+}
+
+// Bus sends emitted events to subscribers.
+type Bus struct {
+	sync.Mutex
+	source      string
+	nextID      uint64
+	subscribers map[chan *Event]struct{}
+}
+
+func NewBus() *Bus {
+	return &Bus{subscribers: make(map[chan *Event]struct{})}
+}
+
+// SetSource sets the source of events emitted later.
+func (b *Bus) SetSource(source string) {
+	b.Lock()
+	b.source = source
+	b.Unlock()
+}
+
+// Emit sends an event to subscribers. Data should not be changed after it's emitted.
+func (b *Bus) Emit(typ Type, data interface{}) {
+	b.Lock()
+	defer b.Unlock()
+
+	b.nextID++
+	e := &Event{ID: b.nextID, Type: typ, Time: time.Now(), Source: b.source, Data: data}
+	for c := range b.subscribers {
+		select {
+		case c <- e:
+		default: // drop events for slow subscribers rather than blocking emitter
+		}
+	}
+}
+
+// Subscribe returns a channel receiving new events until Unsubscribe is called.
+func (b *Bus) Subscribe() chan *Event {
+	c := make(chan *Event, 128)
+	b.Lock()
+	b.subscribers[c] = struct{}{}
+	b.Unlock()
+	return c
+}
+
+func (b *Bus) Unsubscribe(c chan *Event) {
+	b.Lock()
+	delete(b.subscribers, c)
+	b.Unlock()
+}
+
+// Events of the process go through the default bus.
+var defaultBus = NewBus()
+
+func SetSource(source string) {
+	defaultBus.SetSource(source)
+}
+
+func Emit(typ Type, data interface{}) {
+	defaultBus.Emit(typ, data)
+}
+
+func Subscribe() chan *Event {
+	return defaultBus.Subscribe()
+}
+
+func Unsubscribe(c chan *Event) {
+	defaultBus.Unsubscribe(c)
+}
@@ -0,0 +1,75 @@
+package event
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nknorg/nconnect/config"
+	"github.com/stretchr/testify/require"
+)
+
+// go test -v -run=TestWebhook
+func TestWebhook(t *testing.T) {
+	received := make(chan *http.Request, 1)
+	bodies := make(chan []byte, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		received <- r
+		bodies <- b
+	}))
+	defer srv.Close()
+
+	go startWebhook(config.Webhook{URL: srv.URL, Secret: "secret", Events: []string{string(MemberOnline)}})
+	require.Eventually(t, func() bool {
+		defaultBus.Lock()
+		defer defaultBus.Unlock()
+		return len(defaultBus.subscribers) > 0
+	}, time.Second, 10*time.Millisecond)
+
+	Emit(MemberOffline, nil)
+	Emit(MemberOnline, map[string]string{"name": "alice"})
+
+	r, b := <-received, <-bodies
+	require.Equal(t, string(MemberOnline), r.Header.Get(EventHeader))
+	require.Equal(t, Sign(b, "secret"), r.Header.Get(SignatureHeader))
+	e := &Event{}
+	require.NoError(t, json.Unmarshal(b, e))
+	require.Equal(t, MemberOnline, e.Type)
+}
+
+// go test -v -run=TestSSE
+func TestSSE(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(ServeSSE))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "?events=" + string(LowBalance))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	Emit(TunaEnabled, nil)
+	Emit(LowBalance, "0.1")
+
+	reader := bufio.NewReader(resp.Body)
+	for {
+		line, err := reader.ReadString('\n')
+		require.NoError(t, err)
+		if strings.HasPrefix(line, "event: ") {
+			require.Equal(t, "event: lowBalance\n", line)
+			break
+		}
+	}
+}
+
+// go test -v -run=TestNknAddrs
+func TestNknAddrs(t *testing.T) {
+	pk := strings.Repeat("ab", 32)
+	addrs := NknAddrs([]string{pk + "$", "^nkn." + pk + "$", "nConnect.*", ".*"})
+	require.Equal(t, []string{pk, "nkn." + pk}, addrs)
+}
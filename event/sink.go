@@ -0,0 +1,184 @@
+package event
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/nknorg/nconnect/config"
+	"github.com/nknorg/nkn-sdk-go"
+)
+
+const (
+	SignatureHeader = "X-Nconnect-Signature"
+	EventHeader     = "X-Nconnect-Event"
+
+	webhookRetries    = 3
+	webhookRetryDelay = 2 * time.Second
+)
+
+// nknAddrRegex matches plain NKN client address, an optional identifier and a public key.
+var nknAddrRegex = regexp.MustCompile(`^([^.\s]+\.)*[0-9a-fA-F]{64}$`)
+
+// Start posts events to webhooks in conf, and sends events to plain NKN addresses in
+// adminAddrs by NKN message through mc if EventNknNotify is enabled.
+func Start(conf *config.Config, adminAddrs func() []string, mc *nkn.MultiClient) {
+	for _, hook := range conf.Webhooks {
+		go startWebhook(hook)
+	}
+	if conf.EventNknNotify && mc != nil {
+		go startNknNotify(adminAddrs, mc)
+	}
+}
+
+// Sign returns the signature of body with secret, which is put in SignatureHeader.
+func Sign(body []byte, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func startWebhook(hook config.Webhook) {
+	types := make(map[Type]bool, len(hook.Events))
+	for _, t := range hook.Events {
+		types[Type(t)] = true
+	}
+
+	c := Subscribe()
+	defer Unsubscribe(c)
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	for e := range c {
+		if len(types) > 0 && !types[e.Type] {
+			continue
+		}
+		body, err := json.Marshal(e)
+		if err != nil {
+			log.Println("Marshal event error:", err)
+			continue
+		}
+		for i := 0; i < webhookRetries; i++ {
+			if i > 0 {
+				time.Sleep(webhookRetryDelay * time.Duration(i))
+			}
+			if err = postEvent(client, hook, e, body); err == nil {
+				break
+			}
+		}
+		if err != nil {
+			log.Printf("Post event %v to webhook %v error: %v", e.Type, hook.URL, err)
+		}
+	}
+}
+
+func postEvent(client *http.Client, hook config.Webhook, e *Event, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, string(e.Type))
+	if len(hook.Secret) > 0 {
+		req.Header.Set(SignatureHeader, Sign(body, hook.Secret))
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("unexpected status %v", resp.Status)
+	}
+	return nil
+}
+
+// NknAddrs returns patterns that are plain NKN addresses, with optional ^ and $ removed.
+func NknAddrs(patterns []string) []string {
+	addrs := make([]string, 0, len(patterns))
+	for _, p := range patterns {
+		p = strings.TrimSuffix(strings.TrimPrefix(p, "^"), "$")
+		if nknAddrRegex.MatchString(p) {
+			addrs = append(addrs, p)
+		}
+	}
+	return addrs
+}
+
+func startNknNotify(adminAddrs func() []string, mc *nkn.MultiClient) {
+	c := Subscribe()
+	defer Unsubscribe(c)
+
+	for e := range c {
+		addrs := NknAddrs(adminAddrs())
+		if len(addrs) == 0 {
+			continue
+		}
+		b, err := json.Marshal(e)
+		if err != nil {
+			log.Println("Marshal event error:", err)
+			continue
+		}
+		_, err = mc.Send(nkn.NewStringArray(addrs...), b, &nkn.MessageConfig{NoReply: true})
+		if err != nil {
+			log.Printf("Send event %v by NKN message error: %v", e.Type, err)
+		}
+	}
+}
+
+// ServeSSE streams events to http client as server-sent events until it disconnects. Query
+// parameter "events" filters event types, separated by comma.
+func ServeSSE(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	types := make(map[Type]bool)
+	for _, t := range strings.Split(r.URL.Query().Get("events"), ",") {
+		if len(t) > 0 {
+			types[Type(t)] = true
+		}
+	}
+
+	c := Subscribe()
+	defer Unsubscribe(c)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(30 * time.Second)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case e := <-c:
+			if len(types) > 0 && !types[e.Type] {
+				continue
+			}
+			b, err := json.Marshal(e)
+			if err != nil {
+				log.Println("Marshal event error:", err)
+				continue
+			}
+			fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, b)
+			flusher.Flush()
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
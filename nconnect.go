@@ -4,6 +4,7 @@ import (
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net"
 	"os"
@@ -17,8 +18,11 @@ import (
 	"github.com/imdario/mergo"
 	"github.com/nknorg/nconnect/admin"
 	"github.com/nknorg/nconnect/arch"
+	"github.com/nknorg/nconnect/audit"
 	"github.com/nknorg/nconnect/config"
+	"github.com/nknorg/nconnect/event"
 	"github.com/nknorg/nconnect/network"
+	"github.com/nknorg/nconnect/quota"
 	"github.com/nknorg/nconnect/ss"
 	"github.com/nknorg/nconnect/util"
 	"github.com/nknorg/ncp-go"
@@ -44,6 +48,7 @@ type nconnect struct {
 	ssClientConfig *ss.Config
 	ssServerConfig *ss.Config
 	persistConf    *config.Config
+	tunaPriceURL   string // url to get tuna max price from, empty if price is not dynamic
 
 	adminClientCache   *admin.Client
 	sync.RWMutex                                     // lock for maps
@@ -53,12 +58,19 @@ type nconnect struct {
 	clientTunnels []*tunnel.Tunnel // tunnels for client mode
 	serverTunnel  *tunnel.Tunnel   // tunnel for server mode
 	serverReady   chan struct{}    // channel to notify server is ready
+	usage         *quota.Store     // traffic usage of clients for server mode
 
 	tunaNode *types.Node // It is used to connect specified tuna node, mainly is for testing.
 
 	networkMember  *network.Member
 	networkTunnels map[string]*tunnel.Tunnel // tunnels for network nodes
-	routeCIDRs     []*net.IPNet              // CIDRs for routing traffic through network nodes
+	networkRoutes  map[string][]*net.IPNet   // routes through network nodes, keyed by node server address
+
+	networkCIDRToClient map[string]string // map CIDRs advertised by network nodes to local tunnel address
+	routeCIDRs          []*net.IPNet      // CIDRs for routing traffic through network nodes
+
+	controlOnce sync.Once
+	logs        *util.LogBuffer // latest logs for command line
 }
 
 func NewNconnect(opts *config.Opts) (*nconnect, error) {
@@ -83,13 +95,18 @@ func NewNconnect(opts *config.Opts) (*nconnect, error) {
 		return nil, err
 	}
 
+	var logOutput io.Writer = os.Stderr
 	if len(opts.LogFileName) > 0 {
-		log.SetOutput(&lumberjack.Logger{
+		logOutput = &lumberjack.Logger{
 			Filename:   opts.LogFileName,
 			MaxSize:    opts.LogMaxSize,
 			MaxBackups: opts.LogMaxBackups,
-		})
+		}
 	}
+	logs := util.NewLogBuffer(maxLogLines)
+	log.SetOutput(io.MultiWriter(logOutput, logs))
+	audit.SetPath(opts.DataPath(audit.DefaultPath))
+	arch.RouteJournal = opts.DataPath(arch.RouteJournalFile)
 
 	seed, err := hex.DecodeString(opts.Seed)
 	if err != nil {
@@ -204,9 +221,29 @@ func NewNconnect(opts *config.Opts) (*nconnect, error) {
 	dialConfig := &nkn.DialConfig{
 		DialTimeout: opts.DialTimeout,
 	}
+	if opts.RouteTable > 0 && opts.IsTuna() {
+		// tuna sessions dial tuna nodes by their own dialer, which can't set firewall mark
+		return nil, errors.New("--route-table can't be used with --tuna, tuna connections can't be marked and would loop back into the tunnel")
+	}
+	if opts.RouteTable > 0 || opts.KillSwitch || opts.Tproxy {
+		dialContext := arch.MarkedDialContext(opts.FwMark)
+		clientConfig.WsDialContext = dialContext
+		clientConfig.HttpDialContext = dialContext
+		walletConfig.HttpDialContext = dialContext
+	}
+	if opts.KillSwitch || opts.Tproxy {
+		if err := arch.SetExemptGroup(opts.FwGroup); err != nil {
+			return nil, err
+		}
+	}
 
+	var tunaPriceURL string
 	if util.IsValidUrl(opts.TunaMaxPrice) {
-		price, err := util.GetRemotePrice(opts.TunaMaxPrice)
+		tunaPriceURL = opts.TunaMaxPrice
+		price, err := util.GetRemotePrice(tunaPriceURL)
+		if err == nil {
+			price, err = util.ClampPrice(price, "", opts.TunaPriceFloor, opts.TunaPriceCeil, 0)
+		}
 		if err != nil {
 			log.Printf("Get remote price error: %v", err)
 			price = config.FallbackTunaMaxPrice
@@ -266,6 +303,7 @@ func NewNconnect(opts *config.Opts) (*nconnect, error) {
 		ssClientConfig.UDPSocks = true
 	}
 	ssServerConfig := ssClientConfig
+	ssServerConfig.Raw = opts.Protocol == config.ProtocolRaw
 
 	nc := &nconnect{
 		opts:           opts,
@@ -276,11 +314,14 @@ func NewNconnect(opts *config.Opts) (*nconnect, error) {
 		ssServerConfig: &ssServerConfig,
 		walletConfig:   walletConfig,
 		persistConf:    persistConf,
+		tunaPriceURL:   tunaPriceURL,
 
 		remoteInfoCache:    make(map[string]*admin.GetInfoJSON),
 		remoteInfoByTunnel: make(map[string]*admin.GetInfoJSON),
 		networkTunnels:     make(map[string]*tunnel.Tunnel),
+		networkRoutes:      make(map[string][]*net.IPNet),
 		serverReady:        make(chan struct{}, 1),
+		logs:               logs,
 	}
 
 	return nc, nil
@@ -313,7 +354,10 @@ func (nc *nconnect) getAdminClient() (*admin.Client, error) {
 
 // Lazy get remote info to avoid unnecessary rpc call.
 func (nc *nconnect) getRemoteInfo(remoteAdminAddr string) (*admin.GetInfoJSON, error) {
-	if info, ok := nc.remoteInfoCache[remoteAdminAddr]; ok {
+	nc.RLock()
+	info, ok := nc.remoteInfoCache[remoteAdminAddr]
+	nc.RUnlock()
+	if ok {
 		return info, nil
 	}
 
@@ -327,8 +371,10 @@ func (nc *nconnect) getRemoteInfo(remoteAdminAddr string) (*admin.GetInfoJSON, e
 		return nil, fmt.Errorf("get remote server info error: %v. make sure server is online and accepting this client address", err)
 	}
 
+	nc.Lock()
 	nc.remoteInfoCache[remoteAdminAddr] = remoteInfoCache
 	nc.remoteInfoByTunnel[remoteInfoCache.Addr] = remoteInfoCache
+	nc.Unlock()
 
 	return remoteInfoCache, nil
 }
@@ -341,8 +387,23 @@ func (nc *nconnect) StartClient() error {
 		}
 	}
 
+	if nc.opts.VPN || nc.opts.NetworkMember || nc.opts.Tproxy {
+		if err := arch.CleanStaleRoutes(); err != nil {
+			log.Println("Clean stale routes error:", err)
+		}
+	}
+	if nc.opts.VPN || nc.opts.NetworkMember {
+		if nc.opts.RouteTable > 0 {
+			if err := arch.SetRoutingPolicy(nc.opts.RouteTable, nc.opts.FwMark); err != nil {
+				return err
+			}
+			defer arch.RemoveRoutingPolicy()
+		}
+	}
+
 	remoteTunnelAddr := nc.opts.RemoteTunnelAddr
 	if len(remoteTunnelAddr) == 0 {
+		remoteTuna, remoteRaw := false, true
 		for _, remoteAdminAddr := range nc.opts.RemoteAdminAddr {
 			remoteInfo, err := nc.getRemoteInfo(remoteAdminAddr)
 			if err != nil {
@@ -350,7 +411,20 @@ func (nc *nconnect) StartClient() error {
 				continue
 			}
 			remoteTunnelAddr = append(remoteTunnelAddr, remoteInfo.Addr)
+			remoteTuna = remoteTuna || remoteInfo.Tuna
+			remoteRaw = remoteRaw && remoteInfo.SupportsProtocol(config.ProtocolRaw)
+		}
+		if nc.opts.IsTuna() && len(remoteTunnelAddr) > 0 && !remoteTuna {
+			log.Println("Tuna is disabled on remote servers, fall back to non-tuna sessions")
+			nc.opts.SetTuna(false)
+		}
+		nc.ssClientConfig.Raw = nc.opts.Protocol == config.ProtocolRaw && len(remoteTunnelAddr) > 0 && remoteRaw
+		if nc.opts.Protocol == config.ProtocolRaw && len(remoteTunnelAddr) > 0 && !remoteRaw {
+			log.Println("Raw protocol is not supported by remote servers, fall back to aead protocol")
 		}
+		// the direct path has no cipher to keep for old clients, so it uses raw protocol
+		// whenever remote servers accept it
+		nc.ssClientConfig.DirectRaw = nc.opts.TunDirect && len(remoteTunnelAddr) > 0 && remoteRaw
 	}
 	if !nc.opts.NetworkMember && len(remoteTunnelAddr) == 0 {
 		return fmt.Errorf("no remote tunnel address, start client fail")
@@ -381,7 +455,7 @@ func (nc *nconnect) StartClient() error {
 		}
 
 		identifier := config.RandomIdentifier()
-		tunnels, err := tunnel.NewTunnels(nc.account, identifier, from, to, nc.opts.Tuna, nc.tunnelConfig, nil)
+		tunnels, err := tunnel.NewTunnels(nc.account, identifier, from, to, nc.opts.IsTuna(), nc.tunnelConfig, nil)
 		if err != nil {
 			return err
 		}
@@ -397,9 +471,38 @@ func (nc *nconnect) StartClient() error {
 
 	log.Println("nConnect socks proxy listen address:", nc.opts.LocalSocksAddr)
 
+	if nc.opts.Tproxy {
+		// redirected TCP connections are to the address of the interface they come in
+		nc.ssClientConfig.RedirTCP = fmt.Sprintf(":%d", nc.opts.TproxyPort)
+		if nc.opts.UDP {
+			nc.ssClientConfig.TproxyUDP = arch.TproxyUDPAddr(nc.opts.TproxyPort)
+		}
+		if err := nc.enableTproxy(); err != nil {
+			return err
+		}
+		defer arch.DisableTproxy()
+	}
+
 	if nc.opts.Tun || nc.opts.VPN {
 		if !nc.opts.NetworkMember {
-			err := arch.OpenTun(nc.opts.TunName, nc.opts.TunAddr, nc.opts.TunGateway, nc.opts.TunMask, nc.opts.TunDNS[0], nc.opts.LocalSocksAddr)
+			tunConf := &arch.TunConfig{
+				Name:       nc.opts.TunName,
+				Addr:       nc.opts.TunAddr,
+				Gateway:    nc.opts.TunGateway,
+				Mask:       nc.opts.TunMask,
+				DNS:        nc.opts.TunDNS,
+				MTU:        nc.opts.TunMTU,
+				UDPTimeout: time.Duration(nc.opts.TunUDPTimeout) * time.Second,
+				SocksAddr:  nc.opts.LocalSocksAddr,
+			}
+			if nc.opts.TunDirect {
+				tunConf.Dial = ss.Dial
+				tunConf.ListenPacket = ss.ListenPacket
+			}
+			if nc.opts.UDP {
+				tunConf.ICMPEcho = ss.ICMPEcho
+			}
+			err := arch.OpenTun(tunConf)
 			if err != nil {
 				log.Printf("OpenTun error: %v", err)
 			} else {
@@ -410,13 +513,30 @@ func (nc *nconnect) StartClient() error {
 		if nc.opts.VPN {
 			vpnCIDR, err := arch.SetVPNRoutes(nc.opts.TunName, nc.opts.TunGateway, vpnRoutes)
 			if err != nil {
+				arch.RemoveVPNRoutes(nc.opts.TunName, nc.opts.TunGateway, vpnCIDR)
 				return err
 			}
 			nc.routeCIDRs = vpnCIDR
-			defer arch.RemoveVPNRoutes(nc.opts.TunName, nc.opts.TunGateway, nc.routeCIDRs)
+			defer func() {
+				if err := arch.RemoveVPNRoutes(nc.opts.TunName, nc.opts.TunGateway, nc.routeCIDRs); err != nil {
+					log.Println("Remove VPN routes error:", err)
+				}
+			}()
+
+			if nc.opts.KillSwitch {
+				allow, err := parseCIDRs(nc.opts.KillSwitchAllow)
+				if err != nil {
+					return err
+				}
+				if err = arch.EnableKillSwitch(nc.opts.TunName, nc.opts.FwMark, allow); err != nil {
+					return err
+				}
+				defer arch.DisableKillSwitch()
+			}
 		}
 	}
 
+	nc.StartControlService()
 	nc.startSSAndTunnel(true)
 	nc.waitForSignal()
 
@@ -437,7 +557,8 @@ func (nc *nconnect) StartServer() error {
 	nc.ssServerConfig.Server = ssAddr
 	nc.ssServerConfig.Client = ""
 
-	if nc.opts.Tuna {
+	tunaConfigured := nc.opts.IsTuna()
+	if nc.opts.IsTuna() {
 		minBalance, err := common.StringToFixed64(nc.opts.TunaMinBalance)
 		if err != nil {
 			return err
@@ -455,7 +576,7 @@ func (nc *nconnect) StartServer() error {
 			} else if balance.ToFixed64() < minBalance {
 				log.Printf("Wallet balance %s is less than minimal balance to enable tuna %s, tuna will not be enabled",
 					balance.String(), nc.opts.TunaMinBalance)
-				nc.opts.Tuna = false
+				nc.opts.SetTuna(false)
 			}
 		}
 	}
@@ -463,12 +584,29 @@ func (nc *nconnect) StartServer() error {
 	if nc.tunaNode != nil {
 		nc.tunnelConfig.TunaNode = nc.tunaNode
 	}
-	t, err := tunnel.NewTunnel(nc.account, nc.opts.Identifier, "", ssAddr, nc.opts.Tuna, nc.tunnelConfig, nil)
+	t, err := tunnel.NewTunnel(nc.account, nc.opts.Identifier, "", ssAddr, nc.opts.IsTuna(), nc.tunnelConfig, nil)
 	if err != nil {
 		return err
 	}
 	nc.serverTunnel = t
 	log.Println("nConnect server tunnel listen address:", t.FromAddr())
+
+	event.SetSource(t.FromAddr())
+	event.Start(&nc.opts.Config, nc.persistConf.GetAdminAddrs, t.MultiClient())
+
+	nc.usage, err = quota.NewStore(nc.opts.DataPath(usageFile), nc.opts.ClientLimits)
+	if err != nil {
+		return err
+	}
+	go nc.usage.FlushEvery(usageFlushInterval)
+	go nc.usage.SaveEvery(usageSaveInterval)
+	if nc.opts.IsTuna() {
+		go nc.updateTunaNodes()
+	}
+	go nc.startBalanceMonitor(tunaConfigured)
+	if nc.opts.IsTuna() && len(nc.tunaPriceURL) > 0 {
+		go nc.refreshTunaPrice()
+	}
 	if nc.networkMember != nil {
 		nc.networkMember.SetServerTunnel(t)
 	}
@@ -479,7 +617,7 @@ func (nc *nconnect) StartServer() error {
 			if len(nc.opts.Identifier) > 0 {
 				identifier += "." + nc.opts.Identifier
 			}
-			err := admin.StartNKNServer(nc.account, identifier, nc.clientConfig, t, nc.persistConf, &nc.opts.Config)
+			err := admin.StartNKNServer(nc.account, identifier, nc.clientConfig, t, nc.persistConf, &nc.opts.Config, nc.usage)
 			if err != nil {
 				log.Fatal(err)
 			}
@@ -490,7 +628,7 @@ func (nc *nconnect) StartServer() error {
 
 	if len(nc.opts.AdminHTTPAddr) > 0 {
 		go func() {
-			err := admin.StartWebServer(nc.opts.AdminHTTPAddr, t, nc.persistConf, &nc.opts.Config)
+			err := admin.StartWebServer(nc.opts.AdminHTTPAddr, t, nc.persistConf, &nc.opts.Config, nc.usage)
 			if err != nil {
 				log.Fatal(err)
 			}
@@ -501,6 +639,7 @@ func (nc *nconnect) StartServer() error {
 
 	nc.serverReady <- struct{}{}
 
+	nc.StartControlService()
 	nc.startSSAndTunnel(false)
 	nc.waitForSignal()
 
@@ -514,6 +653,9 @@ func (nc *nconnect) startSSAndTunnel(client bool) {
 	} else {
 		ssConfig = nc.ssServerConfig
 	}
+	if client && nc.opts.TunDirect {
+		ss.SetTunnelDialer(nc.dialTunnel)
+	}
 	go func() {
 		err := ss.Start(ssConfig)
 		if err != nil {
@@ -534,7 +676,7 @@ func (nc *nconnect) startSSAndTunnel(client bool) {
 		}
 	} else {
 		go func() {
-			err := nc.serverTunnel.Start()
+			err := nc.startServerTunnel()
 			if err != nil {
 				log.Fatal(err)
 			}
@@ -543,11 +685,98 @@ func (nc *nconnect) startSSAndTunnel(client bool) {
 	}
 }
 
+// dialTunnel connects to the remote side of the tunnel listening on addr directly, skipping
+// the tunnel's local listener. Only tunnels to NKN addresses nConnect learned itself, from
+// remote server info or network nodes, are dialed directly, others through the listener.
+func (nc *nconnect) dialTunnel(addr string) (net.Conn, error) {
+	var t *tunnel.Tunnel
+	nc.RLock()
+	for _, ct := range nc.clientTunnels {
+		if _, ok := nc.remoteInfoByTunnel[ct.ToAddr()]; ok && ct.FromAddr() == addr {
+			t = ct
+			break
+		}
+	}
+	if t == nil {
+		for _, nt := range nc.networkTunnels {
+			if nt.FromAddr() == addr {
+				t = nt
+				break
+			}
+		}
+	}
+	nc.RUnlock()
+	if t == nil || t.IsClosed() {
+		return net.Dial("tcp", addr)
+	}
+
+	var session *ncp.Session
+	var err error
+	if tsClient := t.TunaSessionClient(); tsClient != nil {
+		session, err = tsClient.DialWithConfig(t.ToAddr(), nc.tunnelConfig.DialConfig)
+	} else {
+		session, err = t.MultiClient().DialWithConfig(t.ToAddr(), nc.tunnelConfig.DialConfig)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
 func (nc *nconnect) waitForSignal() {
 	sigs := make(chan os.Signal, 1)
 	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM, os.Interrupt)
 	s := <-sigs
 	log.Printf("Received signal '%v', exiting now...", s)
+	if nc.usage != nil {
+		if err := nc.usage.Save(); err != nil {
+			log.Println("Save client usage error:", err)
+		}
+	}
+	if err := nc.Close(); err != nil {
+		log.Println("Close nConnect error:", err)
+	}
+}
+
+// Close closes control socket and tunnels, and removes routes, routing policy rules,
+// transparent proxy rules and kill switch set up by nConnect. Kill switch is removed last so
+// no traffic leaks while closing.
+func (nc *nconnect) Close() error {
+	nc.RLock()
+	tunnels := make([]*tunnel.Tunnel, 0, len(nc.clientTunnels)+len(nc.networkTunnels)+1)
+	tunnels = append(tunnels, nc.clientTunnels...)
+	for _, t := range nc.networkTunnels {
+		tunnels = append(tunnels, t)
+	}
+	if nc.serverTunnel != nil {
+		tunnels = append(tunnels, nc.serverTunnel)
+	}
+	nc.RUnlock()
+
+	var errs []error
+	if err := network.CloseControl(nc.opts.ControlSocketPath()); err != nil {
+		errs = append(errs, err)
+	}
+	for _, t := range tunnels {
+		if !t.IsClosed() {
+			if err := t.Close(); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	if err := arch.RemoveInstalledRoutes(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := arch.RemoveRoutingPolicy(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := arch.DisableTproxy(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := arch.DisableKillSwitch(); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
 }
 
 func (nc *nconnect) SetTunaNode(node *types.Node) {
@@ -592,6 +821,12 @@ func (nc *nconnect) StartNetworkManager() error {
 		}
 	}()
 
+	go func() {
+		if err := m.StartControlService(); err != nil {
+			log.Println("Start control service error:", err)
+		}
+	}()
+
 	nc.waitForSignal()
 
 	return nil
@@ -626,6 +861,12 @@ func (nc *nconnect) StartNetworkMember() error {
 	}
 
 	nc.networkMember.CbNodeICanAccessUpdated = nc.setupNetworkTunnel
+	nc.networkMember.CbDiagnose = func(node *network.NodeInfo) (*network.DiagResult, error) {
+		return nc.diagnoseNode(node, network.DefaultDiagCount, network.DefaultDiagDuration)
+	}
+	if nc.opts.Server {
+		nc.startDiagServer()
+	}
 	go func() {
 		err = nc.networkMember.StartMember(serverAddr)
 		if err != nil {
@@ -642,8 +883,8 @@ func (nc *nconnect) StartNetworkMember() error {
 		}()
 	}
 
-	// Start Cli Service
-	go nc.networkMember.StartCliService()
+	// Start control service for command line
+	nc.StartControlService()
 
 	nc.waitForSignal()
 	return nil
@@ -655,18 +896,29 @@ func (nc *nconnect) setupNetworkTunnel(nodes []*network.NodeInfo) error {
 	}
 
 	oldTunnels := make(map[string]struct{})
+	nc.RLock()
 	for addr := range nc.networkTunnels {
 		oldTunnels[addr] = struct{}{}
 	}
+	nc.RUnlock()
 
-	var cidrs []*net.IPNet
 	var from, to []string
+	nodeRoutes := make(map[string][]*net.IPNet) // routes through each node, keyed by node server address
+	nodeClients := make(map[string]string)      // local tunnel address of each node, keyed by node server address
 	for _, node := range nodes {
 		if node.ServerAddress == "" {
 			continue
 		}
+		if _, ok := nodeRoutes[node.ServerAddress]; ok {
+			continue
+		}
+		nodeRoutes[node.ServerAddress] = nc.nodeCIDRs(node)
+
 		if _, ok := oldTunnels[node.ServerAddress]; ok {
 			delete(oldTunnels, node.ServerAddress)
+			nc.RLock()
+			nodeClients[node.ServerAddress] = nc.networkTunnels[node.ServerAddress].FromAddr()
+			nc.RUnlock()
 			continue
 		}
 
@@ -675,19 +927,10 @@ func (nc *nconnect) setupNetworkTunnel(nodes []*network.NodeInfo) error {
 			return err
 		}
 		ssAddr := "127.0.0.1:" + strconv.Itoa(port)
-
-		toAddr := node.ServerAddress
-		nc.ssClientConfig.TargetToClient[node.IP] = ssAddr
+		nodeClients[node.ServerAddress] = ssAddr
 
 		from = append(from, ssAddr)
-		to = append(to, toAddr)
-		delete(oldTunnels, toAddr)
-
-		_, cidr, err := net.ParseCIDR(fmt.Sprintf("%s/32", node.IP))
-		if err != nil {
-			continue
-		}
-		cidrs = append(cidrs, cidr)
+		to = append(to, node.ServerAddress)
 	}
 
 	var mc *nkn.MultiClient
@@ -698,7 +941,7 @@ func (nc *nconnect) setupNetworkTunnel(nodes []*network.NodeInfo) error {
 	if len(from) > 0 {
 		identifier := config.RandomIdentifier()
 
-		tunnels, err := tunnel.NewTunnels(nc.account, identifier, from, to, nc.opts.Tuna, nc.tunnelConfig, mc)
+		tunnels, err := tunnel.NewTunnels(nc.account, identifier, from, to, nc.opts.IsTuna(), nc.tunnelConfig, mc)
 		if err != nil {
 			return err
 		}
@@ -707,9 +950,6 @@ func (nc *nconnect) setupNetworkTunnel(nodes []*network.NodeInfo) error {
 			nc.ssClientConfig.DefaultClient = from[0]
 		}
 
-		arch.SetVPNRoutes(nc.opts.TunName, nc.networkMember.GetNetworkInfo().Gateway, cidrs)
-		ss.UpdateTargetToClient(nc.ssClientConfig.TargetToClient)
-
 		for _, tunel := range tunnels {
 			go func(t *tunnel.Tunnel) {
 				log.Println("Connecting to tunnel:", t.ToAddr())
@@ -721,6 +961,7 @@ func (nc *nconnect) setupNetworkTunnel(nodes []*network.NodeInfo) error {
 						log.Printf("nconnect tunnel to %v started\n", t.ToAddr())
 					}
 				}
+				nc.removeNetworkTunnel(t)
 			}(tunel)
 
 			nc.Lock()
@@ -730,14 +971,152 @@ func (nc *nconnect) setupNetworkTunnel(nodes []*network.NodeInfo) error {
 	}
 
 	for addr := range oldTunnels {
+		nc.Lock()
 		t := nc.networkTunnels[addr]
-		t.Close()
 		delete(nc.networkTunnels, addr)
+		nc.Unlock()
+		t.Close()
 	}
 
+	nc.updateNetworkRoutes(nodeRoutes, nodeClients)
+
 	return nil
 }
 
+// removeNetworkTunnel removes a closed tunnel to a network node and the routes through it,
+// they are set up again when the node is in the next node list.
+func (nc *nconnect) removeNetworkTunnel(t *tunnel.Tunnel) {
+	addr := t.ToAddr()
+	nodeRoutes := make(map[string][]*net.IPNet)
+	nodeClients := make(map[string]string)
+	nc.Lock()
+	if nc.networkTunnels[addr] != t { // replaced or closed by setupNetworkTunnel
+		nc.Unlock()
+		return
+	}
+	delete(nc.networkTunnels, addr)
+	for a, cidrs := range nc.networkRoutes {
+		if a == addr {
+			continue
+		}
+		nodeRoutes[a] = cidrs
+		if t, ok := nc.networkTunnels[a]; ok {
+			nodeClients[a] = t.FromAddr()
+		}
+	}
+	nc.Unlock()
+
+	log.Printf("Tunnel to %v is closed, removing routes through it\n", addr)
+	nc.updateNetworkRoutes(nodeRoutes, nodeClients)
+}
+
+// nodeCIDRs returns the CIDRs routed through a network node: its own IP and the routes it
+// advertises which are approved by manager and don't overlap the network or other members.
+func (nc *nconnect) nodeCIDRs(node *network.NodeInfo) []*net.IPNet {
+	var cidrs []*net.IPNet
+	if ip := net.ParseIP(node.IP).To4(); ip != nil {
+		cidrs = append(cidrs, &net.IPNet{IP: ip, Mask: net.CIDRMask(32, 32)})
+	}
+	return append(cidrs, nc.networkMember.NodeRoutes(node)...)
+}
+
+// updateNetworkRoutes installs the routes through network nodes and removes the routes which
+// are not advertised anymore, then points them to the nodes' tunnels.
+func (nc *nconnect) updateNetworkRoutes(nodeRoutes map[string][]*net.IPNet, nodeClients map[string]string) {
+	gateway := nc.networkMember.GetNetworkInfo().Gateway
+
+	nc.Lock()
+	defer nc.Unlock()
+
+	var added, removed []*net.IPNet
+	for addr, cidrs := range nc.networkRoutes {
+		removed = append(removed, diffCIDRs(cidrs, nodeRoutes[addr])...)
+	}
+	for addr, cidrs := range nodeRoutes {
+		added = append(added, diffCIDRs(cidrs, nc.networkRoutes[addr])...)
+	}
+
+	if len(removed) > 0 {
+		if err := arch.RemoveVPNRoutes(nc.opts.TunName, gateway, removed); err != nil {
+			log.Println("Remove VPN route error:", err)
+		}
+		for _, cidr := range removed {
+			if ones, _ := cidr.Mask.Size(); ones == 32 {
+				delete(nc.ssClientConfig.TargetToClient, cidr.IP.String())
+			}
+		}
+	}
+	if len(added) > 0 {
+		if _, err := arch.SetVPNRoutes(nc.opts.TunName, gateway, added); err != nil {
+			log.Println("Set VPN routes error:", err)
+		}
+	}
+
+	cidrToClient := make(map[string]string)
+	for addr, cidrs := range nodeRoutes {
+		for _, cidr := range cidrs {
+			if ones, _ := cidr.Mask.Size(); ones == 32 {
+				nc.ssClientConfig.TargetToClient[cidr.IP.String()] = nodeClients[addr]
+			} else {
+				cidrToClient[cidr.String()] = nodeClients[addr]
+			}
+		}
+	}
+	nc.networkRoutes = nodeRoutes
+	nc.networkCIDRToClient = cidrToClient
+
+	ss.UpdateTargetToClient(nc.ssClientConfig.TargetToClient)
+	ss.UpdateCIDRToClient(cidrToClient)
+}
+
+// diffCIDRs returns the CIDRs in a but not in b.
+func diffCIDRs(a, b []*net.IPNet) []*net.IPNet {
+	var res []*net.IPNet
+	for _, x := range a {
+		found := false
+		for _, y := range b {
+			if x.String() == y.String() {
+				found = true
+				break
+			}
+		}
+		if !found {
+			res = append(res, x)
+		}
+	}
+	return res
+}
+
+func (nc *nconnect) enableTproxy() error {
+	routes, err := parseCIDRs(nc.opts.TproxyRoute)
+	if err != nil {
+		return err
+	}
+	exclude, err := parseCIDRs(nc.opts.TproxyExclude)
+	if err != nil {
+		return err
+	}
+	return arch.EnableTproxy(&arch.TproxyConfig{
+		Port:    nc.opts.TproxyPort,
+		UDP:     nc.opts.UDP,
+		Routes:  routes,
+		Exclude: exclude,
+		Mark:    nc.opts.FwMark,
+	})
+}
+
+func parseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	ipNets := make([]*net.IPNet, 0, len(cidrs))
+	for _, s := range cidrs {
+		_, ipNet, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, fmt.Errorf("parse CIDR %s error: %v", s, err)
+		}
+		ipNets = append(ipNets, ipNet)
+	}
+	return ipNets, nil
+}
+
 func (nc *nconnect) getRemoteRoutes() ([]*net.IPNet, error) {
 	vpnRoutes := nc.opts.VPNRoute
 	if nc.opts.VPN && len(vpnRoutes) == 0 {
@@ -3,111 +3,133 @@ package network
 import (
 	"encoding/json"
 	"fmt"
-	"log"
-	"net"
-	"time"
-)
 
-const (
-	Cli_RPC = "127.0.0.1:10032"
+	"github.com/nknorg/nconnect/admin"
 )
 
+// Member control socket methods
 const (
-	Cli_Status = iota // Get my network status
-	Cli_List          // List all nodes I can access, and all nodes I accept
-	Cli_Join          // Join a network
-	Cli_Leave         // Leave a network
+	Cli_Status = "status" // Get my network status
+	Cli_List   = "list"   // List all nodes I can access, and all nodes I accept
+	Cli_Join   = "join"   // Join a network
+	Cli_Leave  = "leave"  // Leave a network
+	Cli_Watch  = "watch"  // Stream my network status every time it changes
 )
 
-type CliMsgReq struct {
-	MsgType int `json:"msgType"`
-}
-
 type CliMsgResp struct {
-	MsgType       int          `json:"msgType"`
-	Err           string       `json:"err"`
 	NetworkInfo   *networkInfo `json:"networkInfo"`
 	NodeInfo      *NodeInfo    `json:"nodeInfo"`
 	NodeICanAcces []*NodeInfo  `json:"nodeICanAccess"`
 	NodeIAccept   []*NodeInfo  `json:"nodeIAccept"`
 }
 
-func (m *Member) StartCliService() error {
-	a, err := net.ResolveUDPAddr("udp", Cli_RPC)
-	if err != nil {
-		return err
+// HandleControlRequest handles member requests from command line through the local control socket.
+func (m *Member) HandleControlRequest(req *admin.RpcReq, stream *ControlStream) *admin.RpcResp {
+	resp := &admin.RpcResp{}
+	var err error
+
+	switch req.Method {
+	case Cli_Status:
+		resp.Result = m.cliStatus()
+
+	case Cli_List:
+		if err = m.GetNodeIAccept(); err != nil {
+			break
+		}
+		if err = m.GetNodeICanAccess(); err != nil {
+			break
+		}
+		resp.Result = &CliMsgResp{
+			NetworkInfo:   m.networkData.NetworkInfo,
+			NodeIAccept:   m.networkData.NodesIAccept,
+			NodeICanAcces: m.networkData.NodesICanAccess,
+		}
+
+	case Cli_Join:
+		if err = m.JoinNetwork(m.serverAddress); err != nil {
+			break
+		}
+		resp.Result = m.cliStatus()
+
+	case Cli_Leave:
+		if err = m.LeaveNetwork(); err != nil {
+			break
+		}
+		resp.Result = &CliMsgResp{NetworkInfo: m.networkData.NetworkInfo}
+
+	case Cli_Watch:
+		err = m.watchStatus(stream)
+
+	default:
+		err = errUnknownMethod
 	}
-	udpServer, err := net.ListenUDP("udp", a)
+
 	if err != nil {
-		return err
+		resp.Error = err.Error()
 	}
 
-	defer udpServer.Close()
+	return resp
+}
+
+func (m *Member) cliStatus() *CliMsgResp {
+	return &CliMsgResp{NetworkInfo: m.networkData.NetworkInfo, NodeInfo: m.networkData.NodeInfo}
+}
 
-	b := make([]byte, 1024)
-	var req CliMsgReq
-	var resp CliMsgResp
+// watchStatus streams my network status every time it changes, until the peer stops watching.
+func (m *Member) watchStatus(stream *ControlStream) error {
+	changed := m.watchChanges()
+	defer m.unwatchChanges(changed)
 	for {
-		n, addr, err := udpServer.ReadFromUDP(b)
-		if err != nil {
-			log.Printf("StartCliService.ReadFromUDP err: %v", err)
-			time.Sleep(time.Second)
-			continue
+		if err := stream.Send(m.cliStatus()); err != nil {
+			return err
 		}
-
-		err = json.Unmarshal(b[:n], &req)
-		if err != nil {
-			log.Printf("StartCliService.Unmarshal err: %v\n", err)
-			time.Sleep(time.Second)
-			continue
+		select {
+		case <-changed:
+		case <-stream.Done():
+			return nil
 		}
+	}
+}
 
-		resp.MsgType = req.MsgType
-		switch req.MsgType {
-		case Cli_Status:
-			resp.NetworkInfo = m.networkData.NetworkInfo
-			resp.NodeInfo = m.networkData.NodeInfo
-		case Cli_List:
-			m.GetNodeIAccept()
-			m.GetNodeICanAccess()
-			resp.NetworkInfo = m.networkData.NetworkInfo
-			resp.NodeIAccept = m.networkData.NodesIAccept
-			resp.NodeICanAcces = m.networkData.NodesICanAccess
-		case Cli_Join:
-			m.JoinNetwork(m.serverAddress)
-			resp.NetworkInfo = m.networkData.NetworkInfo
-			resp.NodeInfo = m.networkData.NodeInfo
-		case Cli_Leave:
-			m.LeaveNetwork()
-			resp.NetworkInfo = m.networkData.NetworkInfo
-
-		default:
-			resp.Err = "Unknown msgType"
-		}
+// watchChanges returns a channel which receives a value after member data is changed.
+func (m *Member) watchChanges() chan struct{} {
+	c := make(chan struct{}, 1)
+	m.watchLock.Lock()
+	m.watchers[c] = struct{}{}
+	m.watchLock.Unlock()
+	return c
+}
 
-		buf, err := json.Marshal(resp)
-		if err != nil {
-			log.Printf("StartCliService.Marshal err: %v\n", err)
-			time.Sleep(time.Second)
-			continue
-		}
+func (m *Member) unwatchChanges(c chan struct{}) {
+	m.watchLock.Lock()
+	delete(m.watchers, c)
+	m.watchLock.Unlock()
+}
 
-		_, _, err = udpServer.WriteMsgUDP(buf, nil, addr)
-		if err != nil {
-			log.Printf("StartCliService.WriteMsgUDP err: %v\n", err)
-			time.Sleep(time.Second)
-			continue
+func (m *Member) notifyChanges() {
+	m.watchLock.Lock()
+	defer m.watchLock.Unlock()
+	for c := range m.watchers {
+		select {
+		case c <- struct{}{}:
+		default:
 		}
 	}
 }
 
-func CliStatus() {
-	resp, err := CliRequest(Cli_Status)
+func CliStatus(c *ControlClient) {
+	resp, err := CliRequest(c, Cli_Status)
 	if err != nil {
 		fmt.Println("CliStatus err: ", err)
 		return
 	}
-	fmt.Println("\nNetwork Domain: ", resp.NetworkInfo.Domain)
+	printStatus(resp)
+}
+
+func printStatus(resp *CliMsgResp) {
+	if resp.NetworkInfo != nil {
+		fmt.Println("\nNetwork Domain: ", resp.NetworkInfo.Domain)
+	}
 	if resp.NodeInfo != nil && resp.NodeInfo.IP != "" {
 		fmt.Println("Ip:", resp.NodeInfo.IP, "\tMask:", resp.NodeInfo.Netmask, "\tNode Name:", resp.NodeInfo.Name)
 	} else {
@@ -115,8 +137,8 @@ func CliStatus() {
 	}
 }
 
-func CliList() {
-	resp, err := CliRequest(Cli_List)
+func CliList(c *ControlClient) {
+	resp, err := CliRequest(c, Cli_List)
 	if err != nil {
 		fmt.Println("CliList err: ", err)
 		return
@@ -131,13 +153,15 @@ func CliList() {
 	}
 }
 
-func CliJoin() {
-	resp, err := CliRequest(Cli_Join)
+func CliJoin(c *ControlClient) {
+	resp, err := CliRequest(c, Cli_Join)
 	if err != nil {
 		fmt.Println("CliJoin err: ", err)
 		return
 	}
-	fmt.Println("\nNetwork Domain: ", resp.NetworkInfo.Domain)
+	if resp.NetworkInfo != nil {
+		fmt.Println("\nNetwork Domain: ", resp.NetworkInfo.Domain)
+	}
 	if resp.NodeInfo != nil && resp.NodeInfo.IP != "" {
 		fmt.Println("You have joined the network")
 		fmt.Println("Ip:", resp.NodeInfo.IP, "\tMask:", resp.NodeInfo.Netmask, "\tNode Name:", resp.NodeInfo.Name)
@@ -146,8 +170,8 @@ func CliJoin() {
 	}
 }
 
-func CliLeave() {
-	resp, err := CliRequest(Cli_Leave)
+func CliLeave(c *ControlClient) {
+	resp, err := CliRequest(c, Cli_Leave)
 	if err != nil {
 		fmt.Println("CliLeave err: ", err)
 		return
@@ -159,36 +183,28 @@ func CliLeave() {
 	}
 }
 
-func CliRequest(msgType int) (resp CliMsgResp, err error) {
-	req := CliMsgReq{
-		MsgType: msgType,
-	}
-
-	uc, err := net.Dial("udp", Cli_RPC)
-	if err != nil {
-		log.Println("CliRequest net.Dial err: ", err)
-		return
-	}
-	defer uc.Close()
-
-	send, _ := json.Marshal(req)
-	if _, err = uc.Write(send); err != nil {
-		log.Println("CliRequest.Write err ", err)
-		return
-	}
-
-	b := make([]byte, 65535)
-	n, err := uc.Read(b)
+// CliWatch prints network status every time it changes until the member exits.
+func CliWatch(c *ControlClient) {
+	err := c.RPCStream(Cli_Watch, nil, func(res json.RawMessage, more bool) error {
+		if !more {
+			return nil
+		}
+		resp := &CliMsgResp{}
+		if err := json.Unmarshal(res, resp); err != nil {
+			return err
+		}
+		printStatus(resp)
+		return nil
+	})
 	if err != nil {
-		log.Println("CliRequest.Read err ", err)
-		return
+		fmt.Println("CliWatch err: ", err)
 	}
+}
 
-	err = json.Unmarshal(b[:n], &resp)
-	if err != nil {
-		log.Printf("CliRequest.Unmarshal err: %v\n", err)
-		return
+func CliRequest(c *ControlClient, method string) (*CliMsgResp, error) {
+	resp := &CliMsgResp{}
+	if err := c.RPCCall(method, nil, resp); err != nil {
+		return nil, err
 	}
-
-	return
+	return resp, nil
 }
@@ -0,0 +1,278 @@
+package network
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/nknorg/nconnect/admin"
+)
+
+const (
+	controlDialTimeout = 3 * time.Second
+	controlSocketPerm  = 0600 // only the user running nConnect can use the control socket
+)
+
+var (
+	errControlSocketInUse = errors.New("control socket is used by another running nConnect")
+	errUnknownMethod      = errors.New("unknown method")
+)
+
+// control listeners by socket path, closed by CloseControl
+var (
+	controlLock      sync.Mutex
+	controlListeners = make(map[string]net.Listener)
+)
+
+// RPCCaller calls a json rpc method on a running nConnect, either through the local
+// control socket or through NKN.
+type RPCCaller interface {
+	RPCCall(method string, params interface{}, result interface{}) error
+	// RPCCallOnce is like RPCCall, but sends the request only once, for methods which should
+	// not be handled twice, and waits for the result for timeout besides the round trip.
+	RPCCallOnce(method string, params interface{}, result interface{}, timeout time.Duration) error
+}
+
+// controlResp is a response on control socket. A streaming method sends responses with
+// More set before its final response.
+type controlResp struct {
+	admin.RpcResp
+	More bool `json:"more,omitempty"`
+}
+
+// ControlStream lets a handler send partial results before its final response.
+type ControlStream struct {
+	conn    net.Conn
+	enc     *json.Encoder
+	ctx     context.Context
+	cancel  context.CancelFunc
+	started bool
+}
+
+// Send sends a partial result. The connection is closed after a streaming response,
+// so the peer closing it means it doesn't want more results.
+func (s *ControlStream) Send(result interface{}) error {
+	if !s.started {
+		s.started = true
+		go func() {
+			io.Copy(io.Discard, s.conn)
+			s.cancel()
+		}()
+	}
+	return s.enc.Encode(&controlResp{RpcResp: admin.RpcResp{Result: result}, More: true})
+}
+
+// Done is closed when the peer closes the connection of a streaming response.
+func (s *ControlStream) Done() <-chan struct{} {
+	return s.ctx.Done()
+}
+
+type ControlHandler func(req *admin.RpcReq, stream *ControlStream) *admin.RpcResp
+
+// ServeControl serves json rpc requests on a unix domain socket. Each connection carries
+// newline separated requests, and gets responses for each request in order.
+func ServeControl(path string, handler ControlHandler) error {
+	if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		if conn, err := net.DialTimeout("unix", path, controlDialTimeout); err == nil {
+			conn.Close()
+			return fmt.Errorf("%v: %v", errControlSocketInUse, path)
+		}
+		os.Remove(path) // left by a nConnect which was not closed properly
+	}
+
+	l, err := listenControl(path)
+	if err != nil {
+		return err
+	}
+	controlLock.Lock()
+	controlListeners[path] = l
+	controlLock.Unlock()
+	defer CloseControl(path)
+
+	log.Println("nConnect control socket is listening at:", path)
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go serveControlConn(conn, handler)
+	}
+}
+
+// listenControl listens on a socket created in a new directory only the user can access,
+// then moves it to path, so unlike changing its mode after listening, the socket is never
+// accessible by others.
+func listenControl(path string) (*net.UnixListener, error) {
+	dir, err := os.MkdirTemp(filepath.Dir(path), ".nc")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	tmp := filepath.Join(dir, "s")
+	l, err := net.ListenUnix("unix", &net.UnixAddr{Name: tmp, Net: "unix"})
+	if err != nil {
+		return nil, err
+	}
+	l.SetUnlinkOnClose(false) // the socket is moved, CloseControl removes it
+	if err = os.Chmod(tmp, controlSocketPerm); err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// CloseControl stops serving on control socket at path and removes the socket, it should be
+// called before exiting.
+func CloseControl(path string) error {
+	controlLock.Lock()
+	l, ok := controlListeners[path]
+	delete(controlListeners, path)
+	controlLock.Unlock()
+	if !ok {
+		return nil
+	}
+
+	l.Close()
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func serveControlConn(conn net.Conn, handler ControlHandler) {
+	defer conn.Close()
+
+	dec := json.NewDecoder(conn)
+	enc := json.NewEncoder(conn)
+	for {
+		req := &admin.RpcReq{}
+		if err := dec.Decode(req); err != nil {
+			if err != io.EOF {
+				log.Println("Control socket decode request error:", err)
+			}
+			return
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		stream := &ControlStream{conn: conn, enc: enc, ctx: ctx, cancel: cancel}
+		resp := handler(req, stream)
+		if err := enc.Encode(&controlResp{RpcResp: *resp}); err != nil {
+			if !stream.started {
+				log.Println("Control socket encode response error:", err)
+			}
+			cancel()
+			return
+		}
+		cancel()
+		if stream.started {
+			return
+		}
+	}
+}
+
+// ControlClient calls json rpc methods through the control socket of a local nConnect.
+type ControlClient struct {
+	path string
+}
+
+func NewControlClient(path string) *ControlClient {
+	return &ControlClient{path: path}
+}
+
+func (c *ControlClient) RPCCall(method string, params interface{}, result interface{}) error {
+	return c.rpcCall(method, params, result, 0)
+}
+
+// RPCCallOnce calls a method within timeout, requests on control socket are never sent twice.
+func (c *ControlClient) RPCCallOnce(method string, params interface{}, result interface{}, timeout time.Duration) error {
+	return c.rpcCall(method, params, result, timeout+controlDialTimeout)
+}
+
+func (c *ControlClient) rpcCall(method string, params interface{}, result interface{}, timeout time.Duration) error {
+	return c.rpcStream(method, params, timeout, func(res json.RawMessage, more bool) error {
+		if more || result == nil || len(res) == 0 {
+			return nil
+		}
+		return json.Unmarshal(res, result)
+	})
+}
+
+// RPCStream calls a streaming method, onResult is called for each partial result and
+// the final result until it returns an error.
+func (c *ControlClient) RPCStream(method string, params interface{}, onResult func(result json.RawMessage, more bool) error) error {
+	return c.rpcStream(method, params, 0, onResult)
+}
+
+// rpcStream calls a method like RPCStream, and fails if it doesn't finish within timeout
+// unless timeout is 0.
+func (c *ControlClient) rpcStream(method string, params interface{}, timeout time.Duration, onResult func(result json.RawMessage, more bool) error) error {
+	conn, err := net.DialTimeout("unix", c.path, controlDialTimeout)
+	if err != nil {
+		return fmt.Errorf("connect to nConnect control socket %v error: %v, please make sure nConnect is running", c.path, err)
+	}
+	defer conn.Close()
+	if timeout > 0 {
+		conn.SetDeadline(time.Now().Add(timeout))
+	}
+
+	req := map[string]interface{}{
+		"id":     "nConnect",
+		"method": method,
+		"params": params,
+	}
+	if err = json.NewEncoder(conn).Encode(req); err != nil {
+		return err
+	}
+
+	dec := json.NewDecoder(conn)
+	for {
+		resp := &struct {
+			Result json.RawMessage `json:"result,omitempty"`
+			Error  string          `json:"error,omitempty"`
+			More   bool            `json:"more,omitempty"`
+		}{}
+		if err = dec.Decode(resp); err != nil {
+			return err
+		}
+		if len(resp.Error) > 0 {
+			return errors.New(resp.Error)
+		}
+		if err = onResult(resp.Result, resp.More); err != nil {
+			return err
+		}
+		if !resp.More {
+			return nil
+		}
+	}
+}
+
+// NKNClient calls json rpc methods of a remote nConnect through NKN, the remote
+// nConnect only accepts it if our address is in its admin addresses.
+type NKNClient struct {
+	c       *admin.Client
+	address string
+}
+
+func NewNKNClient(c *admin.Client, address string) *NKNClient {
+	return &NKNClient{c: c, address: address}
+}
+
+func (c *NKNClient) RPCCall(method string, params interface{}, result interface{}) error {
+	return c.c.RPCCall(c.address, method, params, result)
+}
+
+func (c *NKNClient) RPCCallOnce(method string, params interface{}, result interface{}, timeout time.Duration) error {
+	return c.c.RPCCallOnce(c.address, method, params, result, timeout+c.c.ReplyTimeout)
+}
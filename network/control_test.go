@@ -0,0 +1,67 @@
+package network
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nknorg/nconnect/admin"
+	"github.com/stretchr/testify/require"
+)
+
+// go test -v -run=TestControlSocket
+func TestControlSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "nConnect.sock")
+	handler := func(req *admin.RpcReq, stream *ControlStream) *admin.RpcResp {
+		switch req.Method {
+		case "echo":
+			return &admin.RpcResp{Result: req.Params["msg"]}
+		case "count":
+			for i := 0; i < 3; i++ {
+				if err := stream.Send(i); err != nil {
+					return &admin.RpcResp{Error: err.Error()}
+				}
+			}
+			return &admin.RpcResp{Result: 3}
+		default:
+			return &admin.RpcResp{Error: "unknown method"}
+		}
+	}
+	go ServeControl(path, handler)
+
+	c := NewControlClient(path)
+	var res string
+	require.Eventually(t, func() bool {
+		return c.RPCCall("echo", map[string]string{"msg": "hello"}, &res) == nil
+	}, 3*time.Second, 10*time.Millisecond)
+	require.Equal(t, "hello", res)
+
+	fi, err := os.Stat(path)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(controlSocketPerm), fi.Mode().Perm())
+
+	require.EqualError(t, c.RPCCall("foo", nil, &res), "unknown method")
+
+	var counts []string
+	err = c.RPCStream("count", nil, func(res json.RawMessage, more bool) error {
+		counts = append(counts, fmt.Sprintf("%s %v", res, more))
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"0 true", "1 true", "2 true", "3 false"}, counts)
+
+	err = ServeControl(path, handler)
+	require.ErrorContains(t, err, errControlSocketInUse.Error())
+
+	// the socket is created in a temporary directory, which is removed, and the socket is
+	// removed when closed
+	entries, err := os.ReadDir(filepath.Dir(path))
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	require.NoError(t, CloseControl(path))
+	_, err = os.Stat(path)
+	require.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,315 @@
+package network
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net"
+	"time"
+)
+
+// Diagnostics test kinds, sent as the first byte of a diagnostics connection.
+const (
+	diagEcho     byte = 'e' // echo 8 bytes timestamps back until EOF
+	diagUpload   byte = 'u' // read length prefixed chunks until an empty one, then reply total bytes
+	diagDownload byte = 'd' // send length prefixed chunks for the requested duration, then an empty one
+)
+
+const (
+	DefaultDiagCount    = 5
+	DefaultDiagDuration = 2 * time.Second
+	MaxDiagDuration     = 10 * time.Second
+
+	diagChunkSize     = 32 * 1024
+	diagPingInterval  = 100 * time.Millisecond
+	diagPingTimeout   = 2 * time.Second
+	diagExtraDeadline = 5 * time.Second // extra time allowed for a throughput test to finish
+
+	// time manager waits for a member to run diagnostics: pings, upload and download tests
+	// and dialing, which may be longer than the reply timeout of other messages
+	diagReplyTimeout = time.Minute
+)
+
+var (
+	errDiagNotSupported = errors.New("member doesn't support diagnostics, please upgrade its nConnect")
+	errUnknownDiagKind  = errors.New("unknown diagnostics kind")
+	errNoDiagResult     = errors.New("member replied without diagnostics result")
+)
+
+// DiagResult is the result of peer to peer diagnostics through the tunnel path.
+type DiagResult struct {
+	Target       string  `json:"target"`
+	IP           string  `json:"ip"`
+	Sent         int     `json:"sent"`     // number of echo requests
+	Lost         int     `json:"lost"`     // number of echo requests without a reply in time
+	MinRttMs     float64 `json:"minRttMs"` // round trip times through the tunnel
+	AvgRttMs     float64 `json:"avgRttMs"`
+	MaxRttMs     float64 `json:"maxRttMs"`
+	JitterMs     float64 `json:"jitterMs"`     // mean difference of consecutive round trip times
+	UploadMbps   float64 `json:"uploadMbps"`   // throughput from us to target
+	DownloadMbps float64 `json:"downloadMbps"` // throughput from target to us
+}
+
+// ServeDiag serves diagnostics connections on listener until it fails.
+func ServeDiag(listener net.Listener) error {
+	defer listener.Close()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go func() {
+			defer conn.Close()
+			if err := handleDiagConn(conn); err != nil && err != io.EOF {
+				log.Println("Diagnostics connection error:", err)
+			}
+		}()
+	}
+}
+
+func handleDiagConn(conn net.Conn) error {
+	conn.SetDeadline(time.Now().Add(MaxDiagDuration + diagExtraDeadline))
+
+	kind := make([]byte, 1)
+	if _, err := io.ReadFull(conn, kind); err != nil {
+		return err
+	}
+
+	switch kind[0] {
+	case diagEcho:
+		b := make([]byte, 8)
+		for {
+			if _, err := io.ReadFull(conn, b); err != nil {
+				return err
+			}
+			if _, err := conn.Write(b); err != nil {
+				return err
+			}
+		}
+
+	case diagUpload:
+		var total uint64
+		chunk := make([]byte, diagChunkSize)
+		for {
+			n, err := readDiagChunk(conn, chunk)
+			if err != nil {
+				return err
+			}
+			if n == 0 {
+				break
+			}
+			total += uint64(n)
+		}
+		return binary.Write(conn, binary.BigEndian, total)
+
+	case diagDownload:
+		var ms uint32
+		if err := binary.Read(conn, binary.BigEndian, &ms); err != nil {
+			return err
+		}
+		duration := time.Duration(ms) * time.Millisecond
+		if duration > MaxDiagDuration {
+			duration = MaxDiagDuration
+		}
+		chunk := make([]byte, diagChunkSize)
+		for start := time.Now(); time.Since(start) < duration; {
+			if err := writeDiagChunk(conn, chunk); err != nil {
+				return err
+			}
+		}
+		return writeDiagChunk(conn, nil)
+
+	default:
+		return errUnknownDiagKind
+	}
+}
+
+func writeDiagChunk(w io.Writer, chunk []byte) error {
+	if err := binary.Write(w, binary.BigEndian, uint32(len(chunk))); err != nil {
+		return err
+	}
+	_, err := w.Write(chunk)
+	return err
+}
+
+func readDiagChunk(r io.Reader, buf []byte) (int, error) {
+	var n uint32
+	if err := binary.Read(r, binary.BigEndian, &n); err != nil {
+		return 0, err
+	}
+	if int(n) > len(buf) {
+		return 0, fmt.Errorf("diagnostics chunk size %v is too large", n)
+	}
+	return io.ReadFull(r, buf[:n])
+}
+
+// Diagnose measures round trip time with count echo requests, then upload and download
+// throughput for duration each, on connections created by dial.
+func Diagnose(dial func() (net.Conn, error), count int, duration time.Duration) (*DiagResult, error) {
+	if count <= 0 {
+		count = DefaultDiagCount
+	}
+	if duration <= 0 {
+		duration = DefaultDiagDuration
+	}
+	if duration > MaxDiagDuration {
+		duration = MaxDiagDuration
+	}
+
+	res := &DiagResult{}
+	if err := diagEchoTest(dial, count, res); err != nil {
+		return nil, fmt.Errorf("echo test error: %v", err)
+	}
+
+	var err error
+	if res.UploadMbps, err = diagUploadTest(dial, duration); err != nil {
+		return nil, fmt.Errorf("upload test error: %v", err)
+	}
+	if res.DownloadMbps, err = diagDownloadTest(dial, duration); err != nil {
+		return nil, fmt.Errorf("download test error: %v", err)
+	}
+
+	return res, nil
+}
+
+func dialDiag(dial func() (net.Conn, error), kind byte) (net.Conn, error) {
+	conn, err := dial()
+	if err != nil {
+		return nil, err
+	}
+	if _, err = conn.Write([]byte{kind}); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+func diagEchoTest(dial func() (net.Conn, error), count int, res *DiagResult) error {
+	conn, err := dialDiag(dial, diagEcho)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var rtts []time.Duration
+	b := make([]byte, 8)
+	for i := 0; i < count; i++ {
+		if i > 0 {
+			time.Sleep(diagPingInterval)
+		}
+		res.Sent++
+
+		start := time.Now()
+		binary.BigEndian.PutUint64(b, uint64(start.UnixNano()))
+		conn.SetDeadline(start.Add(diagPingTimeout))
+		if _, err = conn.Write(b); err != nil {
+			return err
+		}
+		if _, err = io.ReadFull(conn, b); err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				// a late reply would be read as the next one, so stop here
+				res.Lost += count - i
+				break
+			}
+			return err
+		}
+		rtts = append(rtts, time.Since(start))
+	}
+	if len(rtts) == 0 {
+		return nil
+	}
+
+	res.MinRttMs = math.MaxFloat64
+	var sum, diffs float64
+	for i, rtt := range rtts {
+		ms := float64(rtt) / float64(time.Millisecond)
+		sum += ms
+		res.MinRttMs = math.Min(res.MinRttMs, ms)
+		res.MaxRttMs = math.Max(res.MaxRttMs, ms)
+		if i > 0 {
+			diffs += math.Abs(ms - float64(rtts[i-1])/float64(time.Millisecond))
+		}
+	}
+	res.AvgRttMs = sum / float64(len(rtts))
+	if len(rtts) > 1 {
+		res.JitterMs = diffs / float64(len(rtts)-1)
+	}
+
+	return nil
+}
+
+func diagUploadTest(dial func() (net.Conn, error), duration time.Duration) (float64, error) {
+	conn, err := dialDiag(dial, diagUpload)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	start := time.Now()
+	conn.SetDeadline(start.Add(duration + diagExtraDeadline))
+	chunk := make([]byte, diagChunkSize)
+	for time.Since(start) < duration {
+		if err = writeDiagChunk(conn, chunk); err != nil {
+			return 0, err
+		}
+	}
+	if err = writeDiagChunk(conn, nil); err != nil {
+		return 0, err
+	}
+
+	// wait until target received all data, so buffered data is not counted
+	var total uint64
+	if err = binary.Read(conn, binary.BigEndian, &total); err != nil {
+		return 0, err
+	}
+
+	return mbps(total, time.Since(start)), nil
+}
+
+func diagDownloadTest(dial func() (net.Conn, error), duration time.Duration) (float64, error) {
+	conn, err := dialDiag(dial, diagDownload)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	start := time.Now()
+	conn.SetDeadline(start.Add(duration + diagExtraDeadline))
+	if err = binary.Write(conn, binary.BigEndian, uint32(duration.Milliseconds())); err != nil {
+		return 0, err
+	}
+
+	var total uint64
+	chunk := make([]byte, diagChunkSize)
+	for {
+		n, err := readDiagChunk(conn, chunk)
+		if err != nil {
+			return 0, err
+		}
+		if n == 0 {
+			break
+		}
+		total += uint64(n)
+	}
+
+	return mbps(total, time.Since(start)), nil
+}
+
+func mbps(bytes uint64, d time.Duration) float64 {
+	if d <= 0 {
+		return 0
+	}
+	return float64(bytes) * 8 / 1e6 / d.Seconds()
+}
+
+// PrintDiagResult prints diagnostics result in text format.
+func PrintDiagResult(res *DiagResult) {
+	fmt.Printf("Diagnostics to %v (%v) through tunnel:\n", res.Target, res.IP)
+	fmt.Printf("rtt min/avg/max/jitter = %.1f/%.1f/%.1f/%.1f ms, %v/%v lost\n",
+		res.MinRttMs, res.AvgRttMs, res.MaxRttMs, res.JitterMs, res.Lost, res.Sent)
+	fmt.Printf("upload %.2f Mbps, download %.2f Mbps\n", res.UploadMbps, res.DownloadMbps)
+}
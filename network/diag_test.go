@@ -0,0 +1,29 @@
+package network
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// go test -v -run=TestDiagnose
+func TestDiagnose(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	address := l.Addr().String()
+	go ServeDiag(l)
+
+	dial := func() (net.Conn, error) { return net.Dial("tcp", address) }
+
+	res, err := Diagnose(dial, 3, 200*time.Millisecond)
+	require.NoError(t, err)
+	require.Equal(t, 3, res.Sent)
+	require.Equal(t, 0, res.Lost)
+	require.Greater(t, res.AvgRttMs, 0.0)
+	require.LessOrEqual(t, res.MinRttMs, res.AvgRttMs)
+	require.LessOrEqual(t, res.AvgRttMs, res.MaxRttMs)
+	require.Greater(t, res.UploadMbps, 0.0)
+	require.Greater(t, res.DownloadMbps, 0.0)
+}
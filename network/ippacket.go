@@ -0,0 +1,277 @@
+package network
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/nknorg/nconnect/arch"
+	"github.com/nknorg/nkn-sdk-go"
+)
+
+// IP packet mode: members which enable it exchange raw IP packets read from their TUN
+// devices over NKN sessions, instead of proxying TCP and UDP through tunnels, so ICMP and
+// any IP protocol work between them. A member sends packets to another over the session it
+// dials to it, and receives packets over the sessions others dial to it, so packets never
+// queue up with manager messages. Each packet on a session is prefixed with its length.
+
+const (
+	ipPacketQueueSize   = 1024 // packets to a member are dropped when its send queue is full
+	ipPacketDialTimeout = 10 * time.Second
+	ipPacketRetryDelay  = 5 * time.Second // packets to a member are dropped for this long after dialing it fails
+	maxIPPacketSize     = 65535
+)
+
+var errIPPacketTooLarge = errors.New("IP packet too large")
+
+// ipPacketState is a copy of what IP packet mode needs from network data, updated when
+// network data is saved, so reading TUN device doesn't race with network data updates.
+// Peer IPs are parsed once there, so packets are matched to peers by map lookups.
+type ipPacketState struct {
+	sync.Mutex
+	ip     netip.Addr               // my IP, packets to other IPs are dropped
+	byIP   map[netip.Addr]*NodeInfo // members in IP packet mode I can access or accept, by IP
+	byAddr map[string]*NodeInfo     // the same members by address
+	conns  map[string]*ipPacketConn // sessions sending packets, by member address
+}
+
+// ipPacketConn is the send queue of packets to a member.
+type ipPacketConn struct {
+	peer  *NodeInfo
+	queue chan []byte
+	done  chan struct{} // closed when the member is no longer a peer
+}
+
+// ipPacketAddrs returns source and destination addresses of an IPv4 or IPv6 packet.
+func ipPacketAddrs(pkt []byte) (src, dst netip.Addr, ok bool) {
+	if len(pkt) == 0 {
+		return src, dst, false
+	}
+	switch pkt[0] >> 4 {
+	case 4:
+		if len(pkt) < 20 {
+			return src, dst, false
+		}
+		return netip.AddrFrom4([4]byte(pkt[12:16])), netip.AddrFrom4([4]byte(pkt[16:20])), true
+	case 6:
+		if len(pkt) < 40 {
+			return src, dst, false
+		}
+		return netip.AddrFrom16([16]byte(pkt[8:24])).Unmap(), netip.AddrFrom16([16]byte(pkt[24:40])).Unmap(), true
+	}
+	return src, dst, false
+}
+
+// parseIP parses ip of network data, IPv4-mapped IPv6 addresses are unmapped so they match
+// addresses of IPv4 packets.
+func parseIP(ip string) netip.Addr {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return netip.Addr{}
+	}
+	return addr.Unmap()
+}
+
+// writeIPPacket writes pkt with its length prefix by buf, which has room for the largest packet.
+func writeIPPacket(w io.Writer, buf, pkt []byte) error {
+	if len(pkt) > maxIPPacketSize {
+		return errIPPacketTooLarge
+	}
+	binary.BigEndian.PutUint16(buf, uint16(len(pkt)))
+	n := copy(buf[2:], pkt)
+	_, err := w.Write(buf[:2+n])
+	return err
+}
+
+// readIPPacket reads a packet written by writeIPPacket into buf.
+func readIPPacket(r io.Reader, buf []byte) ([]byte, error) {
+	if _, err := io.ReadFull(r, buf[:2]); err != nil {
+		return nil, err
+	}
+	n := binary.BigEndian.Uint16(buf)
+	if _, err := io.ReadFull(r, buf[:n]); err != nil {
+		return nil, err
+	}
+	return buf[:n], nil
+}
+
+// updateIPPacketPeers copies my IP and members in IP packet mode from network data, and
+// stops sending to members which are no longer peers. Members on either side of an access
+// permission are peers and can start connections to each other, like through tunnels.
+func (m *Member) updateIPPacketPeers() {
+	var ip netip.Addr
+	if m.networkData.NodeInfo != nil {
+		ip = parseIP(m.networkData.NodeInfo.IP)
+	}
+	byIP := make(map[netip.Addr]*NodeInfo)
+	byAddr := make(map[string]*NodeInfo)
+	for _, nodes := range [][]*NodeInfo{m.networkData.NodesICanAccess, m.networkData.NodesIAccept} {
+		for _, n := range nodes {
+			if n == nil || !n.IPPacket {
+				continue
+			}
+			if _, ok := byAddr[n.Address]; ok {
+				continue
+			}
+			byAddr[n.Address] = n
+			if peerIP := parseIP(n.IP); peerIP.IsValid() {
+				byIP[peerIP] = n
+			}
+		}
+	}
+
+	m.ipPacket.Lock()
+	defer m.ipPacket.Unlock()
+	m.ipPacket.ip = ip
+	m.ipPacket.byIP = byIP
+	m.ipPacket.byAddr = byAddr
+	for addr, conn := range m.ipPacket.conns {
+		if _, ok := byAddr[addr]; !ok {
+			close(conn.done)
+			delete(m.ipPacket.conns, addr)
+		}
+	}
+}
+
+// SendIPPacket sends a packet read from TUN device to the member it's destined to. It returns
+// false if the destination is not a member in IP packet mode, then the packet should be
+// handled by the network stack as usual.
+func (m *Member) SendIPPacket(pkt []byte) bool {
+	_, dst, ok := ipPacketAddrs(pkt)
+	if !ok || len(pkt) > maxIPPacketSize {
+		return false
+	}
+
+	m.ipPacket.Lock()
+	defer m.ipPacket.Unlock()
+	peer, ok := m.ipPacket.byIP[dst]
+	if !ok {
+		return false
+	}
+	conn, ok := m.ipPacket.conns[peer.Address]
+	if !ok {
+		conn = &ipPacketConn{
+			peer:  peer,
+			queue: make(chan []byte, ipPacketQueueSize),
+			done:  make(chan struct{}),
+		}
+		m.ipPacket.conns[peer.Address] = conn
+		go m.sendIPPackets(conn)
+	}
+
+	select {
+	case conn.queue <- append([]byte(nil), pkt...):
+	default:
+	}
+	return true
+}
+
+// sendIPPackets dials a session to the member and sends queued packets over it in order,
+// so TUN device reading is not blocked by sending. Packets queued when the session fails
+// are dropped, and the next packet dials a new session.
+func (m *Member) sendIPPackets(conn *ipPacketConn) {
+	defer m.removeIPPacketConn(conn)
+
+	dialConfig := &nkn.DialConfig{DialTimeout: int32(ipPacketDialTimeout / time.Millisecond)}
+	session, err := m.c.DialWithConfig(conn.peer.Address, dialConfig)
+	if err != nil {
+		if m.opts.Verbose {
+			log.Printf("Network member, dial IP packet session to %v error: %v\n", conn.peer.Name, err)
+		}
+		select {
+		case <-time.After(ipPacketRetryDelay):
+		case <-conn.done:
+		}
+		return
+	}
+	defer session.Close()
+
+	buf := make([]byte, 2+maxIPPacketSize)
+	for {
+		select {
+		case pkt := <-conn.queue:
+			if err = writeIPPacket(session, buf, pkt); err != nil {
+				if m.opts.Verbose {
+					log.Printf("Network member, send IP packet to %v error: %v\n", conn.peer.Name, err)
+				}
+				return
+			}
+		case <-conn.done:
+			return
+		}
+	}
+}
+
+func (m *Member) removeIPPacketConn(conn *ipPacketConn) {
+	m.ipPacket.Lock()
+	defer m.ipPacket.Unlock()
+	if m.ipPacket.conns[conn.peer.Address] == conn {
+		delete(m.ipPacket.conns, conn.peer.Address)
+	}
+}
+
+// acceptIPPacketSessions receives packets over sessions dialed by members in IP packet mode.
+func (m *Member) acceptIPPacketSessions() {
+	if err := m.c.Listen(nil); err != nil {
+		log.Println("Network member, listen for IP packet sessions error:", err)
+		return
+	}
+	for {
+		session, err := m.c.AcceptSession()
+		if err != nil {
+			log.Println("Network member, accept IP packet session error:", err)
+			return
+		}
+		go m.receiveIPPackets(session)
+	}
+}
+
+func (m *Member) receiveIPPackets(session net.Conn) {
+	defer session.Close()
+
+	address := session.RemoteAddr().String()
+	m.ipPacket.Lock()
+	peer, ok := m.ipPacket.byAddr[address]
+	m.ipPacket.Unlock()
+	if !ok {
+		return
+	}
+
+	buf := make([]byte, 2+maxIPPacketSize)
+	for {
+		pkt, err := readIPPacket(session, buf)
+		if err != nil {
+			if m.opts.Verbose {
+				log.Printf("Network member, receive IP packet from %v error: %v\n", peer.Name, err)
+			}
+			return
+		}
+		m.ReceiveIPPacket(address, pkt)
+	}
+}
+
+// ReceiveIPPacket writes a packet received from member at address to TUN device. Packets
+// are dropped unless they are from a peer's IP to my IP.
+func (m *Member) ReceiveIPPacket(address string, pkt []byte) {
+	src, dst, ok := ipPacketAddrs(pkt)
+	if !ok {
+		return
+	}
+	m.ipPacket.Lock()
+	peer, ok := m.ipPacket.byAddr[address]
+	fromPeerIP := ok && m.ipPacket.byIP[src] == peer
+	toMe := dst == m.ipPacket.ip
+	m.ipPacket.Unlock()
+	if !fromPeerIP || !toMe {
+		return
+	}
+
+	if err := arch.WriteTunPacket(pkt); err != nil && m.opts.Verbose {
+		log.Printf("Network member, write IP packet from %v to TUN device error: %v\n", peer.Name, err)
+	}
+}
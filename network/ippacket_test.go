@@ -0,0 +1,88 @@
+package network
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func testIPv4Packet(src, dst string) []byte {
+	pkt := make([]byte, 28)
+	pkt[0] = 0x45
+	copy(pkt[12:16], net.ParseIP(src).To4())
+	copy(pkt[16:20], net.ParseIP(dst).To4())
+	return pkt
+}
+
+// go test -v -run=TestIPPacketAddrs
+func TestIPPacketAddrs(t *testing.T) {
+	src, dst, ok := ipPacketAddrs(testIPv4Packet("10.0.86.2", "10.0.86.3"))
+	require.True(t, ok)
+	require.Equal(t, "10.0.86.2", src.String())
+	require.Equal(t, "10.0.86.3", dst.String())
+
+	pkt := make([]byte, 40)
+	pkt[0] = 0x60
+	copy(pkt[8:24], net.ParseIP("fd00::2"))
+	copy(pkt[24:40], net.ParseIP("fd00::3"))
+	src, dst, ok = ipPacketAddrs(pkt)
+	require.True(t, ok)
+	require.Equal(t, "fd00::2", src.String())
+	require.Equal(t, "fd00::3", dst.String())
+
+	_, _, ok = ipPacketAddrs([]byte{0x45, 0})
+	require.False(t, ok)
+}
+
+// go test -v -run=TestIPPacketFraming
+func TestIPPacketFraming(t *testing.T) {
+	var b bytes.Buffer
+	buf := make([]byte, 2+maxIPPacketSize)
+	pkts := [][]byte{testIPv4Packet("10.0.86.2", "10.0.86.3"), make([]byte, maxIPPacketSize)}
+	for _, pkt := range pkts {
+		require.NoError(t, writeIPPacket(&b, buf, pkt))
+	}
+	require.Equal(t, errIPPacketTooLarge, writeIPPacket(&b, buf, make([]byte, maxIPPacketSize+1)))
+
+	for _, pkt := range pkts {
+		got, err := readIPPacket(&b, buf)
+		require.NoError(t, err)
+		require.Equal(t, pkt, got)
+	}
+	_, err := readIPPacket(&b, buf)
+	require.Equal(t, io.EOF, err)
+}
+
+// go test -v -run=TestIPPacketPeers
+func TestIPPacketPeers(t *testing.T) {
+	server := &NodeInfo{Name: "server", Address: "server-addr", IP: "10.0.86.3", IPPacket: true}
+	client := &NodeInfo{Name: "client", Address: "client-addr", IP: "10.0.86.4", IPPacket: true}
+	legacy := &NodeInfo{Name: "legacy", Address: "legacy-addr", IP: "10.0.86.5"}
+
+	m := NewMember(nil, nil)
+	m.networkData.NodeInfo = &NodeInfo{IP: "10.0.86.2"}
+	m.networkData.NodesICanAccess = []*NodeInfo{server, legacy}
+	m.networkData.NodesIAccept = []*NodeInfo{client, server}
+	m.updateIPPacketPeers()
+	require.Equal(t, "10.0.86.2", m.ipPacket.ip.String())
+
+	// members on either side of an access permission are peers once, members not in IP
+	// packet mode are not
+	require.Equal(t, map[string]*NodeInfo{server.Address: server, client.Address: client}, m.ipPacket.byAddr)
+	require.Equal(t, client, m.ipPacket.byIP[netip.MustParseAddr("10.0.86.4")])
+	require.Nil(t, m.ipPacket.byIP[netip.MustParseAddr("10.0.86.5")])
+
+	// sending to members no longer peers stops
+	conn := &ipPacketConn{peer: client, done: make(chan struct{})}
+	m.ipPacket.conns[client.Address] = conn
+	m.networkData.NodesIAccept = nil
+	m.updateIPPacketPeers()
+	require.Equal(t, map[string]*NodeInfo{server.Address: server}, m.ipPacket.byAddr)
+	require.Nil(t, m.ipPacket.byIP[netip.MustParseAddr("10.0.86.4")])
+	require.Empty(t, m.ipPacket.conns)
+	<-conn.done
+}
@@ -14,7 +14,10 @@ import (
 	"time"
 
 	"github.com/nknorg/nconnect/admin"
+	"github.com/nknorg/nconnect/audit"
 	"github.com/nknorg/nconnect/config"
+	"github.com/nknorg/nconnect/event"
+	"github.com/nknorg/nconnect/util"
 	"github.com/nknorg/nkn-sdk-go"
 )
 
@@ -44,14 +47,28 @@ type networkData struct {
 	NameToAddress map[string]string    `json:"nameToAddress"` // map name to address
 
 	ManagerBalance string `json:"managerBalance"` // manager's NKN balance
+
+	Version uint64 `json:"version"` // increased on every change, used to order snapshots pushed to members
+
+	UptimeHistory map[string][]*onlinePeriod `json:"uptimeHistory"` // map member address to its recent online periods
+
+	TopUp map[string]string `json:"topUp"` // map member address to total NKN sent to it by top up
 }
 
 type Manager struct {
-	opts *config.Opts
-	c    *admin.Client
+	opts    *config.Opts
+	account *nkn.Account
+	c       *admin.Client
 
 	sync.RWMutex
 	networkData *networkData // persisted data that will be saved to disk
+
+	protocolLock sync.RWMutex
+	protocols    map[string]*peerProtocol // map member address to protocol negotiated with it
+
+	send func(address string, msg *managerToMember, usePb, waitResponse bool) (*managerToMember, error) // sends to members by NKN client if nil
+
+	topUps map[string]*topUpState // backoff of failed top up by member address, used by top up goroutine only
 }
 
 var manager *Manager
@@ -61,11 +78,14 @@ func NewManager(account *nkn.Account, clientConfig *nkn.ClientConfig, opts *conf
 		return manager, nil
 	}
 
-	manager = &Manager{opts: opts}
+	manager = &Manager{opts: opts, account: account, protocols: make(map[string]*peerProtocol)}
 	err := manager.loadNetworkData()
 	if err != nil {
 		return nil, err
 	}
+	if err = manager.resetPresence(); err != nil {
+		return nil, err
+	}
 
 	if len(opts.Identifier) == 0 {
 		return nil, errors.New("network manager's identifier should not be empty")
@@ -82,17 +102,34 @@ func NewManager(account *nkn.Account, clientConfig *nkn.ClientConfig, opts *conf
 func (m *Manager) StartManager() error {
 	log.Println("nConnect manager is listening at:", m.c.MultiClient.Address())
 
+	event.SetSource(m.c.MultiClient.Address())
+	event.Start(&m.opts.Config, m.opts.GetAdminAddrs, m.c.MultiClient)
+
+	go m.StartPresenceMonitor()
+	go m.StartTopUp()
+
 	for {
 		msg := <-m.c.MultiClient.OnMessage.C
-		resp, err := m.handleRequest(msg)
+		if rpcReq := decodeAdminRequest(msg.Data); rpcReq != nil {
+			go m.handleAdminMsg(msg, rpcReq)
+			continue
+		}
+
+		req, usePb, err := decodeMemberMsg(msg.Data)
 		if err != nil {
-			log.Println("nConnect manager handle request error", err)
+			log.Println("nConnect manager decode request error", err)
 			continue
 		}
+		m.setPeerProtocol(msg.Src, &req.msgHeader)
 
-		b, err := json.Marshal(resp)
+		resp := m.handleRequest(msg.Src, req)
+		resp.ProtocolVersion = ProtocolVersion
+		resp.Capabilities = localCapabilities
+		resp.RequestID = req.RequestID
+
+		b, err := encodeMsg(resp, usePb)
 		if err != nil {
-			log.Println("nConnect manager json.Marshal resp error", err)
+			log.Println("nConnect manager encode resp error", err)
 			continue
 		}
 
@@ -104,63 +141,145 @@ func (m *Manager) StartManager() error {
 	}
 }
 
-func (m *Manager) handleRequest(msg *nkn.Message) (*managerToMember, error) {
-	req := &memberToManager{}
-	err := json.Unmarshal(msg.Data, req)
+// decodeAdminRequest returns the json rpc request sent by nConnect manager command line,
+// or nil if data is a member message.
+func decodeAdminRequest(data []byte) *admin.RpcReq {
+	if !isLegacyMsg(data) {
+		return nil
+	}
+	req := &admin.RpcReq{}
+	if err := json.Unmarshal(data, req); err != nil || req.Method == "" {
+		return nil
+	}
+	return req
+}
+
+func (m *Manager) handleAdminMsg(msg *nkn.Message, req *admin.RpcReq) {
+	if !util.MatchRegex(m.opts.GetAdminAddrs(), msg.Src) {
+		log.Println("nConnect manager ignore admin request from unauthorized address", msg.Src)
+		return
+	}
+
+	resp := m.handleWebRequest(req, audit.Caller{Addr: msg.Src, Via: audit.ViaNKN})
+	if m.opts.Verbose {
+		log.Printf("Admin request %v from %v, response %+v\n", req.Method, msg.Src, resp)
+	}
+
+	b, err := json.Marshal(resp)
 	if err != nil {
-		return nil, err
+		log.Println("nConnect manager encode admin resp error", err)
+		return
+	}
+	if err = msg.Reply(b); err != nil {
+		log.Println("nConnect manager msg.Reply error", err)
 	}
+}
 
+// StartControlService serves manager command line requests on the local control socket.
+func (m *Manager) StartControlService() error {
+	return ServeControl(m.opts.ControlSocketPath(), func(req *admin.RpcReq, _ *ControlStream) *admin.RpcResp {
+		return m.handleWebRequest(req, audit.Caller{Addr: m.opts.ControlSocketPath(), Via: audit.ViaControl})
+	})
+}
+
+func (m *Manager) handleRequest(src string, req *memberToManager) *managerToMember {
 	var node *NodeInfo
+	var err error
 	resp := &managerToMember{}
 	resp.MsgType = req.MsgType
 
+	// any message proves the member is alive, joining member is handled by JoinNetwork.
+	// memberOnline saves network data and notifies members, so it doesn't block receiving.
+	if req.MsgType != JOIN_NETWORK && m.markOnline(src) {
+		go m.memberOnline(src)
+	}
+
 	switch req.MsgType {
 	case JOIN_NETWORK:
 		resp.NetworkInfo = m.networkData.NetworkInfo
-		node, err = m.JoinNetwork(msg.Src, req.Name, req.ServerAddress)
+		node, err = m.JoinNetwork(src, req.Name, req.ServerAddress)
 		if node != nil {
 			resp.NodeInfo = append(resp.NodeInfo, node)
 		}
 
 	case LEAVE_NETWORK:
-		err = m.LeaveNetwork(msg.Src, req.Name)
+		err = m.LeaveNetwork(src, req.Name)
 
 	case GET_MY_INFO:
 		resp.NetworkInfo = m.networkData.NetworkInfo
-		if n := m.GetNodeInfo(msg.Src); n != nil {
+		if n := m.GetNodeInfo(src); n != nil {
 			resp.NodeInfo = append(resp.NodeInfo, n)
 		} else {
 			err = errors.New(errNodeNotFound)
 		}
 
 	case GET_NODES_I_ACCEPT:
-		list := m.GetAcceptNodes(msg.Src)
+		list := m.GetAcceptNodes(src)
 		resp.NodeInfo = list
 
 	case GET_NODES_I_CAN_ACCESS:
-		list := m.GetNodesICanAccess(msg.Src)
+		list := m.GetNodesICanAccess(src)
 		resp.NodeInfo = list
 
 	case UPDATE_SERVER_ADDRESS:
-		err = m.SetNodeServerAddress(msg.Src, req.ServerAddress)
+		err = m.SetNodeServerAddress(src, req.ServerAddress)
+
+	case UPDATE_MY_INFO:
+		node, err = m.UpdateMemberInfo(src, req.NodeInfo)
+		if node != nil {
+			resp.NodeInfo = append(resp.NodeInfo, node)
+		}
+
+	case GET_SNAPSHOT:
+		resp.Snapshot, err = m.GetSnapshot(src)
 
 	case NKN_PING:
-		fmt.Println("Got ping from", msg.Src)
+		fmt.Println("Got ping from", src)
 		resp.MsgType = NKN_PONG
 
 	case NKN_PONG:
-		fmt.Println("Got pong from", msg.Src)
+		fmt.Println("Got pong from", src)
+
+	case HEARTBEAT:
+		if m.opts.Verbose {
+			log.Println("Got heartbeat from", src)
+		}
+		if m.GetNodeInfo(src) == nil {
+			err = errors.New(errNodeNotFound) // member rejoins when it gets this
+		}
 
 	default:
-		return nil, fmt.Errorf("nConnect manager got unknown message type: %v", req.MsgType)
+		log.Printf("nConnect manager got unknown message type %v from %v\n", req.MsgType, src)
+		err = errors.New(errUnknownMsgType)
 	}
 
 	if err != nil {
 		resp.Err = err.Error()
 	}
 
-	return resp, nil
+	return resp
+}
+
+func (m *Manager) setPeerProtocol(address string, h *msgHeader) {
+	m.protocolLock.Lock()
+	defer m.protocolLock.Unlock()
+	m.protocols[address] = newPeerProtocol(h)
+}
+
+func (m *Manager) peerProtocol(address string) *peerProtocol {
+	m.protocolLock.RLock()
+	defer m.protocolLock.RUnlock()
+	return m.protocols[address]
+}
+
+// sendToMember encodes msg in the format negotiated with the member, members
+// which haven't sent us a message since manager started get legacy json.
+func (m *Manager) sendToMember(address string, msg *managerToMember, waitResponse bool) (*managerToMember, error) {
+	usePb := m.peerProtocol(address).supports(capProtobuf)
+	if m.send != nil {
+		return m.send(address, msg, usePb, waitResponse)
+	}
+	return SendMsg(m.c, address, msg, usePb, waitResponse)
 }
 
 func (m *Manager) JoinNetwork(address, name, serverAddr string) (*NodeInfo, error) {
@@ -172,7 +291,7 @@ func (m *Manager) JoinNetwork(address, name, serverAddr string) (*NodeInfo, erro
 	node, ok := m.networkData.Member[address]
 	m.RUnlock()
 	if ok {
-		node.LastSeen = time.Now()
+		m.markOnline(address)
 		if name != "" {
 			node.Name = name
 		}
@@ -195,8 +314,10 @@ func (m *Manager) JoinNetwork(address, name, serverAddr string) (*NodeInfo, erro
 		}
 		// broadcast member online event to related members
 		m.NotifyIAccept(address, notification)
+		go m.PushSnapshots()
 
 		log.Printf("The member '%v' is online, its IP is %v\n", node.Name, node.IP)
+		event.Emit(event.MemberOnline, *node)
 
 		return node, nil
 	}
@@ -236,6 +357,7 @@ func (m *Manager) JoinNetwork(address, name, serverAddr string) (*NodeInfo, erro
 	}
 
 	log.Println("A new member is waiting for authorization:", name, address)
+	event.Emit(event.JoinRequest, *m.networkData.Waiting[address])
 
 	return nil, errors.New(errWaitForAuth)
 }
@@ -277,8 +399,16 @@ func (m *Manager) LeaveNetwork(address, name string) error {
 	}
 
 	log.Printf("The node %v left network, its address is %v\n", name, address)
+	event.Emit(event.MemberLeft, NodeInfo{Name: name, Address: address})
 
-	return m.saveNetworkData()
+	if err := m.saveNetworkData(); err != nil {
+		return err
+	}
+	if ok {
+		go m.PushSnapshots()
+	}
+
+	return nil
 }
 
 func (m *Manager) AuthorizeMemeber(address string) error {
@@ -312,17 +442,85 @@ func (m *Manager) AuthorizeMemeber(address string) error {
 		NodeInfo:    []*NodeInfo{nw},
 	}
 
-	if _, err = SendMsg(m.c, address, notification, false); err != nil {
+	if _, err = m.sendToMember(address, notification, false); err != nil {
 		return err
 	}
 
 	m.NotifyICanAccess(address, &managerToMember{MsgType: NOTI_NEW_MEMBER})
+	go m.PushSnapshots()
 
 	log.Println("You just authorized a new member:", nw.Name, nw.IP)
+	event.Emit(event.MemberAuthorized, *nw)
+
+	return nil
+}
+
+// AssignIP changes the IP of a member, the IP should be in the network range and not used by others.
+func (m *Manager) AssignIP(address, ip string) error {
+	if net.ParseIP(ip).To4() == nil {
+		return fmt.Errorf("invalid IPv4 address %v", ip)
+	}
+
+	m.Lock()
+	node, ok := m.networkData.Member[address]
+	if !ok {
+		m.Unlock()
+		return errors.New(errNodeNotFound)
+	}
+	n := ip2int(ip)
+	if n <= ip2int(m.networkData.IpStart) || n >= ip2int(m.networkData.IpEnd) {
+		m.Unlock()
+		return fmt.Errorf("IP %v is out of the member range (%v, %v)", ip, m.networkData.IpStart, m.networkData.IpEnd)
+	}
+	if other := m.ipUser(ip); other != nil && other.Address != address {
+		m.Unlock()
+		return fmt.Errorf("IP %v is used by '%v'", ip, other.Name)
+	}
+	oldIP := node.IP
+	node.IP = ip
+	node.Netmask = m.networkData.Netmask
+	err := m.saveNetworkData()
+	m.Unlock()
+	if err != nil {
+		return err
+	}
 
+	notification := &managerToMember{
+		MsgType:     NOTI_AUTHORIZED,
+		NetworkInfo: m.networkData.NetworkInfo,
+		NodeInfo:    []*NodeInfo{node},
+	}
+	if _, err = m.sendToMember(address, notification, false); err != nil {
+		log.Printf("Send msg type %v to %v error %v\n", notification.MsgType, address, err)
+	}
+	m.NotifyIAccept(address, &managerToMember{MsgType: NOTI_UPD_I_CAN_ACCESS, NodeInfo: []*NodeInfo{node}})
+	go m.PushSnapshots()
+
+	log.Printf("You just changed the IP of member '%v' from %v to %v\n", node.Name, oldIP, ip)
+
+	return nil
+}
+
+// ipUser returns the member using ip, caller should hold the lock.
+func (m *Manager) ipUser(ip string) *NodeInfo {
+	for _, n := range m.networkData.Member {
+		if n.IP == ip {
+			return n
+		}
+	}
 	return nil
 }
 
+// ResolveAddress returns the address of the node with name, or s itself if no node has this name.
+func (m *Manager) ResolveAddress(s string) string {
+	m.RLock()
+	defer m.RUnlock()
+	if addr, ok := m.networkData.NameToAddress[s]; ok {
+		return addr
+	}
+	return s
+}
+
 func (m *Manager) DeleteWaiting(address string) error {
 	m.Lock()
 	delete(m.networkData.Waiting, address)
@@ -336,44 +534,53 @@ func (m *Manager) RemoveMember(address string) error {
 	nw, ok := m.networkData.Member[address]
 	m.RUnlock()
 
-	if ok {
-		m.Lock()
-		m.networkData.Waiting[address] = nw
-		delete(m.networkData.Member, address)
-		delete(m.networkData.AcceptAddress, address)
-		m.Unlock()
+	if !ok {
+		return errors.New(errNodeNotFound)
+	}
 
-		err := m.saveNetworkData()
-		if err != nil {
-			return err
-		}
+	m.Lock()
+	m.networkData.Waiting[address] = nw
+	delete(m.networkData.Member, address)
+	delete(m.networkData.AcceptAddress, address)
+	m.Unlock()
 
-		notification := &managerToMember{
-			MsgType:  NOTI_LEAVE_NETWORK,
-			NodeInfo: []*NodeInfo{nw},
-		}
-		m.NotifyIAccept(address, notification)
-		m.NotifyICanAccess(address, notification)
+	err := m.saveNetworkData()
+	if err != nil {
+		return err
+	}
+
+	notification := &managerToMember{
+		MsgType:  NOTI_LEAVE_NETWORK,
+		NodeInfo: []*NodeInfo{nw},
 	}
+	m.NotifyIAccept(address, notification)
+	m.NotifyICanAccess(address, notification)
+	go m.PushSnapshots(address)
 
 	log.Println("You just removed a member:", nw.Name, nw.IP)
+	event.Emit(event.MemberRemoved, *nw)
 
 	return nil
 }
 
 func (m *Manager) SetNodeServerAddress(address, serverAddress string) error {
-	m.Lock()
-	defer m.Unlock()
-
 	if serverAddress == "" {
 		return nil
 	}
 
+	m.Lock()
 	if node, ok := m.networkData.Member[address]; ok && node.ServerAddress != serverAddress {
 		node.ServerAddress = serverAddress
 		m.networkData.Member[address] = node
-		return m.saveNetworkData()
+		err := m.saveNetworkData()
+		m.Unlock()
+		if err != nil {
+			return err
+		}
+		go m.PushSnapshots()
+		return nil
 	}
+	defer m.Unlock()
 
 	if node, ok := m.networkData.Waiting[address]; ok && node.ServerAddress != serverAddress {
 		node.ServerAddress = serverAddress
@@ -384,6 +591,70 @@ func (m *Manager) SetNodeServerAddress(address, serverAddress string) error {
 	return nil
 }
 
+// UpdateMemberInfo updates the profile of a member or a node waiting for authorization,
+// it returns the updated node info.
+func (m *Manager) UpdateMemberInfo(address string, info *NodeInfo) (*NodeInfo, error) {
+	if info == nil {
+		return nil, errors.New("node info is empty")
+	}
+
+	m.Lock()
+	node, isMember := m.networkData.Member[address]
+	if !isMember {
+		var ok bool
+		if node, ok = m.networkData.Waiting[address]; !ok {
+			m.Unlock()
+			return nil, errors.New(errNodeNotFound)
+		}
+	}
+	subnet, memberIPs := m.routeLimits()
+	for _, route := range info.Routes {
+		if _, err := parseRoute(route, subnet, memberIPs); err != nil {
+			m.Unlock()
+			return nil, fmt.Errorf("invalid route %v: %v", route, err)
+		}
+	}
+
+	if info.Name != "" && info.Name != node.Name {
+		if addr, ok := m.networkData.NameToAddress[info.Name]; ok && addr != address {
+			m.Unlock()
+			return nil, errors.New(errNameExist)
+		}
+		delete(m.networkData.NameToAddress, node.Name)
+		m.networkData.NameToAddress[info.Name] = address
+		node.Name = info.Name
+	}
+	node.Description = info.Description
+	node.Tags = info.Tags
+	node.OS = info.OS
+	node.Version = info.Version
+	node.Routes = info.Routes
+	node.IPPacket = info.IPPacket
+	// routes are used by other members after manager approves them, withdrawn routes need approval again
+	var approved []string
+	for _, route := range node.ApprovedRoutes {
+		if containsRoute(info.Routes, route) {
+			approved = append(approved, route)
+		}
+	}
+	node.ApprovedRoutes = approved
+
+	err := m.saveNetworkData()
+	m.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("The node '%v' updated its info, its address is %v\n", node.Name, address)
+
+	if isMember {
+		m.NotifyIAccept(address, &managerToMember{MsgType: NOTI_UPD_I_CAN_ACCESS, NodeInfo: []*NodeInfo{node}})
+		go m.PushSnapshots()
+	}
+
+	return node, nil
+}
+
 func (m *Manager) GetAcceptAddress(address string) []string {
 	m.RLock()
 	defer m.RUnlock()
@@ -394,7 +665,10 @@ func (m *Manager) GetAcceptAddress(address string) []string {
 func (m *Manager) GetAcceptNodes(address string) []*NodeInfo {
 	m.RLock()
 	defer m.RUnlock()
+	return m.getAcceptNodes(address)
+}
 
+func (m *Manager) getAcceptNodes(address string) []*NodeInfo {
 	addressList := m.networkData.AcceptAddress[address]
 	var list []*NodeInfo
 	if len(addressList) > 0 && addressList[0] == AllMembers {
@@ -417,7 +691,10 @@ func (m *Manager) GetAcceptNodes(address string) []*NodeInfo {
 func (m *Manager) GetNodesICanAccess(address string) []*NodeInfo {
 	m.RLock()
 	defer m.RUnlock()
+	return m.getNodesICanAccess(address)
+}
 
+func (m *Manager) getNodesICanAccess(address string) []*NodeInfo {
 	if _, ok := m.networkData.Member[address]; !ok { // not a member
 		return nil
 	}
@@ -456,7 +733,7 @@ func (m *Manager) SetAcceptAddress(address string, acceptAddress []string) error
 		return err
 	}
 	notification := &managerToMember{MsgType: NOTI_UPD_I_ACCEPT}
-	if _, err := SendMsg(m.c, address, notification, false); err != nil {
+	if _, err := m.sendToMember(address, notification, false); err != nil {
 		return err
 	}
 
@@ -466,6 +743,9 @@ func (m *Manager) SetAcceptAddress(address string, acceptAddress []string) error
 
 	notification = &managerToMember{MsgType: NOTI_UPD_I_CAN_ACCESS, NodeInfo: []*NodeInfo{n}}
 	m.NotifyIAccept(address, notification)
+	go m.PushSnapshots()
+
+	event.Emit(event.AcceptChanged, &addresses{Address: address, AcceptAddresses: acceptAddress})
 
 	return nil
 }
@@ -493,7 +773,7 @@ func (m *Manager) SendToken(address, amount string) error {
 func (m *Manager) NknPing(address string) (int, error) {
 	msg := &managerToMember{MsgType: NKN_PING}
 	start := time.Now()
-	_, err := SendMsg(m.c, address, msg, true)
+	_, err := m.sendToMember(address, msg, true)
 	if err != nil {
 		return 0, err
 	}
@@ -502,6 +782,37 @@ func (m *Manager) NknPing(address string) (int, error) {
 	return int(rtt.Milliseconds()), nil
 }
 
+// Diagnose asks member from to measure latency and throughput to member to through the tunnel path.
+func (m *Manager) Diagnose(from, to string) (*DiagResult, error) {
+	m.RLock()
+	fromNode, ok1 := m.networkData.Member[from]
+	toNode, ok2 := m.networkData.Member[to]
+	m.RUnlock()
+	if !ok1 || !ok2 {
+		return nil, errors.New(errNodeNotFound)
+	}
+	if !m.peerProtocol(from).supports(capDiagnose) {
+		return nil, errDiagNotSupported
+	}
+
+	// diagnostics are not retried, a retry would run them again and its reply
+	// wouldn't match the request
+	msg := &managerToMember{MsgType: DIAGNOSE, NodeInfo: []*NodeInfo{toNode}}
+	usePb := m.peerProtocol(from).supports(capProtobuf)
+	resp, err := SendMsgOnce(m.c, fromNode.Address, msg, usePb, diagReplyTimeout)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Err != "" {
+		return nil, errors.New(resp.Err)
+	}
+	if resp.DiagResult == nil {
+		return nil, errNoDiagResult
+	}
+
+	return resp.DiagResult, nil
+}
+
 // Send notification to all the nodes which I(initiatorAddr) accept
 func (m *Manager) NotifyIAccept(initiatorAddr string, notification *managerToMember) error {
 	m.RLock()
@@ -511,14 +822,14 @@ func (m *Manager) NotifyIAccept(initiatorAddr string, notification *managerToMem
 	if len(acceptAddress) > 0 && acceptAddress[0] == AllMembers {
 		for _, n := range m.networkData.Member {
 			if n.Address != initiatorAddr {
-				if _, err := SendMsg(m.c, n.Address, notification, false); err != nil {
+				if _, err := m.sendToMember(n.Address, notification, false); err != nil {
 					log.Printf("Send msg type %v to %v error %v\n", notification.MsgType, n.Address, err)
 				}
 			}
 		}
 	} else {
 		for _, addr := range acceptAddress {
-			if _, err := SendMsg(m.c, addr, notification, false); err != nil {
+			if _, err := m.sendToMember(addr, notification, false); err != nil {
 				log.Printf("Send msg type %v to %v error %v\n", notification.MsgType, addr, err)
 			}
 		}
@@ -536,13 +847,13 @@ func (m *Manager) NotifyICanAccess(initiatorAddr string, notification *managerTo
 
 		acceptAddr := m.networkData.AcceptAddress[n.Address]
 		if len(acceptAddr) > 0 && acceptAddr[0] == AllMembers {
-			if _, err := SendMsg(m.c, n.Address, notification, false); err != nil {
+			if _, err := m.sendToMember(n.Address, notification, false); err != nil {
 				log.Printf("Send msg type %v to %v error %v\n", notification.MsgType, n.Address, err)
 			}
 		} else {
 			for _, addr := range acceptAddr { // broadcast accept info to nodes
 				if addr == initiatorAddr {
-					if _, err := SendMsg(m.c, n.Address, notification, false); err != nil {
+					if _, err := m.sendToMember(n.Address, notification, false); err != nil {
 						log.Printf("Send msg type %v to %v error %v\n", notification.MsgType, addr, err)
 					}
 				}
@@ -562,6 +873,57 @@ func (m *Manager) GetNodeInfo(address string) *NodeInfo {
 	return nil
 }
 
+// GetSnapshot builds and signs the current view of the network for a member.
+// A non-member gets a snapshot without node info, so it can drop stale state.
+func (m *Manager) GetSnapshot(address string) (*signedSnapshot, error) {
+	m.RLock()
+	defer m.RUnlock() // snapshot data points to live node info, sign it before it's changed
+
+	data := &snapshotData{
+		Version:     m.networkData.Version,
+		Address:     address,
+		Timestamp:   time.Now(),
+		NetworkInfo: m.networkData.NetworkInfo,
+	}
+	if n, ok := m.networkData.Member[address]; ok {
+		data.NodeInfo = n
+		data.NodesIAccept = m.getAcceptNodes(address)
+		data.NodesICanAccess = m.getNodesICanAccess(address)
+		data.AcceptAddress = m.networkData.AcceptAddress[address]
+	}
+
+	return signSnapshot(m.account, data)
+}
+
+// PushSnapshots sends the latest snapshot to every member, and to the extra
+// addresses which are no longer members but should drop their network state.
+// Members not known to support snapshots, including all members which haven't
+// sent a message since manager started, only get legacy notifications.
+func (m *Manager) PushSnapshots(extraAddrs ...string) {
+	m.RLock()
+	addrs := make([]string, 0, len(m.networkData.Member)+len(extraAddrs))
+	for addr := range m.networkData.Member {
+		addrs = append(addrs, addr)
+	}
+	m.RUnlock()
+	addrs = append(addrs, extraAddrs...)
+
+	for _, addr := range addrs {
+		if p := m.peerProtocol(addr); p == nil || !p.supports(capSnapshot) {
+			continue
+		}
+		snapshot, err := m.GetSnapshot(addr)
+		if err != nil {
+			log.Printf("Build snapshot for %v error %v\n", addr, err)
+			continue
+		}
+		notification := &managerToMember{MsgType: NOTI_SNAPSHOT, Snapshot: snapshot}
+		if _, err = m.sendToMember(addr, notification, false); err != nil {
+			log.Printf("Send msg type %v to %v error %v\n", notification.MsgType, addr, err)
+		}
+	}
+}
+
 type network struct {
 	NetworkData    *networkData `json:"networkData"`    // network data
 	ManagerAddress string       `json:"managerAddress"` // manager's NKN address
@@ -590,7 +952,12 @@ func (m *Manager) SetNetworkConfig(conf *networkData) error {
 	m.networkData.IpEnd = conf.IpEnd
 	m.networkData.Netmask = conf.Netmask
 
-	return m.saveNetworkData()
+	if err := m.saveNetworkData(); err != nil {
+		return err
+	}
+	go m.PushSnapshots()
+
+	return nil
 }
 
 func (m *Manager) GetAvailableIp() (string, error) {
@@ -600,6 +967,15 @@ func (m *Manager) GetAvailableIp() (string, error) {
 		return "", errors.New("nConnect manager has no available ip")
 	}
 
+	// skip the IPs assigned to members manually
+	for m.ipUser(m.networkData.NextIp) != nil {
+		m.networkData.NextIp = int2ip(ip2int(m.networkData.NextIp) + 1)
+		if m.networkData.NextIp == m.networkData.IpEnd {
+			m.networkData.NextIp = ""
+			return "", errors.New("nConnect manager has no available ip")
+		}
+	}
+
 	ip := m.networkData.NextIp
 	m.networkData.NextIp = int2ip(ip2int(m.networkData.NextIp) + 1)
 	if m.networkData.NextIp == m.networkData.IpEnd {
@@ -618,6 +994,8 @@ func (m *Manager) loadNetworkData() error {
 		Member:        make(map[string]*NodeInfo),
 		AcceptAddress: make(map[string][]string),
 		NameToAddress: make(map[string]string),
+		UptimeHistory: make(map[string][]*onlinePeriod),
+		TopUp:         make(map[string]string),
 	}
 	m.networkData = nwData
 
@@ -650,6 +1028,7 @@ func (m *Manager) saveNetworkData() error {
 	if m.networkData == nil {
 		return errors.New("networkData is nil")
 	}
+	m.networkData.Version++
 
 	b, err := json.MarshalIndent(m.networkData, "", "  ")
 	if err != nil {
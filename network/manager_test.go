@@ -0,0 +1,153 @@
+package network
+
+import (
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nknorg/nconnect/config"
+	"github.com/nknorg/nkn-sdk-go"
+	"github.com/stretchr/testify/require"
+)
+
+type sentMsg struct {
+	address string
+	msg     *managerToMember
+}
+
+type testSender struct {
+	sync.Mutex
+	sent []*sentMsg
+}
+
+func (s *testSender) send(address string, msg *managerToMember, usePb, waitResponse bool) (*managerToMember, error) {
+	s.Lock()
+	defer s.Unlock()
+	s.sent = append(s.sent, &sentMsg{address: address, msg: msg})
+	return nil, nil
+}
+
+// sentTo returns the types of messages sent to address.
+func (s *testSender) sentTo(address string) []int {
+	s.Lock()
+	defer s.Unlock()
+	var types []int
+	for _, m := range s.sent {
+		if m.address == address {
+			types = append(types, m.msg.MsgType)
+		}
+	}
+	return types
+}
+
+// newTestManager returns a manager with members alice and bob accepting each other, which
+// records messages sent to members instead of sending them. Alice has negotiated the
+// current protocol, bob hasn't sent a message since manager started. Network data is saved
+// to a temporary directory.
+func newTestManager(t *testing.T) (*Manager, *testSender) {
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(t.TempDir()))
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	account, err := nkn.NewAccount(nil)
+	require.NoError(t, err)
+
+	now := time.Now()
+	alice := &NodeInfo{Name: "alice", Address: "alice-addr", IP: "10.0.86.2", Online: true, LastSeen: now}
+	bob := &NodeInfo{Name: "bob", Address: "bob-addr", IP: "10.0.86.3", Online: true, LastSeen: now}
+	s := &testSender{}
+	m := &Manager{
+		opts:      &config.Opts{},
+		account:   account,
+		protocols: make(map[string]*peerProtocol),
+		send:      s.send,
+		networkData: &networkData{
+			NetworkInfo:   &networkInfo{},
+			IpStart:       "10.0.86.1",
+			Netmask:       "255.255.255.0",
+			Waiting:       make(map[string]*NodeInfo),
+			Member:        map[string]*NodeInfo{alice.Address: alice, bob.Address: bob},
+			AcceptAddress: map[string][]string{alice.Address: {bob.Address}, bob.Address: {alice.Address}},
+			NameToAddress: map[string]string{alice.Name: alice.Address, bob.Name: bob.Address},
+			UptimeHistory: make(map[string][]*onlinePeriod),
+		},
+	}
+	m.setPeerProtocol(alice.Address, &msgHeader{ProtocolVersion: ProtocolVersion, Capabilities: localCapabilities})
+	return m, s
+}
+
+// go test -v -run=TestPushSnapshots
+func TestPushSnapshots(t *testing.T) {
+	m, s := newTestManager(t)
+
+	// members not known to support snapshots only get legacy notifications
+	m.PushSnapshots()
+	require.Equal(t, []int{NOTI_SNAPSHOT}, s.sentTo("alice-addr"))
+	require.Empty(t, s.sentTo("bob-addr"))
+}
+
+// go test -v -run=TestHeartbeat
+func TestHeartbeat(t *testing.T) {
+	m, s := newTestManager(t)
+
+	resp := m.handleRequest("alice-addr", &memberToManager{MsgType: HEARTBEAT})
+	require.Empty(t, resp.Err)
+
+	// heartbeat of an offline member brings it online and tells its peers
+	m.networkData.Member["alice-addr"].Online = false
+	resp = m.handleRequest("alice-addr", &memberToManager{MsgType: HEARTBEAT})
+	require.Empty(t, resp.Err)
+	require.True(t, m.GetNodeInfo("alice-addr").Online)
+	require.Eventually(t, func() bool {
+		for _, msgType := range s.sentTo("bob-addr") {
+			if msgType == NOTI_MEMBER_ONLINE {
+				return true
+			}
+		}
+		return false
+	}, time.Second, 10*time.Millisecond)
+	require.Eventually(t, func() bool {
+		return len(s.sentTo("alice-addr")) > 0
+	}, time.Second, 10*time.Millisecond)
+
+	// members unknown to manager, e.g. after it lost its data, are told to join again
+	resp = m.handleRequest("carol-addr", &memberToManager{MsgType: HEARTBEAT})
+	require.Equal(t, errNodeNotFound, resp.Err)
+}
+
+// go test -v -run=TestUpdateMyInfo
+func TestUpdateMyInfo(t *testing.T) {
+	m, s := newTestManager(t)
+	update := func(src string, info *NodeInfo) *managerToMember {
+		return m.handleRequest(src, &memberToManager{MsgType: UPDATE_MY_INFO, NodeInfo: info})
+	}
+
+	require.Equal(t, errNodeNotFound, update("carol-addr", &NodeInfo{Name: "carol"}).Err)
+	require.Equal(t, errNameExist, update("alice-addr", &NodeInfo{Name: "bob"}).Err)
+	for _, route := range []string{"192.168.1.0", "0.0.0.0/1", "10.0.0.0/8", "10.0.86.3/32"} {
+		require.Contains(t, update("alice-addr", &NodeInfo{Name: "alice", Routes: []string{route}}).Err, "invalid route")
+	}
+	require.Empty(t, s.sentTo("bob-addr"))
+
+	resp := update("alice-addr", &NodeInfo{Name: "carol", Description: "home", Routes: []string{"192.168.1.0/24"}})
+	require.Empty(t, resp.Err)
+	require.Len(t, resp.NodeInfo, 1)
+	require.Equal(t, "carol", resp.NodeInfo[0].Name)
+	require.Equal(t, []string{"192.168.1.0/24"}, resp.NodeInfo[0].Routes)
+	require.Equal(t, "alice-addr", m.networkData.NameToAddress["carol"])
+	require.NotContains(t, m.networkData.NameToAddress, "alice")
+
+	// the change is saved and peers accepting the member are notified
+	b, err := os.ReadFile(networkDataFile)
+	require.NoError(t, err)
+	require.Contains(t, string(b), `"carol"`)
+	require.Contains(t, s.sentTo("bob-addr"), NOTI_UPD_I_CAN_ACCESS)
+	require.Eventually(t, func() bool {
+		return len(s.sentTo("alice-addr")) > 0
+	}, time.Second, 10*time.Millisecond)
+
+	// keeping the same name is not a conflict
+	require.Empty(t, update("alice-addr", &NodeInfo{Name: "carol"}).Err)
+}
@@ -0,0 +1,217 @@
+package network
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/nknorg/nconnect/audit"
+)
+
+const ManagerCliHelp = `
+nConnect manager [options] <cmd>, to manage a running nConnect network manager. The cmd can be:
+list                          list members and nodes waiting for authorization
+authorize <node>              authorize a node waiting for authorization
+remove <node>                 remove a member from the network
+acl set <node> [node...|all]  set the nodes which are allowed to access <node>, all for all members
+ip assign <node> <ip>         assign an IP to a member
+routes <node> [route...]      approve routes advertised by <node> for other members, no route to revoke all
+ping <node>                   ping a member through NKN
+diag <from> <to>              measure latency and throughput from member <from> to member <to> through tunnel
+audit [n] [method]            print latest n (default 20) audit records, optionally of a method only
+<node> can be either node name or NKN address.
+`
+
+const defaultAuditLines = 20
+
+var errManagerCliUsage = errors.New("invalid command, run nConnect manager help for usage")
+
+// ManagerCli runs manager command args through caller, prints json if jsonOutput is true,
+// otherwise prints human readable text.
+func ManagerCli(caller RPCCaller, args []string, jsonOutput bool) error {
+	if len(args) == 0 {
+		return errManagerCliUsage
+	}
+
+	cmd := strings.ToLower(args[0])
+	args = args[1:]
+	switch cmd {
+	case "help":
+		fmt.Print(ManagerCliHelp)
+		return nil
+
+	case "list":
+		res := &network{}
+		if err := caller.RPCCall("getNetworkConfig", nil, res); err != nil {
+			return err
+		}
+		if jsonOutput {
+			return printJSON(res)
+		}
+		printNetwork(res)
+		return nil
+
+	case "authorize", "remove", "ping":
+		if len(args) != 1 {
+			return errManagerCliUsage
+		}
+		method := map[string]string{"authorize": "authorizeMember", "remove": "removeMember", "ping": "nknPing"}[cmd]
+		return managerCliCall(caller, method, &addressData{Address: args[0]}, jsonOutput)
+
+	case "diag":
+		if len(args) != 2 {
+			return errManagerCliUsage
+		}
+		// manager waits for the member running diagnostics, the request is not sent again
+		res := &DiagResult{}
+		if err := caller.RPCCallOnce("diagnose", &diagnoseData{From: args[0], To: args[1]}, res, diagReplyTimeout); err != nil {
+			return err
+		}
+		if jsonOutput {
+			return printJSON(res)
+		}
+		PrintDiagResult(res)
+		return nil
+
+	case "acl":
+		if len(args) < 2 || strings.ToLower(args[0]) != "set" {
+			return errManagerCliUsage
+		}
+		accept := make([]string, 0, len(args)-2)
+		for _, a := range args[2:] {
+			if strings.ToLower(a) == "all" {
+				accept = []string{AllMembers}
+				break
+			}
+			accept = append(accept, a)
+		}
+		return managerCliCall(caller, "setAcceptAddress", &addresses{Address: args[1], AcceptAddresses: accept}, jsonOutput)
+
+	case "audit":
+		filter := &audit.Filter{Limit: defaultAuditLines}
+		if len(args) > 0 {
+			n, err := strconv.Atoi(args[0])
+			if err != nil {
+				return errManagerCliUsage
+			}
+			filter.Limit = n
+		}
+		if len(args) > 1 {
+			filter.Method = args[1]
+		}
+		var records []*audit.Record
+		if err := caller.RPCCall("getAuditLog", filter, &records); err != nil {
+			return err
+		}
+		if jsonOutput {
+			return printJSON(records)
+		}
+		printAudit(records)
+		return nil
+
+	case "ip":
+		if len(args) != 3 || strings.ToLower(args[0]) != "assign" {
+			return errManagerCliUsage
+		}
+		return managerCliCall(caller, "assignIp", &assignIPData{Address: args[1], IP: args[2]}, jsonOutput)
+
+	case "routes":
+		if len(args) < 1 {
+			return errManagerCliUsage
+		}
+		return managerCliCall(caller, "approveRoutes", &routesData{Address: args[0], Routes: args[1:]}, jsonOutput)
+
+	default:
+		return errManagerCliUsage
+	}
+}
+
+func managerCliCall(caller RPCCaller, method string, params interface{}, jsonOutput bool) error {
+	var res string
+	if err := caller.RPCCall(method, params, &res); err != nil {
+		return err
+	}
+	if jsonOutput {
+		return printJSON(map[string]string{"result": res})
+	}
+	fmt.Println(res)
+	return nil
+}
+
+func printJSON(v interface{}) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func printNetwork(n *network) {
+	data := n.NetworkData
+	if data == nil {
+		return
+	}
+
+	fmt.Println("Manager address:", n.ManagerAddress)
+	fmt.Println("Manager balance:", n.ManagerBalance)
+	if data.NetworkInfo != nil {
+		fmt.Println("Network domain: ", data.NetworkInfo.Domain)
+	}
+
+	addrToName := make(map[string]string, len(data.NameToAddress))
+	for name, addr := range data.NameToAddress {
+		addrToName[addr] = name
+	}
+
+	fmt.Println("\nMembers:")
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tIP\tONLINE\tSERVER\tACCEPT\tROUTES\tADDRESS")
+	for _, node := range sortedNodes(data.Member) {
+		accept := make([]string, 0, len(data.AcceptAddress[node.Address]))
+		for _, addr := range data.AcceptAddress[node.Address] {
+			if name, ok := addrToName[addr]; ok {
+				addr = name
+			}
+			accept = append(accept, addr)
+		}
+		routes := make([]string, 0, len(node.Routes))
+		for _, r := range node.Routes {
+			if !containsRoute(node.ApprovedRoutes, r) {
+				r += "(pending)"
+			}
+			routes = append(routes, r)
+		}
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\t%v\n", node.Name, node.IP, node.Online, node.Server, strings.Join(accept, ","), strings.Join(routes, ","), node.Address)
+	}
+	w.Flush()
+
+	fmt.Println("\nWaiting for authorization:")
+	w = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tADDRESS")
+	for _, node := range sortedNodes(data.Waiting) {
+		fmt.Fprintf(w, "%v\t%v\n", node.Name, node.Address)
+	}
+	w.Flush()
+}
+
+func printAudit(records []*audit.Record) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TIME\tVIA\tCALLER\tMETHOD\tERROR")
+	for _, r := range records {
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\n", r.Time.Format(time.RFC3339), r.Caller.Via, r.Caller.Addr, r.Method, r.Error)
+	}
+	w.Flush()
+}
+
+func sortedNodes(nodes map[string]*NodeInfo) []*NodeInfo {
+	list := make([]*NodeInfo, 0, len(nodes))
+	for _, n := range nodes {
+		list = append(list, n)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
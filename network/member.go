@@ -8,12 +8,16 @@ import (
 	"log"
 	"net"
 	"os"
+	"runtime"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/nknorg/nconnect/admin"
 	"github.com/nknorg/nconnect/arch"
 	"github.com/nknorg/nconnect/config"
+	"github.com/nknorg/nconnect/ss"
 	"github.com/nknorg/nkn-sdk-go"
 	tunnel "github.com/nknorg/nkn-tunnel"
 )
@@ -23,10 +27,11 @@ const (
 )
 
 var (
-	errNoDataInFile = "no data in file"
-	errWaitForAuth  = "wait for authorization"
-	errNameExist    = "network node name already exists"
-	errNodeNotFound = "node not found"
+	errNoDataInFile   = "no data in file"
+	errWaitForAuth    = "wait for authorization"
+	errNameExist      = "network node name already exists"
+	errNodeNotFound   = "node not found"
+	errUnknownMsgType = "unknown message type"
 )
 
 type memberNetworkData struct {
@@ -37,6 +42,7 @@ type memberNetworkData struct {
 }
 
 type callbackNodeICanAccessUpdated func(nodes []*NodeInfo) error
+type callbackDiagnose func(node *NodeInfo) (*DiagResult, error)
 
 type Member struct {
 	opts                    *config.Opts
@@ -44,13 +50,31 @@ type Member struct {
 	networkData             memberNetworkData // node info of this node
 	serverAddress           string            // nconnect server tunnel address
 	serverTunnel            *tunnel.Tunnel
-	joinedNetwork           bool
+	joinedNetwork           atomic.Bool // read by heartbeat and message handlers
 	CbNodeICanAccessUpdated callbackNodeICanAccessUpdated
-	openTunOnce             sync.Once // only open tun device once
+	CbDiagnose              callbackDiagnose // runs diagnostics to a node through the tunnel path
+	openTunOnce             sync.Once        // only open tun device once
+
+	snapshotLock    sync.Mutex
+	snapshotVersion uint64 // version of the latest applied snapshot, 0 if none
+
+	protocolLock    sync.RWMutex
+	managerProtocol *peerProtocol // protocol negotiated with manager, nil until manager replies
+
+	watchLock sync.Mutex
+	watchers  map[chan struct{}]struct{} // control socket watchers notified when member data changes
+
+	ipPacket ipPacketState
 }
 
 func NewMember(opts *config.Opts, c *admin.Client) *Member {
-	return &Member{opts: opts, c: c, networkData: memberNetworkData{NetworkInfo: &networkInfo{}, NodeInfo: &NodeInfo{}}}
+	return &Member{
+		opts:        opts,
+		c:           c,
+		networkData: memberNetworkData{NetworkInfo: &networkInfo{}, NodeInfo: &NodeInfo{}},
+		watchers:    make(map[chan struct{}]struct{}),
+		ipPacket:    ipPacketState{conns: make(map[string]*ipPacketConn)},
+	}
 }
 
 func (m *Member) StartMember(serverAddress string) error {
@@ -61,27 +85,22 @@ func (m *Member) StartMember(serverAddress string) error {
 		return err
 	}
 
-	if err = m.JoinNetwork(serverAddress); err != nil {
+	if err = m.joinAndSync(); err != nil {
 		return err
 	}
 
-	if m.joinedNetwork {
-		if err = m.GetNodeICanAccess(); err != nil {
-			return err
-		}
-		if err = m.GetNodeIAccept(); err != nil {
-			return err
-		}
+	go m.StartHeartbeat()
+	if m.opts.IPPacket {
+		go m.acceptIPPacketSessions()
 	}
 
 	log.Println("nConnect Network member is listening at:", m.c.Address())
 	for {
 		msg := <-m.c.OnMessage.C
 
-		req := &managerToMember{}
-		err := json.Unmarshal(msg.Data, req)
+		req, usePb, err := decodeManagerMsg(msg.Data)
 		if err != nil {
-			log.Println("Network member, received multiclient msg, unmarshal msg.Data error: ", err)
+			log.Println("Network member, received multiclient msg, decode msg.Data error: ", err)
 			continue
 		}
 		if m.opts.Verbose {
@@ -89,6 +108,11 @@ func (m *Member) StartMember(serverAddress string) error {
 		}
 
 		go func() {
+			if req.MsgType == DIAGNOSE {
+				replyMsg(msg, m.diagnose(msg.Src, req), usePb)
+				return
+			}
+
 			err = m.handleNknMsg(req)
 			if err != nil {
 				log.Println(err)
@@ -96,23 +120,143 @@ func (m *Member) StartMember(serverAddress string) error {
 			if req.MsgType == NKN_PING {
 				resp := req
 				resp.MsgType = NKN_PONG
-				b, err := json.Marshal(resp)
-				if err != nil {
-					log.Println("Network member, marshal pong error: ", err)
-					return
-				}
-
-				err = msg.Reply(b)
-				if err != nil {
-					log.Println("Network member, reply pong error: ", err)
-				}
+				replyMsg(msg, resp, usePb)
 			}
 		}()
 	}
 }
 
+func replyMsg(msg *nkn.Message, resp *managerToMember, usePb bool) {
+	resp.ProtocolVersion = ProtocolVersion
+	resp.Capabilities = localCapabilities
+	b, err := encodeMsg(resp, usePb)
+	if err != nil {
+		log.Printf("Network member, encode reply of msg type %v error: %v\n", resp.MsgType, err)
+		return
+	}
+
+	err = msg.Reply(b)
+	if err != nil {
+		log.Printf("Network member, reply msg type %v error: %v\n", resp.MsgType, err)
+	}
+}
+
+// diagnose runs diagnostics requested by manager to the node in req.
+func (m *Member) diagnose(src string, req *managerToMember) *managerToMember {
+	resp := &managerToMember{MsgType: DIAGNOSE}
+	resp.RequestID = req.RequestID
+
+	var err error
+	switch {
+	case src != m.opts.ManagerAddress:
+		err = fmt.Errorf("diagnostics request from %v is not from manager", src)
+	case len(req.NodeInfo) == 0:
+		err = errors.New(errNodeNotFound)
+	case m.CbDiagnose == nil:
+		err = errDiagNotSupported
+	default:
+		log.Printf("Network member, running diagnostics to %v requested by manager\n", req.NodeInfo[0].Name)
+		resp.DiagResult, err = m.CbDiagnose(req.NodeInfo[0])
+	}
+	if err != nil {
+		resp.Err = err.Error()
+	}
+
+	return resp
+}
+
+// joinAndSync joins the network and gets the latest node lists from manager.
+func (m *Member) joinAndSync() error {
+	if err := m.JoinNetwork(m.serverAddress); err != nil {
+		return err
+	}
+
+	if m.managerSupports(capUpdateMyInfo) {
+		if err := m.UpdateMyInfo(); err != nil {
+			log.Println("Network member, update my info error:", err)
+		}
+	}
+
+	if !m.joinedNetwork.Load() {
+		return nil
+	}
+
+	if m.managerSupports(capSnapshot) {
+		if err := m.GetSnapshot(); err != nil {
+			log.Println("Network member, get snapshot error, fall back to node lists:", err)
+		}
+	}
+	if !m.hasSnapshot() {
+		if err := m.GetNodeICanAccess(); err != nil {
+			return err
+		}
+		if err := m.GetNodeIAccept(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Reconnect joins the network again and refreshes tunnels to the nodes I can access.
+func (m *Member) Reconnect() error {
+	if err := m.joinAndSync(); err != nil {
+		return err
+	}
+
+	if m.CbNodeICanAccessUpdated != nil {
+		return m.CbNodeICanAccessUpdated(m.networkData.NodesICanAccess)
+	}
+
+	return nil
+}
+
+// Ping sends a ping to another member and returns the round trip time.
+func (m *Member) Ping(address string, timeout time.Duration) (time.Duration, error) {
+	msg := &managerToMember{MsgType: NKN_PING}
+	msg.ProtocolVersion = ProtocolVersion
+	msg.Capabilities = localCapabilities
+	b, err := encodeMsg(msg, false) // every member understands json
+	if err != nil {
+		return 0, err
+	}
+
+	start := time.Now()
+	onReply, err := m.c.Send(nkn.NewStringArray(address), b, nkn.GetDefaultMessageConfig())
+	if err != nil {
+		return 0, err
+	}
+
+	select {
+	case <-onReply.C:
+		return time.Since(start), nil
+	case <-time.After(timeout):
+		return 0, admin.ErrReplyTimeout
+	}
+}
+
 // handle notification from manager
 func (m *Member) handleNknMsg(notification *managerToMember) error {
+	if notification.MsgType == NOTI_SNAPSHOT {
+		return m.applySnapshot(notification.Snapshot)
+	}
+
+	// Managers which push snapshots also send the legacy notifications for older members.
+	// Once a snapshot is applied, changes they notify are synced by getting the latest
+	// snapshot, so they are not lost if the snapshot push is.
+	if m.hasSnapshot() && notification.MsgType != NKN_PING {
+		switch notification.MsgType {
+		case NOTI_MEMBER_OFFLINE:
+			for _, n := range notification.NodeInfo {
+				log.Printf("Network member, the member '%v' is offline, its IP is %v\n", n.Name, n.IP)
+			}
+		case NOTI_AUTHORIZED, NOTI_NEW_MEMBER, NOTI_UPD_I_ACCEPT, NOTI_MEMBER_ONLINE, NOTI_UPD_I_CAN_ACCESS:
+		default:
+			return nil
+		}
+		return m.GetSnapshot()
+	}
+
 	switch notification.MsgType {
 	case NOTI_AUTHORIZED: // I was authorized by manager
 		if len(notification.NodeInfo) > 0 {
@@ -131,6 +275,7 @@ func (m *Member) handleNknMsg(notification *managerToMember) error {
 			log.Printf("\n\nCongratulations!!! Your nConnect network member is authorized, IP: %v, mask: %v\n\n",
 				m.networkData.NodeInfo.IP, m.networkData.NodeInfo.Netmask)
 
+			m.joinedNetwork.Store(true)
 			m.OpenTunAndSetIp()
 			m.GetNodeICanAccess()
 		}
@@ -154,6 +299,15 @@ func (m *Member) handleNknMsg(notification *managerToMember) error {
 		}
 		m.UpdMyAccept(notification.NodeInfo)
 
+	case NOTI_MEMBER_OFFLINE:
+		for _, n := range notification.NodeInfo {
+			log.Printf("Network member, the member '%v' is offline, its IP is %v\n", n.Name, n.IP)
+		}
+		m.GetNodeICanAccess()
+		if m.CbNodeICanAccessUpdated != nil {
+			m.CbNodeICanAccessUpdated(m.networkData.NodesICanAccess)
+		}
+
 	case NOTI_UPD_I_CAN_ACCESS:
 		m.GetNodeICanAccess()
 		if m.CbNodeICanAccessUpdated != nil {
@@ -161,15 +315,39 @@ func (m *Member) handleNknMsg(notification *managerToMember) error {
 		}
 
 	case NKN_PING:
-		log.Println("Network member, received ping from manager, send pong back")
+		log.Println("Network member, received ping, send pong back")
 
 	default:
-		return fmt.Errorf("nConnect member got unknown notification type: %v", notification.MsgType)
+		// notifications added by newer managers are skipped
+		if m.opts.Verbose {
+			log.Printf("Network member, ignore unknown notification type: %v\n", notification.MsgType)
+		}
 	}
 
 	return nil
 }
 
+func (m *Member) managerSupports(capability uint64) bool {
+	m.protocolLock.RLock()
+	defer m.protocolLock.RUnlock()
+	return m.managerProtocol.supports(capability)
+}
+
+// sendToManager encodes msg as protobuf envelope once manager advertised support for it,
+// and keeps the protocol negotiated with manager up to date from its replies.
+func (m *Member) sendToManager(msg *memberToManager, waitResponse bool) (*managerToMember, error) {
+	resp, err := SendMsg(m.c, m.opts.ManagerAddress, msg, m.managerSupports(capProtobuf), waitResponse)
+	if err != nil || resp == nil {
+		return resp, err
+	}
+
+	m.protocolLock.Lock()
+	m.managerProtocol = newPeerProtocol(&resp.msgHeader)
+	m.protocolLock.Unlock()
+
+	return resp, nil
+}
+
 func (m *Member) JoinNetwork(serverAddr string) error {
 	if serverAddr == "" {
 		serverAddr = m.networkData.NodeInfo.ServerAddress
@@ -181,7 +359,7 @@ func (m *Member) JoinNetwork(serverAddr string) error {
 	}
 
 	msg := memberToManager{MsgType: JOIN_NETWORK, Name: m.opts.NodeName, ServerAddress: serverAddr}
-	resp, err := SendMsg(m.c, m.opts.ManagerAddress, &msg, true)
+	resp, err := m.sendToManager(&msg, true)
 	if err != nil {
 		return err
 	}
@@ -207,7 +385,7 @@ func (m *Member) JoinNetwork(serverAddr string) error {
 		m.networkData.NetworkInfo = resp.NetworkInfo
 		m.saveMemberData()
 		if m.networkData.NodeInfo.IP != "" {
-			m.joinedNetwork = true
+			m.joinedNetwork.Store(true)
 			m.OpenTunAndSetIp()
 
 			log.Printf("\n\nCongratulations!!! Your nConnect network member IP is: %v, mask is: %v\n\n",
@@ -220,9 +398,36 @@ func (m *Member) JoinNetwork(serverAddr string) error {
 	return nil
 }
 
+// UpdateMyInfo sends this node's profile to the manager, which shares it with peers.
+func (m *Member) UpdateMyInfo() error {
+	info := &NodeInfo{
+		Name:        m.opts.NodeName,
+		Description: m.opts.NodeDescription,
+		Tags:        m.opts.NodeTags,
+		OS:          runtime.GOOS + "/" + runtime.GOARCH,
+		Version:     config.Version,
+		Routes:      m.opts.NodeRoutes,
+		IPPacket:    m.opts.IPPacket,
+	}
+	resp, err := m.sendToManager(&memberToManager{MsgType: UPDATE_MY_INFO, NodeInfo: info}, true)
+	if err != nil {
+		return err
+	}
+	if resp.Err != "" {
+		return errors.New(resp.Err)
+	}
+
+	if len(resp.NodeInfo) > 0 && resp.NodeInfo[0] != nil {
+		m.networkData.NodeInfo = resp.NodeInfo[0]
+		return m.saveMemberData()
+	}
+
+	return nil
+}
+
 func (m *Member) LeaveNetwork() error {
 	msg := memberToManager{MsgType: LEAVE_NETWORK, Name: m.opts.NodeName}
-	resp, err := SendMsg(m.c, m.opts.ManagerAddress, &msg, true)
+	resp, err := m.sendToManager(&msg, true)
 	if err != nil {
 		return err
 	}
@@ -247,7 +452,7 @@ func (m *Member) SetServerTunnel(t *tunnel.Tunnel) error {
 	}
 
 	msg := memberToManager{MsgType: UPDATE_SERVER_ADDRESS, ServerAddress: serverAddress}
-	_, err = SendMsg(m.c, m.opts.ManagerAddress, &msg, false)
+	_, err = m.sendToManager(&msg, false)
 	if err != nil {
 		return err
 	}
@@ -262,7 +467,7 @@ func (m *Member) SetServerTunnel(t *tunnel.Tunnel) error {
 
 func (m *Member) GetNodeIAccept() error {
 	msg := memberToManager{MsgType: GET_NODES_I_ACCEPT}
-	resp, err := SendMsg(m.c, m.opts.ManagerAddress, &msg, true)
+	resp, err := m.sendToManager(&msg, true)
 	if err != nil {
 		return err
 	}
@@ -311,7 +516,7 @@ func (m *Member) UpdMyAccept(nodes []*NodeInfo) {
 
 func (m *Member) GetNodeICanAccess() error {
 	msg := memberToManager{MsgType: GET_NODES_I_CAN_ACCESS, Name: m.opts.NodeName}
-	resp, err := SendMsg(m.c, m.opts.ManagerAddress, &msg, true)
+	resp, err := m.sendToManager(&msg, true)
 	if err != nil {
 		return err
 	}
@@ -333,6 +538,91 @@ func (m *Member) GetNodeICanAccess() error {
 	return nil
 }
 
+// GetSnapshot fetches the latest network snapshot from manager and applies it.
+func (m *Member) GetSnapshot() error {
+	msg := memberToManager{MsgType: GET_SNAPSHOT}
+	resp, err := m.sendToManager(&msg, true)
+	if err != nil {
+		return err
+	}
+	if resp.Err != "" {
+		return errors.New(resp.Err)
+	}
+
+	return m.applySnapshot(resp.Snapshot)
+}
+
+func (m *Member) hasSnapshot() bool {
+	m.snapshotLock.Lock()
+	defer m.snapshotLock.Unlock()
+	return m.snapshotVersion > 0
+}
+
+// applySnapshot verifies a snapshot signed by manager and replaces the member's
+// network view with it. Snapshots not newer than the applied one are ignored.
+func (m *Member) applySnapshot(s *signedSnapshot) error {
+	data, err := verifySnapshot(s, m.opts.ManagerAddress, m.c.Address())
+	if err != nil {
+		return err
+	}
+
+	m.snapshotLock.Lock()
+	defer m.snapshotLock.Unlock()
+
+	if data.Version <= m.snapshotVersion {
+		if m.opts.Verbose {
+			log.Printf("Network member, ignore snapshot version %v, current version %v\n", data.Version, m.snapshotVersion)
+		}
+		return nil
+	}
+	m.snapshotVersion = data.Version
+
+	authorized := m.networkData.NodeInfo == nil || m.networkData.NodeInfo.IP == ""
+	if data.NetworkInfo != nil {
+		m.networkData.NetworkInfo = data.NetworkInfo
+	}
+	if data.NodeInfo != nil {
+		if m.serverAddress != "" && data.NodeInfo.ServerAddress != m.serverAddress {
+			msg := memberToManager{MsgType: UPDATE_SERVER_ADDRESS, ServerAddress: m.serverAddress}
+			if _, err = m.sendToManager(&msg, false); err != nil {
+				log.Println("Network member, send server address error:", err)
+			}
+			data.NodeInfo.ServerAddress = m.serverAddress
+		}
+		m.networkData.NodeInfo = data.NodeInfo
+	} else {
+		// manager removed me, stop heartbeat so it doesn't join me again as a new member
+		if m.joinedNetwork.Swap(false) {
+			log.Println("Network member, removed from the network by manager")
+		}
+		m.networkData.NodeInfo = &NodeInfo{ServerAddress: m.serverAddress}
+	}
+	authorized = authorized && m.networkData.NodeInfo.IP != ""
+	m.networkData.NodesIAccept = data.NodesIAccept
+	m.networkData.NodesICanAccess = data.NodesICanAccess
+	if err = m.saveMemberData(); err != nil {
+		return err
+	}
+
+	if m.opts.Verbose {
+		log.Printf("Network member, applied snapshot version %v\n", data.Version)
+	}
+
+	if authorized {
+		m.joinedNetwork.Store(true)
+		log.Printf("\n\nCongratulations!!! Your nConnect network member is authorized, IP: %v, mask: %v\n\n",
+			m.networkData.NodeInfo.IP, m.networkData.NodeInfo.Netmask)
+		m.OpenTunAndSetIp()
+	}
+
+	m.UpdMyAccept(m.networkData.NodesIAccept)
+	if m.CbNodeICanAccessUpdated != nil {
+		m.CbNodeICanAccessUpdated(m.networkData.NodesICanAccess)
+	}
+
+	return nil
+}
+
 func (m *Member) loadMemberData() error {
 	jsonFile, err := os.OpenFile(memberFile, os.O_CREATE|os.O_RDONLY, 0666)
 	if err != nil {
@@ -360,16 +650,21 @@ func (m *Member) loadMemberData() error {
 	if data.NodeInfo != nil {
 		m.networkData.NodeInfo = data.NodeInfo
 	}
+	m.updateIPPacketPeers()
 
 	return nil
 }
 
 func (m *Member) saveMemberData() error {
+	m.updateIPPacketPeers()
+
 	b, err := json.MarshalIndent(m.networkData, "", "  ")
 	if err != nil {
 		return err
 	}
 
+	defer m.notifyChanges()
+
 	return os.WriteFile(memberFile, b, os.ModePerm)
 }
 
@@ -389,9 +684,9 @@ func (m *Member) SetRoutes() error {
 			ipNets[i] = cidr
 		}
 	}
-	arch.SetVPNRoutes(m.opts.TunName, m.networkData.NetworkInfo.Gateway, ipNets)
+	_, err := arch.SetVPNRoutes(m.opts.TunName, m.networkData.NetworkInfo.Gateway, ipNets)
 
-	return nil
+	return err
 }
 
 func (m *Member) DeleteRoutes() error {
@@ -411,22 +706,50 @@ func (m *Member) DeleteRoutes() error {
 		}
 	}
 
-	arch.RemoveVPNRoutes(m.opts.TunName, m.networkData.NetworkInfo.Gateway, ipNets)
-
-	return nil
+	return arch.RemoveVPNRoutes(m.opts.TunName, m.networkData.NetworkInfo.Gateway, ipNets)
 }
 
 func (m *Member) GetNodeInfo() *NodeInfo {
 	return m.networkData.NodeInfo
 }
 
+// GetNodesICanAccess returns the nodes I can access, the slice should not be modified.
+func (m *Member) GetNodesICanAccess() []*NodeInfo {
+	return m.networkData.NodesICanAccess
+}
+
+// GetNodesIAccept returns the nodes which can access me, the slice should not be modified.
+func (m *Member) GetNodesIAccept() []*NodeInfo {
+	return m.networkData.NodesIAccept
+}
+
 func (m *Member) GetNetworkInfo() *networkInfo {
 	return m.networkData.NetworkInfo
 }
 
 func (m *Member) OpenTunAndSetIp() {
 	m.openTunOnce.Do(func() {
-		err := arch.OpenTun(m.opts.TunName, m.networkData.NodeInfo.IP, m.networkData.NetworkInfo.Gateway, m.networkData.NodeInfo.Netmask, m.opts.TunDNS[0], m.opts.LocalSocksAddr)
+		tunConf := &arch.TunConfig{
+			Name:       m.opts.TunName,
+			Addr:       m.networkData.NodeInfo.IP,
+			Gateway:    m.networkData.NetworkInfo.Gateway,
+			Mask:       m.networkData.NodeInfo.Netmask,
+			DNS:        m.opts.TunDNS,
+			MTU:        m.opts.TunMTU,
+			UDPTimeout: time.Duration(m.opts.TunUDPTimeout) * time.Second,
+			SocksAddr:  m.opts.LocalSocksAddr,
+		}
+		if m.opts.TunDirect {
+			tunConf.Dial = ss.Dial
+			tunConf.ListenPacket = ss.ListenPacket
+		}
+		if m.opts.IPPacket {
+			tunConf.Intercept = m.SendIPPacket
+		}
+		if m.opts.UDP {
+			tunConf.ICMPEcho = ss.ICMPEcho
+		}
+		err := arch.OpenTun(tunConf)
 		if err != nil {
 			log.Printf("OpenTun error: %v", err)
 		} else {
@@ -1,10 +1,10 @@
 package network
 
 import (
-	"encoding/json"
 	"time"
 
 	"github.com/nknorg/nconnect/admin"
+	"github.com/nknorg/nkn-sdk-go"
 )
 
 // msgType constants
@@ -26,17 +26,33 @@ const (
 	NOTI_UPD_I_ACCEPT
 	NOTI_MEMBER_ONLINE
 	NOTI_LEAVE_NETWORK
+
+	GET_SNAPSHOT
+	NOTI_SNAPSHOT
+
+	HEARTBEAT
+	NOTI_MEMBER_OFFLINE
+
+	DIAGNOSE
 )
 
 type NodeInfo struct {
-	IP            string    `json:"ip"`
-	Netmask       string    `json:"netmask"`
-	Name          string    `json:"name"`
-	Address       string    `json:"address"`       // client address
-	ServerAddress string    `json:"serverAddress"` // nconnect server listen address
-	LastSeen      time.Time `json:"lastSeen"`
-	Server        bool      `json:"server"`
-	Balance       string    `json:"balance"`
+	IP             string    `json:"ip"`
+	Netmask        string    `json:"netmask"`
+	Name           string    `json:"name"`
+	Address        string    `json:"address"`       // client address
+	ServerAddress  string    `json:"serverAddress"` // nconnect server listen address
+	LastSeen       time.Time `json:"lastSeen"`
+	Server         bool      `json:"server"`
+	Balance        string    `json:"balance"`
+	Online         bool      `json:"online"`
+	Description    string    `json:"description,omitempty"`
+	Tags           []string  `json:"tags,omitempty"`
+	OS             string    `json:"os,omitempty"`             // os and arch of the member
+	Version        string    `json:"version,omitempty"`        // nConnect version of the member
+	Routes         []string  `json:"routes,omitempty"`         // CIDRs reachable through the member
+	IPPacket       bool      `json:"ipPacket,omitempty"`       // exchanges raw IP packets with other members
+	ApprovedRoutes []string  `json:"approvedRoutes,omitempty"` // routes approved by manager, only these are used by other members
 }
 
 type networkInfo struct {
@@ -46,27 +62,64 @@ type networkInfo struct {
 }
 
 type memberToManager struct {
-	MsgType       int    `json:"msgType"`
-	Name          string `json:"name"`
-	ServerAddress string `json:"serverAddress"`
+	msgHeader
+	MsgType       int       `json:"msgType"`
+	Name          string    `json:"name"`
+	ServerAddress string    `json:"serverAddress"`
+	NodeInfo      *NodeInfo `json:"nodeInfo,omitempty"` // info to update by UPDATE_MY_INFO
 }
 
 type managerToMember struct {
-	MsgType     int          `json:"msgType"`
-	Err         string       `json:"err"`
-	NetworkInfo *networkInfo `json:"networkInfo"`
-	NodeInfo    []*NodeInfo  `json:"nodeInfo"`
+	msgHeader
+	MsgType     int             `json:"msgType"`
+	Err         string          `json:"err"`
+	NetworkInfo *networkInfo    `json:"networkInfo"`
+	NodeInfo    []*NodeInfo     `json:"nodeInfo"`
+	Snapshot    *signedSnapshot `json:"snapshot,omitempty"`
+	DiagResult  *DiagResult     `json:"diagResult,omitempty"`
+}
+
+// SendMsg encodes msg as a protobuf envelope if usePb is true, otherwise as legacy json, and sends it
+// to address. The reply is decoded from either encoding.
+func SendMsg(mc *admin.Client, address string, msg message, usePb, waitResponse bool) (*managerToMember, error) {
+	return sendMsg(msg, usePb, waitResponse, func(b []byte) (*nkn.Message, error) {
+		return mc.SendData(address, b, waitResponse)
+	})
+}
+
+// SendMsgOnce is like SendMsg waiting for response, but sends msg only once and waits for the
+// reply within timeout, for requests which should not be handled twice.
+func SendMsgOnce(mc *admin.Client, address string, msg message, usePb bool, timeout time.Duration) (*managerToMember, error) {
+	return sendMsg(msg, usePb, true, func(b []byte) (*nkn.Message, error) {
+		return mc.SendDataOnce(address, b, timeout)
+	})
 }
 
-func SendMsg(mc *admin.Client, address string, msg interface{}, waitResponse bool) (*managerToMember, error) {
-	reply, err := mc.SendMsg(address, msg, waitResponse)
+func sendMsg(msg message, usePb, waitResponse bool, send func([]byte) (*nkn.Message, error)) (*managerToMember, error) {
+	h := msg.header()
+	h.ProtocolVersion = ProtocolVersion
+	h.Capabilities = localCapabilities
+	if waitResponse && h.RequestID == "" {
+		h.RequestID = newRequestID()
+	}
+
+	b, err := encodeMsg(msg, usePb)
+	if err != nil {
+		return nil, err
+	}
+
+	reply, err := send(b)
 	if err != nil || !waitResponse {
 		return nil, err
 	}
 
-	var respMsg managerToMember
-	if err = json.Unmarshal(reply.Data, &respMsg); err != nil {
+	respMsg, _, err := decodeManagerMsg(reply.Data)
+	if err != nil {
 		return nil, err
 	}
-	return &respMsg, nil
+	if respMsg.RequestID != "" && respMsg.RequestID != h.RequestID {
+		return nil, errRequestIDMismatch
+	}
+
+	return respMsg, nil
 }
@@ -0,0 +1,190 @@
+package network
+
+import (
+	"log"
+	"time"
+
+	"github.com/nknorg/nconnect/event"
+)
+
+const (
+	defaultHeartbeatInterval    = 30 * time.Second
+	defaultMemberOfflineTimeout = 90 * time.Second
+
+	maxUptimeHistory = 50 // max online periods kept for each member
+)
+
+// onlinePeriod is a period of time a member was online, End is nil while the member is still online.
+type onlinePeriod struct {
+	Start time.Time  `json:"start"`
+	End   *time.Time `json:"end,omitempty"`
+}
+
+// resetPresence marks all members offline when manager starts, because manager
+// can't know whether members are still online while it was not running.
+func (m *Manager) resetPresence() error {
+	m.Lock()
+	defer m.Unlock()
+
+	changed := false
+	for addr, node := range m.networkData.Member {
+		if node.Online {
+			node.Online = false
+			m.endOnlinePeriod(addr, node.LastSeen)
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+
+	return m.saveNetworkData()
+}
+
+// markOnline updates member's last seen time, and returns true if the member was offline.
+func (m *Manager) markOnline(address string) bool {
+	m.Lock()
+	defer m.Unlock()
+
+	node, ok := m.networkData.Member[address]
+	if !ok {
+		return false
+	}
+
+	now := time.Now()
+	node.LastSeen = now
+	if node.Online {
+		return false
+	}
+
+	node.Online = true
+	history := append(m.networkData.UptimeHistory[address], &onlinePeriod{Start: now})
+	if len(history) > maxUptimeHistory {
+		history = history[len(history)-maxUptimeHistory:]
+	}
+	m.networkData.UptimeHistory[address] = history
+
+	return true
+}
+
+func (m *Manager) endOnlinePeriod(address string, end time.Time) {
+	history := m.networkData.UptimeHistory[address]
+	if len(history) > 0 && history[len(history)-1].End == nil {
+		history[len(history)-1].End = &end
+	}
+}
+
+// memberOnline broadcasts a member which was offline is online again.
+func (m *Manager) memberOnline(address string) {
+	node := m.GetNodeInfo(address)
+	if node == nil {
+		return
+	}
+
+	m.Lock()
+	err := m.saveNetworkData()
+	m.Unlock()
+	if err != nil {
+		log.Println("nConnect manager save network data error:", err)
+	}
+
+	m.NotifyIAccept(address, &managerToMember{MsgType: NOTI_MEMBER_ONLINE, NodeInfo: []*NodeInfo{node}})
+	go m.PushSnapshots()
+
+	log.Printf("The member '%v' is online, its IP is %v\n", node.Name, node.IP)
+	event.Emit(event.MemberOnline, *node)
+}
+
+// StartPresenceMonitor marks members offline if no heartbeat is received within offline timeout.
+// Members which don't send heartbeat keep the online state from their last join.
+func (m *Manager) StartPresenceMonitor() {
+	timeout := time.Duration(m.opts.MemberOfflineTimeout) * time.Second
+	if timeout <= 0 {
+		timeout = defaultMemberOfflineTimeout
+	}
+
+	ticker := time.NewTicker(timeout / 3)
+	defer ticker.Stop()
+	for range ticker.C {
+		m.checkPresence(timeout)
+	}
+}
+
+func (m *Manager) checkPresence(timeout time.Duration) {
+	var offline []*NodeInfo
+
+	m.Lock()
+	now := time.Now()
+	for addr, node := range m.networkData.Member {
+		if !node.Online || now.Sub(node.LastSeen) < timeout {
+			continue
+		}
+		if !m.peerProtocol(addr).supports(capHeartbeat) {
+			continue
+		}
+		node.Online = false
+		m.endOnlinePeriod(addr, node.LastSeen)
+		offline = append(offline, node)
+	}
+	if len(offline) > 0 {
+		if err := m.saveNetworkData(); err != nil {
+			log.Println("nConnect manager save network data error:", err)
+		}
+	}
+	m.Unlock()
+
+	for _, node := range offline {
+		log.Printf("The member '%v' is offline, its IP is %v\n", node.Name, node.IP)
+		event.Emit(event.MemberOffline, *node)
+		m.NotifyIAccept(node.Address, &managerToMember{MsgType: NOTI_MEMBER_OFFLINE, NodeInfo: []*NodeInfo{node}})
+	}
+	if len(offline) > 0 {
+		m.PushSnapshots()
+	}
+}
+
+// StartHeartbeat sends heartbeat to manager periodically after joining the network.
+func (m *Member) StartHeartbeat() {
+	interval := time.Duration(m.opts.HeartbeatInterval) * time.Second
+	if interval <= 0 {
+		interval = defaultHeartbeatInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if !m.joinedNetwork.Load() || !m.managerSupports(capHeartbeat) {
+			continue
+		}
+		resp, err := m.sendToManager(&memberToManager{MsgType: HEARTBEAT}, true)
+		if err != nil {
+			log.Println("Network member, send heartbeat error:", err)
+			continue
+		}
+		if resp.Err == errNodeNotFound {
+			m.rejoin()
+		}
+	}
+}
+
+// rejoin joins the network again when manager no longer knows this member, e.g. its data
+// was lost when it restarted. Heartbeat stops until the member is authorized again. A
+// removed member gets the same error, so a newer snapshot is checked first, applying it
+// clears joined state if manager removed this member.
+func (m *Member) rejoin() {
+	if m.managerSupports(capSnapshot) {
+		if err := m.GetSnapshot(); err != nil {
+			log.Println("Network member, get snapshot error:", err)
+			return
+		}
+		if !m.joinedNetwork.Load() {
+			return
+		}
+	}
+
+	log.Println("Network member, manager doesn't know this member, join the network again")
+	m.joinedNetwork.Store(false)
+	if err := m.Reconnect(); err != nil {
+		log.Println("Network member, join the network again error:", err)
+	}
+}
@@ -0,0 +1,321 @@
+package network
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/nknorg/nconnect/network/pb"
+	"google.golang.org/protobuf/proto"
+)
+
+// Protocol versions of manager/member messages
+const (
+	legacyProtocolVersion = 1 // plain json messages, sent by nodes which predate protocol versioning
+	ProtocolVersion       = 2 // protobuf envelope, see pb/network.proto
+)
+
+// Capability flags advertised in every message
+const (
+	capProtobuf     uint64 = 1 << iota // accepts protobuf envelopes
+	capSnapshot                        // accepts signed network snapshots
+	capHeartbeat                       // sends or tracks heartbeats
+	capUpdateMyInfo                    // handles UPDATE_MY_INFO
+	capDiagnose                        // runs DIAGNOSE requested by manager
+)
+
+const localCapabilities = capProtobuf | capSnapshot | capHeartbeat | capUpdateMyInfo | capDiagnose
+
+var (
+	errEmptyMsg           = errors.New("empty message")
+	errRequestIDMismatch  = errors.New("reply request id mismatch")
+	errUnknownMsgEnvelope = errors.New("unknown message envelope")
+)
+
+// msgHeader is embedded in both directions of messages. Nodes which predate
+// protocol versioning leave it empty, and ignore it when they receive json.
+type msgHeader struct {
+	ProtocolVersion uint32 `json:"protocolVersion,omitempty"`
+	Capabilities    uint64 `json:"capabilities,omitempty"`
+	RequestID       string `json:"requestId,omitempty"`
+}
+
+func (h *msgHeader) header() *msgHeader {
+	return h
+}
+
+type message interface {
+	header() *msgHeader
+}
+
+// peerProtocol is the protocol negotiated with a peer, it is nil until the
+// peer sends us a message.
+type peerProtocol struct {
+	Version      uint32
+	Capabilities uint64
+}
+
+func newPeerProtocol(h *msgHeader) *peerProtocol {
+	p := &peerProtocol{Version: h.ProtocolVersion, Capabilities: h.Capabilities & localCapabilities}
+	if p.Version == 0 {
+		p.Version = legacyProtocolVersion
+	}
+	if p.Version > ProtocolVersion {
+		p.Version = ProtocolVersion
+	}
+	return p
+}
+
+func (p *peerProtocol) supports(capability uint64) bool {
+	return p != nil && p.Version >= ProtocolVersion && p.Capabilities&capability != 0
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// encodeMsg encodes msg as a protobuf envelope if usePb is true, otherwise as legacy json.
+func encodeMsg(msg message, usePb bool) ([]byte, error) {
+	if !usePb {
+		return json.Marshal(msg)
+	}
+
+	h := msg.header()
+	env := &pb.Envelope{
+		ProtocolVersion: h.ProtocolVersion,
+		Capabilities:    h.Capabilities,
+		RequestId:       h.RequestID,
+	}
+	switch m := msg.(type) {
+	case *memberToManager:
+		env.Body = &pb.Envelope_MemberToManager{MemberToManager: m.toPb()}
+	case *managerToMember:
+		env.Body = &pb.Envelope_ManagerToMember{ManagerToMember: m.toPb()}
+	default:
+		return nil, fmt.Errorf("can not encode message of type %T", msg)
+	}
+
+	return proto.Marshal(env)
+}
+
+// A json message always starts with '{', which is never a valid first byte of an envelope.
+func isLegacyMsg(data []byte) bool {
+	return len(data) > 0 && data[0] == '{'
+}
+
+func decodeEnvelope(data []byte) (*pb.Envelope, msgHeader, error) {
+	env := &pb.Envelope{}
+	if err := proto.Unmarshal(data, env); err != nil {
+		return nil, msgHeader{}, err
+	}
+	h := msgHeader{
+		ProtocolVersion: env.ProtocolVersion,
+		Capabilities:    env.Capabilities,
+		RequestID:       env.RequestId,
+	}
+	return env, h, nil
+}
+
+// decodeMemberMsg decodes a message sent by member, usePb tells which encoding it uses.
+func decodeMemberMsg(data []byte) (msg *memberToManager, usePb bool, err error) {
+	if len(data) == 0 {
+		return nil, false, errEmptyMsg
+	}
+
+	if isLegacyMsg(data) {
+		msg = &memberToManager{}
+		err = json.Unmarshal(data, msg)
+		return msg, false, err
+	}
+
+	env, h, err := decodeEnvelope(data)
+	if err != nil {
+		return nil, true, err
+	}
+	body := env.GetMemberToManager()
+	if body == nil {
+		return nil, true, errUnknownMsgEnvelope
+	}
+	msg = memberToManagerFromPb(body)
+	msg.msgHeader = h
+
+	return msg, true, nil
+}
+
+// decodeManagerMsg decodes a message sent by manager, usePb tells which encoding it uses.
+func decodeManagerMsg(data []byte) (msg *managerToMember, usePb bool, err error) {
+	if len(data) == 0 {
+		return nil, false, errEmptyMsg
+	}
+
+	if isLegacyMsg(data) {
+		msg = &managerToMember{}
+		err = json.Unmarshal(data, msg)
+		return msg, false, err
+	}
+
+	env, h, err := decodeEnvelope(data)
+	if err != nil {
+		return nil, true, err
+	}
+	body := env.GetManagerToMember()
+	if body == nil {
+		return nil, true, errUnknownMsgEnvelope
+	}
+	msg = managerToMemberFromPb(body)
+	msg.msgHeader = h
+
+	return msg, true, nil
+}
+
+func (m *memberToManager) toPb() *pb.MemberToManager {
+	p := &pb.MemberToManager{
+		MsgType:       pb.MsgType(m.MsgType),
+		Name:          m.Name,
+		ServerAddress: m.ServerAddress,
+	}
+	if m.NodeInfo != nil {
+		p.NodeInfo = nodeInfosToPb([]*NodeInfo{m.NodeInfo})[0]
+	}
+	return p
+}
+
+func memberToManagerFromPb(p *pb.MemberToManager) *memberToManager {
+	m := &memberToManager{
+		MsgType:       int(p.MsgType),
+		Name:          p.Name,
+		ServerAddress: p.ServerAddress,
+	}
+	if p.NodeInfo != nil {
+		m.NodeInfo = nodeInfosFromPb([]*pb.NodeInfo{p.NodeInfo})[0]
+	}
+	return m
+}
+
+func (m *managerToMember) toPb() *pb.ManagerToMember {
+	p := &pb.ManagerToMember{
+		MsgType:  pb.MsgType(m.MsgType),
+		Err:      m.Err,
+		NodeInfo: nodeInfosToPb(m.NodeInfo),
+	}
+	if m.NetworkInfo != nil {
+		p.NetworkInfo = &pb.NetworkInfo{Domain: m.NetworkInfo.Domain, Gateway: m.NetworkInfo.Gateway, Dns: m.NetworkInfo.DNS}
+	}
+	if m.Snapshot != nil {
+		p.Snapshot = &pb.SignedSnapshot{Data: m.Snapshot.Data, Signature: m.Snapshot.Signature}
+	}
+	if r := m.DiagResult; r != nil {
+		p.DiagResult = &pb.DiagResult{
+			Target:       r.Target,
+			Ip:           r.IP,
+			Sent:         int32(r.Sent),
+			Lost:         int32(r.Lost),
+			MinRttMs:     r.MinRttMs,
+			AvgRttMs:     r.AvgRttMs,
+			MaxRttMs:     r.MaxRttMs,
+			JitterMs:     r.JitterMs,
+			UploadMbps:   r.UploadMbps,
+			DownloadMbps: r.DownloadMbps,
+		}
+	}
+	return p
+}
+
+func managerToMemberFromPb(p *pb.ManagerToMember) *managerToMember {
+	m := &managerToMember{
+		MsgType:  int(p.MsgType),
+		Err:      p.Err,
+		NodeInfo: nodeInfosFromPb(p.NodeInfo),
+	}
+	if p.NetworkInfo != nil {
+		m.NetworkInfo = &networkInfo{Domain: p.NetworkInfo.Domain, Gateway: p.NetworkInfo.Gateway, DNS: p.NetworkInfo.Dns}
+	}
+	if p.Snapshot != nil {
+		m.Snapshot = &signedSnapshot{Data: p.Snapshot.Data, Signature: p.Snapshot.Signature}
+	}
+	if r := p.DiagResult; r != nil {
+		m.DiagResult = &DiagResult{
+			Target:       r.Target,
+			IP:           r.Ip,
+			Sent:         int(r.Sent),
+			Lost:         int(r.Lost),
+			MinRttMs:     r.MinRttMs,
+			AvgRttMs:     r.AvgRttMs,
+			MaxRttMs:     r.MaxRttMs,
+			JitterMs:     r.JitterMs,
+			UploadMbps:   r.UploadMbps,
+			DownloadMbps: r.DownloadMbps,
+		}
+	}
+	return m
+}
+
+func nodeInfosToPb(nodes []*NodeInfo) []*pb.NodeInfo {
+	if len(nodes) == 0 {
+		return nil
+	}
+	list := make([]*pb.NodeInfo, 0, len(nodes))
+	for _, n := range nodes {
+		if n == nil {
+			continue
+		}
+		p := &pb.NodeInfo{
+			Ip:             n.IP,
+			Netmask:        n.Netmask,
+			Name:           n.Name,
+			Address:        n.Address,
+			ServerAddress:  n.ServerAddress,
+			Server:         n.Server,
+			Balance:        n.Balance,
+			Online:         n.Online,
+			Description:    n.Description,
+			Tags:           n.Tags,
+			Os:             n.OS,
+			Version:        n.Version,
+			Routes:         n.Routes,
+			IpPacket:       n.IPPacket,
+			ApprovedRoutes: n.ApprovedRoutes,
+		}
+		if !n.LastSeen.IsZero() {
+			p.LastSeen = n.LastSeen.UnixNano()
+		}
+		list = append(list, p)
+	}
+	return list
+}
+
+func nodeInfosFromPb(nodes []*pb.NodeInfo) []*NodeInfo {
+	if len(nodes) == 0 {
+		return nil
+	}
+	list := make([]*NodeInfo, 0, len(nodes))
+	for _, p := range nodes {
+		n := &NodeInfo{
+			IP:             p.Ip,
+			Netmask:        p.Netmask,
+			Name:           p.Name,
+			Address:        p.Address,
+			ServerAddress:  p.ServerAddress,
+			Server:         p.Server,
+			Balance:        p.Balance,
+			Online:         p.Online,
+			Description:    p.Description,
+			Tags:           p.Tags,
+			OS:             p.Os,
+			Version:        p.Version,
+			Routes:         p.Routes,
+			IPPacket:       p.IpPacket,
+			ApprovedRoutes: p.ApprovedRoutes,
+		}
+		if p.LastSeen != 0 {
+			n.LastSeen = time.Unix(0, p.LastSeen)
+		}
+		list = append(list, n)
+	}
+	return list
+}
@@ -0,0 +1,68 @@
+package network
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// go test -v -run=TestMsgEncoding
+func TestMsgEncoding(t *testing.T) {
+	resp := &managerToMember{
+		msgHeader:   msgHeader{ProtocolVersion: ProtocolVersion, Capabilities: localCapabilities, RequestID: "abc"},
+		MsgType:     JOIN_NETWORK,
+		NetworkInfo: &networkInfo{Domain: defaultDomain, Gateway: defaultGateway, DNS: defaultDNS},
+		NodeInfo:    []*NodeInfo{{IP: "10.0.86.3", Name: "alice", LastSeen: time.Unix(1700000000, 5)}},
+		Snapshot:    &signedSnapshot{Data: []byte("data"), Signature: []byte("sig")},
+		DiagResult:  &DiagResult{Target: "alice", IP: "10.0.86.3", Sent: 5, Lost: 1, AvgRttMs: 12.5, UploadMbps: 3.2},
+	}
+
+	for _, usePb := range []bool{false, true} {
+		b, err := encodeMsg(resp, usePb)
+		require.NoError(t, err)
+		require.Equal(t, !usePb, isLegacyMsg(b))
+
+		got, gotPb, err := decodeManagerMsg(b)
+		require.NoError(t, err)
+		require.Equal(t, usePb, gotPb)
+		require.Equal(t, resp.msgHeader, got.msgHeader)
+		require.Equal(t, resp.NetworkInfo, got.NetworkInfo)
+		require.Equal(t, resp.Snapshot, got.Snapshot)
+		require.Equal(t, resp.DiagResult, got.DiagResult)
+		require.Equal(t, "alice", got.NodeInfo[0].Name)
+		require.True(t, resp.NodeInfo[0].LastSeen.Equal(got.NodeInfo[0].LastSeen))
+	}
+
+	req := &memberToManager{MsgType: GET_SNAPSHOT, Name: "bob"}
+	b, err := encodeMsg(req, true)
+	require.NoError(t, err)
+	gotReq, usePb, err := decodeMemberMsg(b)
+	require.NoError(t, err)
+	require.True(t, usePb)
+	require.Equal(t, req, gotReq)
+
+	_, _, err = decodeManagerMsg(b)
+	require.Equal(t, errUnknownMsgEnvelope, err)
+}
+
+// go test -v -run=TestProtocolNegotiation
+func TestProtocolNegotiation(t *testing.T) {
+	// a legacy member sends json without header
+	legacy := &memberToManager{}
+	require.NoError(t, json.Unmarshal([]byte(`{"msgType":1,"name":"alice"}`), legacy))
+	p := newPeerProtocol(&legacy.msgHeader)
+	require.Equal(t, uint32(legacyProtocolVersion), p.Version)
+	require.False(t, p.supports(capProtobuf))
+	require.False(t, p.supports(capSnapshot))
+
+	// a future member only gets capabilities we know
+	p = newPeerProtocol(&msgHeader{ProtocolVersion: ProtocolVersion + 1, Capabilities: capSnapshot | 1<<40})
+	require.Equal(t, uint32(ProtocolVersion), p.Version)
+	require.True(t, p.supports(capSnapshot))
+	require.False(t, p.supports(capProtobuf))
+
+	var unknown *peerProtocol
+	require.False(t, unknown.supports(capProtobuf))
+}
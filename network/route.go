@@ -0,0 +1,173 @@
+package network
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+)
+
+// Members can advertise subnets reachable through them by routes, which are used by other
+// members only after manager approves them. Both manager and members check routes, so a
+// member can't take over traffic to the network or to other members.
+
+// minRoutePrefix is the shortest prefix of a route, so a member can't take over most of the
+// address space, e.g. by 0.0.0.0/1 and 128.0.0.0/1.
+const minRoutePrefix = 8
+
+// parseRoute parses a route advertised by a member and checks it's an IPv4 CIDR with a
+// prefix not shorter than minRoutePrefix, which doesn't overlap subnet or contain memberIPs.
+func parseRoute(route string, subnet *net.IPNet, memberIPs []net.IP) (*net.IPNet, error) {
+	_, cidr, err := net.ParseCIDR(route)
+	if err != nil {
+		return nil, err
+	}
+	if cidr.IP.To4() == nil {
+		return nil, errors.New("only IPv4 routes are supported")
+	}
+	if ones, _ := cidr.Mask.Size(); ones < minRoutePrefix {
+		return nil, fmt.Errorf("prefix is shorter than /%v", minRoutePrefix)
+	}
+	if subnet != nil && cidrsOverlap(cidr, subnet) {
+		return nil, fmt.Errorf("overlaps network subnet %v", subnet)
+	}
+	for _, ip := range memberIPs {
+		if cidr.Contains(ip) {
+			return nil, fmt.Errorf("contains member IP %v", ip)
+		}
+	}
+	return cidr, nil
+}
+
+func cidrsOverlap(a, b *net.IPNet) bool {
+	return a.Contains(b.IP) || b.Contains(a.IP)
+}
+
+// subnetOf returns the subnet of ip with netmask, or nil if either is invalid.
+func subnetOf(ip, netmask string) *net.IPNet {
+	addr, mask := net.ParseIP(ip).To4(), net.ParseIP(netmask).To4()
+	if addr == nil || mask == nil {
+		return nil
+	}
+	return &net.IPNet{IP: addr.Mask(net.IPMask(mask)), Mask: net.IPMask(mask)}
+}
+
+// containsRoute returns whether routes has route.
+func containsRoute(routes []string, route string) bool {
+	for _, r := range routes {
+		if r == route {
+			return true
+		}
+	}
+	return false
+}
+
+// routeLimits returns the network subnet and member IPs which routes must not overlap,
+// caller should hold the lock.
+func (m *Manager) routeLimits() (*net.IPNet, []net.IP) {
+	ips := make([]net.IP, 0, len(m.networkData.Member))
+	for _, n := range m.networkData.Member {
+		if ip := net.ParseIP(n.IP); ip != nil {
+			ips = append(ips, ip)
+		}
+	}
+	return subnetOf(m.networkData.IpStart, m.networkData.Netmask), ips
+}
+
+// ApproveRoutes sets the routes advertised by a member which other members use, routes not
+// given are no longer used. A route can't overlap routes approved for other members.
+func (m *Manager) ApproveRoutes(address string, routes []string) error {
+	m.Lock()
+	node, ok := m.networkData.Member[address]
+	if !ok {
+		m.Unlock()
+		return errors.New(errNodeNotFound)
+	}
+	subnet, memberIPs := m.routeLimits()
+	for _, route := range routes {
+		if !containsRoute(node.Routes, route) {
+			m.Unlock()
+			return fmt.Errorf("route %v is not advertised by '%v'", route, node.Name)
+		}
+		cidr, err := parseRoute(route, subnet, memberIPs)
+		if err != nil {
+			m.Unlock()
+			return fmt.Errorf("invalid route %v: %v", route, err)
+		}
+		for _, n := range m.networkData.Member {
+			if n.Address == address {
+				continue
+			}
+			for _, r := range n.ApprovedRoutes {
+				if _, other, err := net.ParseCIDR(r); err == nil && cidrsOverlap(cidr, other) {
+					m.Unlock()
+					return fmt.Errorf("route %v overlaps route %v of '%v'", route, r, n.Name)
+				}
+			}
+		}
+	}
+	node.ApprovedRoutes = routes
+	err := m.saveNetworkData()
+	m.Unlock()
+	if err != nil {
+		return err
+	}
+
+	m.NotifyIAccept(address, &managerToMember{MsgType: NOTI_UPD_I_CAN_ACCESS, NodeInfo: []*NodeInfo{node}})
+	go m.PushSnapshots()
+
+	log.Printf("You just approved routes %v of member '%v'\n", routes, node.Name)
+
+	return nil
+}
+
+// NodeRoutes returns the routes of node approved by manager. They are checked again, routes
+// overlapping the network, member IPs or routes of other nodes I can access are ignored.
+func (m *Member) NodeRoutes(node *NodeInfo) []*net.IPNet {
+	var subnet *net.IPNet
+	var memberIPs []net.IP
+	if me := m.networkData.NodeInfo; me != nil {
+		subnet = subnetOf(me.IP, me.Netmask)
+		memberIPs = append(memberIPs, net.ParseIP(me.IP))
+	}
+	for _, nodes := range [][]*NodeInfo{m.networkData.NodesICanAccess, m.networkData.NodesIAccept} {
+		for _, n := range nodes {
+			if n == nil {
+				continue
+			}
+			if ip := net.ParseIP(n.IP); ip != nil {
+				memberIPs = append(memberIPs, ip)
+			}
+		}
+	}
+
+	var cidrs []*net.IPNet
+	for _, route := range node.ApprovedRoutes {
+		cidr, err := parseRoute(route, subnet, memberIPs)
+		if err == nil {
+			err = m.routeConflict(node, cidr)
+		}
+		if err != nil {
+			log.Printf("Network member, ignore route %v of %v: %v\n", route, node.Name, err)
+			continue
+		}
+		cidrs = append(cidrs, cidr)
+	}
+	return cidrs
+}
+
+// routeConflict returns an error if cidr overlaps a route approved for another node I can
+// access, then neither is used, since which node the traffic should go to is unknown.
+func (m *Member) routeConflict(node *NodeInfo, cidr *net.IPNet) error {
+	for _, n := range m.networkData.NodesICanAccess {
+		if n == nil || n.Address == node.Address {
+			continue
+		}
+		for _, r := range n.ApprovedRoutes {
+			if _, other, err := net.ParseCIDR(r); err == nil && cidrsOverlap(cidr, other) {
+				return fmt.Errorf("overlaps route %v of %v", r, n.Name)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package network
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// go test -v -run=TestParseRoute
+func TestParseRoute(t *testing.T) {
+	subnet := subnetOf("10.0.86.2", "255.255.255.0")
+	require.Equal(t, "10.0.86.0/24", subnet.String())
+	memberIPs := []net.IP{net.ParseIP("10.0.86.2"), net.ParseIP("172.16.0.1")}
+
+	cidr, err := parseRoute("192.168.1.0/24", subnet, memberIPs)
+	require.NoError(t, err)
+	require.Equal(t, "192.168.1.0/24", cidr.String())
+
+	for _, route := range []string{
+		"192.168.1.0",    // not a CIDR
+		"fd00::/64",      // not IPv4
+		"0.0.0.0/0",      // default route
+		"128.0.0.0/1",    // prefix too short
+		"10.0.0.0/16",    // contains network subnet
+		"10.0.86.128/25", // in network subnet
+		"172.16.0.0/24",  // contains a member IP
+	} {
+		_, err = parseRoute(route, subnet, memberIPs)
+		require.Error(t, err, route)
+	}
+}
+
+// go test -v -run=TestApproveRoutes
+func TestApproveRoutes(t *testing.T) {
+	m, s := newTestManager(t)
+	m.networkData.Member["alice-addr"].Routes = []string{"192.168.1.0/24", "192.168.2.0/24"}
+	m.networkData.Member["bob-addr"].Routes = []string{"192.168.0.0/16"}
+
+	require.Error(t, m.ApproveRoutes("carol-addr", nil))
+	require.Contains(t, m.ApproveRoutes("alice-addr", []string{"192.168.3.0/24"}).Error(), "not advertised")
+	require.NoError(t, m.ApproveRoutes("alice-addr", []string{"192.168.1.0/24"}))
+	require.Equal(t, []string{"192.168.1.0/24"}, m.GetNodeInfo("alice-addr").ApprovedRoutes)
+	require.Contains(t, s.sentTo("bob-addr"), NOTI_UPD_I_CAN_ACCESS)
+
+	// routes can't overlap routes approved for other members
+	require.Contains(t, m.ApproveRoutes("bob-addr", []string{"192.168.0.0/16"}).Error(), "overlaps")
+
+	// withdrawn routes lose their approval
+	_, err := m.UpdateMemberInfo("alice-addr", &NodeInfo{Name: "alice", Routes: []string{"192.168.2.0/24"}})
+	require.NoError(t, err)
+	require.Empty(t, m.GetNodeInfo("alice-addr").ApprovedRoutes)
+	require.NoError(t, m.ApproveRoutes("bob-addr", []string{"192.168.0.0/16"}))
+}
+
+// go test -v -run=TestNodeRoutes
+func TestNodeRoutes(t *testing.T) {
+	alice := &NodeInfo{Name: "alice", Address: "alice-addr", IP: "10.0.86.3", ApprovedRoutes: []string{"192.168.1.0/24", "0.0.0.0/1", "10.0.86.4/32"}}
+	bob := &NodeInfo{Name: "bob", Address: "bob-addr", IP: "10.0.86.4", ApprovedRoutes: []string{"192.168.1.128/25", "192.168.2.0/24"}}
+
+	m := NewMember(nil, nil)
+	m.networkData.NodeInfo = &NodeInfo{IP: "10.0.86.2", Netmask: "255.255.255.0"}
+	m.networkData.NodesICanAccess = []*NodeInfo{alice, bob}
+
+	// routes not passing checks and routes overlapping each other are not used
+	require.Empty(t, m.NodeRoutes(alice))
+	routes := m.NodeRoutes(bob)
+	require.Len(t, routes, 1)
+	require.Equal(t, "192.168.2.0/24", routes[0].String())
+}
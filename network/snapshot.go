@@ -0,0 +1,77 @@
+package network
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/nknorg/nkn-sdk-go"
+	"github.com/nknorg/nkn/v2/crypto"
+)
+
+var (
+	errInvalidSnapshot   = errors.New("invalid network snapshot")
+	errSnapshotSignature = errors.New("network snapshot signature verification failed")
+	errSnapshotAddress   = errors.New("network snapshot is not built for this member")
+)
+
+// snapshotData is a member's complete view of the network at a given version.
+type snapshotData struct {
+	Version         uint64       `json:"version"`
+	Address         string       `json:"address"` // member address this snapshot is built for
+	Timestamp       time.Time    `json:"timestamp"`
+	NetworkInfo     *networkInfo `json:"networkInfo"`
+	NodeInfo        *NodeInfo    `json:"nodeInfo"`
+	NodesIAccept    []*NodeInfo  `json:"nodesIAccept"`
+	NodesICanAccess []*NodeInfo  `json:"nodesICanAccess"`
+	AcceptAddress   []string     `json:"acceptAddress"`
+}
+
+// signedSnapshot carries the json encoded snapshotData together with the
+// manager's signature over these exact bytes.
+type signedSnapshot struct {
+	Data      []byte `json:"data"`
+	Signature []byte `json:"signature"`
+}
+
+func signSnapshot(account *nkn.Account, data *snapshotData) (*signedSnapshot, error) {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+
+	sig, err := crypto.Sign(account.PrivKey(), b)
+	if err != nil {
+		return nil, err
+	}
+
+	return &signedSnapshot{Data: b, Signature: sig}, nil
+}
+
+// verifySnapshot checks the snapshot is signed by the manager and built for
+// memberAddress, then decodes it.
+func verifySnapshot(s *signedSnapshot, managerAddress, memberAddress string) (*snapshotData, error) {
+	if s == nil || len(s.Data) == 0 || len(s.Signature) == 0 {
+		return nil, errInvalidSnapshot
+	}
+
+	pubKey, err := nkn.ClientAddrToPubKey(managerAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = crypto.Verify(pubKey, s.Data, s.Signature); err != nil {
+		return nil, errSnapshotSignature
+	}
+
+	data := &snapshotData{}
+	if err = json.Unmarshal(s.Data, data); err != nil {
+		return nil, err
+	}
+
+	if data.Address != memberAddress {
+		return nil, errSnapshotAddress
+	}
+
+	return data, nil
+}
@@ -0,0 +1,40 @@
+package network
+
+import (
+	"testing"
+
+	"github.com/nknorg/nkn-sdk-go"
+	"github.com/nknorg/nkn/v2/util/address"
+	"github.com/stretchr/testify/require"
+)
+
+// go test -v -run=TestSnapshotSignature
+func TestSnapshotSignature(t *testing.T) {
+	account, err := nkn.NewAccount(nil)
+	require.NoError(t, err)
+	managerAddr := address.MakeAddressString(account.PubKey(), "manager")
+	memberAddr := "alice." + managerAddr[len("manager."):]
+
+	data := &snapshotData{Version: 3, Address: memberAddr, NodeInfo: &NodeInfo{IP: "10.0.86.3"}}
+	s, err := signSnapshot(account, data)
+	require.NoError(t, err)
+
+	got, err := verifySnapshot(s, managerAddr, memberAddr)
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), got.Version)
+	require.Equal(t, "10.0.86.3", got.NodeInfo.IP)
+
+	_, err = verifySnapshot(s, managerAddr, "bob."+memberAddr)
+	require.Equal(t, errSnapshotAddress, err)
+
+	s.Data[len(s.Data)-2] ^= 1
+	_, err = verifySnapshot(s, managerAddr, memberAddr)
+	require.Equal(t, errSnapshotSignature, err)
+
+	other, err := nkn.NewAccount(nil)
+	require.NoError(t, err)
+	s, err = signSnapshot(other, data)
+	require.NoError(t, err)
+	_, err = verifySnapshot(s, managerAddr, memberAddr)
+	require.Equal(t, errSnapshotSignature, err)
+}
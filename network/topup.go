@@ -0,0 +1,165 @@
+package network
+
+import (
+	"log"
+	"time"
+
+	"github.com/nknorg/nconnect/event"
+	"github.com/nknorg/nkn/v2/common"
+)
+
+const maxTopUpBackoff = 24 * time.Hour
+
+// topUpState is the backoff of top up of a member after failures.
+type topUpState struct {
+	failures int
+	next     time.Time // don't top up again before
+}
+
+// topUpEvent is the data of TopUp and TopUpFailed events.
+type topUpEvent struct {
+	Address string `json:"address"`
+	Amount  string `json:"amount"`
+	Total   string `json:"total"` // total NKN sent to member by top up
+	Error   string `json:"error,omitempty"`
+}
+
+// StartTopUp sends NKN from manager wallet to server members whose balance is lower than
+// TopUpBalance, balance is checked every BalanceCheckInterval. NKN is only sent to the wallet
+// of a member's own NKN address, up to TopUpLimit in total for each member.
+func (m *Manager) StartTopUp() {
+	interval := time.Duration(m.opts.BalanceCheckInterval) * time.Second
+	if interval <= 0 || len(m.opts.TopUpBalance) == 0 {
+		return
+	}
+	threshold, err := common.StringToFixed64(m.opts.TopUpBalance)
+	if err != nil {
+		log.Println("Parse top up balance error:", err)
+		return
+	}
+	amount, err := common.StringToFixed64(m.opts.TopUpAmount)
+	if err != nil {
+		log.Println("Parse top up amount error:", err)
+		return
+	}
+	limit, err := common.StringToFixed64(m.opts.TopUpLimit)
+	if err != nil {
+		log.Println("Parse top up limit error:", err)
+		return
+	}
+
+	m.topUps = make(map[string]*topUpState)
+	for {
+		time.Sleep(interval)
+		m.topUp(threshold, amount, limit, interval)
+	}
+}
+
+func (m *Manager) topUp(threshold, amount, limit common.Fixed64, interval time.Duration) {
+	var servers []string
+	m.RLock()
+	for address, node := range m.networkData.Member {
+		if node.Server {
+			servers = append(servers, address)
+		}
+	}
+	m.RUnlock()
+
+	now := time.Now()
+	for _, address := range servers {
+		state := m.topUps[address]
+		if state != nil && now.Before(state.next) {
+			continue
+		}
+
+		// Server address is reported by member itself, so the wallet of member address, which
+		// is the same account as its server, is checked and paid instead.
+		balance := getBalance(address)
+		if len(balance) == 0 {
+			continue
+		}
+		b, err := common.StringToFixed64(balance)
+		if err != nil || b >= threshold {
+			continue
+		}
+
+		total := m.topUpTotal(address)
+		if total+amount > limit {
+			if state == nil {
+				log.Printf("Balance %v of server %v is low, but it has been topped up %v NKN, reaching limit %v", balance, address, total, m.opts.TopUpLimit)
+				event.Emit(event.TopUpFailed, &topUpEvent{Address: address, Amount: amount.String(), Total: total.String(), Error: "top up limit reached"})
+			}
+			m.backoffTopUp(address, now, maxTopUpBackoff)
+			continue
+		}
+
+		log.Printf("Balance %v of server %v is lower than %v, top up %v NKN", balance, address, m.opts.TopUpBalance, amount)
+		err = m.SendToken(address, amount.String())
+		if err != nil {
+			state = m.backoffTopUp(address, now, topUpBackoff(interval, m.topUpFailures(address)))
+			log.Printf("Top up server %v failed %v times, retry after %v", address, state.failures, state.next.Sub(now))
+			event.Emit(event.TopUpFailed, &topUpEvent{Address: address, Amount: amount.String(), Total: total.String(), Error: err.Error()})
+			continue
+		}
+		delete(m.topUps, address)
+
+		total += amount
+		m.Lock()
+		if m.networkData.TopUp == nil {
+			m.networkData.TopUp = make(map[string]string)
+		}
+		m.networkData.TopUp[address] = total.String()
+		err = m.saveNetworkData()
+		m.Unlock()
+		if err != nil {
+			log.Println("Save top up total error:", err)
+		}
+		event.Emit(event.TopUp, &topUpEvent{Address: address, Amount: amount.String(), Total: total.String()})
+	}
+}
+
+// topUpTotal returns the total NKN sent to member by top up.
+func (m *Manager) topUpTotal(address string) common.Fixed64 {
+	m.RLock()
+	defer m.RUnlock()
+	total, err := common.StringToFixed64(m.networkData.TopUp[address])
+	if err != nil {
+		return 0
+	}
+	return total
+}
+
+func (m *Manager) topUpFailures(address string) int {
+	if state, ok := m.topUps[address]; ok {
+		return state.failures
+	}
+	return 0
+}
+
+// topUpBackoff returns interval doubled for each failure, at most maxTopUpBackoff. It doesn't
+// shift interval by failures, which overflows after many failures.
+func topUpBackoff(interval time.Duration, failures int) time.Duration {
+	backoff := interval
+	for i := 0; i < failures && backoff < maxTopUpBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxTopUpBackoff {
+		backoff = maxTopUpBackoff
+	}
+	return backoff
+}
+
+// backoffTopUp skips top up of member for backoff, which is at most maxTopUpBackoff.
+func (m *Manager) backoffTopUp(address string, now time.Time, backoff time.Duration) *topUpState {
+	state, ok := m.topUps[address]
+	if !ok {
+		state = &topUpState{}
+		m.topUps[address] = state
+	}
+	state.failures++
+	if backoff <= 0 || backoff > maxTopUpBackoff {
+		backoff = maxTopUpBackoff
+	}
+	state.next = now.Add(backoff)
+	return state
+}
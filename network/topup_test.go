@@ -0,0 +1,16 @@
+package network
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// go test -v -run=TestTopUpBackoff
+func TestTopUpBackoff(t *testing.T) {
+	require.Equal(t, time.Minute, topUpBackoff(time.Minute, 0))
+	require.Equal(t, 8*time.Minute, topUpBackoff(time.Minute, 3))
+	require.Equal(t, maxTopUpBackoff, topUpBackoff(time.Minute, 100))
+	require.Equal(t, maxTopUpBackoff, topUpBackoff(48*time.Hour, 1))
+}
@@ -1,14 +1,18 @@
 package network
 
 import (
+	"errors"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"path"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 	"github.com/nknorg/nconnect/admin"
+	"github.com/nknorg/nconnect/audit"
+	"github.com/nknorg/nconnect/event"
 	"github.com/nknorg/nconnect/util"
 )
 
@@ -17,6 +21,8 @@ const (
 	defaultAdminAddr = "127.0.0.1:8000"
 )
 
+var errNotAdmin = errors.New("only local admin can receive events")
+
 type addressData struct {
 	Address string `json:"address"`
 }
@@ -26,6 +32,29 @@ type addresses struct {
 	AcceptAddresses []string `json:"acceptAddresses"`
 }
 
+type assignIPData struct {
+	Address string `json:"address"`
+	IP      string `json:"ip"`
+}
+
+type routesData struct {
+	Address string   `json:"address"`
+	Routes  []string `json:"routes"`
+}
+
+type diagnoseData struct {
+	From string `json:"from"` // member running diagnostics
+	To   string `json:"to"`   // member to diagnose the link to
+}
+
+// methods that don't change anything are not audited
+var unauditedMethods = map[string]bool{
+	"getNetworkConfig": true,
+	"nknPing":          true,
+	"diagnose":         true,
+	"getAuditLog":      true,
+}
+
 type sendTokenData struct {
 	Address string `json:"address"`
 	Amount  string `json:"amount"`
@@ -54,7 +83,7 @@ func (m *Manager) StartWebServer() error {
 			return
 		}
 
-		resp := m.handleWebRequest(req)
+		resp := m.handleWebRequest(req, audit.Caller{Addr: c.ClientIP(), Via: audit.ViaWeb})
 		if m.opts.Verbose {
 			log.Printf("Web request %v, response %+v\n", req.Method, resp)
 		}
@@ -62,6 +91,15 @@ func (m *Manager) StartWebServer() error {
 		c.JSON(http.StatusOK, resp)
 	})
 
+	r.GET("/events", func(c *gin.Context) {
+		// events of network are only for admins, remote admins receive them by NKN
+		if !isLoopback(c.Request.RemoteAddr) {
+			c.JSON(http.StatusForbidden, gin.H{"error": errNotAdmin.Error()})
+			return
+		}
+		event.ServeSSE(c.Writer, c.Request)
+	})
+
 	r.StaticFile("/network", path.Join(m.opts.WebRootPath, "network.html"))
 	r.StaticFile("/favicon.ico", path.Join(m.opts.WebRootPath, "favicon.ico"))
 	r.StaticFile("/sw.js", path.Join(m.opts.WebRootPath, "sw.js"))
@@ -75,9 +113,23 @@ func (m *Manager) StartWebServer() error {
 	return r.Run(m.opts.AdminHTTPAddr)
 }
 
-func (m *Manager) handleWebRequest(req *admin.RpcReq) *admin.RpcResp {
+// isLoopback returns whether remote address of a request is loopback. Headers like
+// X-Forwarded-For are not trusted.
+func isLoopback(remoteAddr string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// handleWebRequest handles requests from web GUI, manager command line through control socket,
+// and admin addresses through NKN. Node address params can also be node names.
+func (m *Manager) handleWebRequest(req *admin.RpcReq, caller audit.Caller) *admin.RpcResp {
 	resp := &admin.RpcResp{}
 	var err error
+	known := true
 
 	switch req.Method {
 	case "getNetworkConfig":
@@ -96,8 +148,9 @@ func (m *Manager) handleWebRequest(req *admin.RpcReq) *admin.RpcResp {
 		if err = util.JSONConvert(req.Params, params); err != nil {
 			break
 		}
-		m.AuthorizeMemeber(params.Address)
-
+		if err = m.AuthorizeMemeber(m.ResolveAddress(params.Address)); err != nil {
+			break
+		}
 		resp.Result = success
 
 	case "removeMember":
@@ -105,8 +158,9 @@ func (m *Manager) handleWebRequest(req *admin.RpcReq) *admin.RpcResp {
 		if err = util.JSONConvert(req.Params, params); err != nil {
 			break
 		}
-		m.RemoveMember(params.Address)
-
+		if err = m.RemoveMember(m.ResolveAddress(params.Address)); err != nil {
+			break
+		}
 		resp.Result = success
 
 	case "deleteWaiting":
@@ -114,8 +168,9 @@ func (m *Manager) handleWebRequest(req *admin.RpcReq) *admin.RpcResp {
 		if err = util.JSONConvert(req.Params, params); err != nil {
 			break
 		}
-		m.RemoveMember(params.Address)
-
+		if err = m.DeleteWaiting(m.ResolveAddress(params.Address)); err != nil {
+			break
+		}
 		resp.Result = success
 
 	case "setAcceptAddress":
@@ -124,7 +179,32 @@ func (m *Manager) handleWebRequest(req *admin.RpcReq) *admin.RpcResp {
 			break
 		}
 
-		m.SetAcceptAddress(params.Address, params.AcceptAddresses)
+		for i, addr := range params.AcceptAddresses {
+			params.AcceptAddresses[i] = m.ResolveAddress(addr)
+		}
+		if err = m.SetAcceptAddress(m.ResolveAddress(params.Address), params.AcceptAddresses); err != nil {
+			break
+		}
+		resp.Result = success
+
+	case "assignIp":
+		params := &assignIPData{}
+		if err = util.JSONConvert(req.Params, params); err != nil {
+			break
+		}
+		if err = m.AssignIP(m.ResolveAddress(params.Address), params.IP); err != nil {
+			break
+		}
+		resp.Result = success
+
+	case "approveRoutes":
+		params := &routesData{}
+		if err = util.JSONConvert(req.Params, params); err != nil {
+			break
+		}
+		if err = m.ApproveRoutes(m.ResolveAddress(params.Address), params.Routes); err != nil {
+			break
+		}
 		resp.Result = success
 
 	case "sendToken":
@@ -132,24 +212,38 @@ func (m *Manager) handleWebRequest(req *admin.RpcReq) *admin.RpcResp {
 		if err = util.JSONConvert(req.Params, params); err != nil {
 			break
 		}
-		if err = m.SendToken(params.Address, params.Amount); err != nil {
+		if err = m.SendToken(m.ResolveAddress(params.Address), params.Amount); err != nil {
 			break
 		}
 		resp.Result = success
 
 	case "nknPing":
-		fmt.Println("got network webservice nknPing")
 		params := &addressData{}
 		if err = util.JSONConvert(req.Params, params); err != nil {
 			break
 		}
-		ms, err := m.NknPing(params.Address)
-		if err != nil {
+		var ms int
+		if ms, err = m.NknPing(m.ResolveAddress(params.Address)); err != nil {
 			break
 		}
 		resp.Result = fmt.Sprintf("%s, RTT time = %v ms", success, ms)
 
+	case "diagnose":
+		params := &diagnoseData{}
+		if err = util.JSONConvert(req.Params, params); err != nil {
+			break
+		}
+		resp.Result, err = m.Diagnose(m.ResolveAddress(params.From), m.ResolveAddress(params.To))
+
+	case "getAuditLog":
+		params := &audit.Filter{}
+		if err = util.JSONConvert(req.Params, params); err != nil {
+			break
+		}
+		resp.Result, err = audit.Query(params)
+
 	default:
+		known = false
 		resp.Error = "nConnect manager webservice got unknown method"
 	}
 
@@ -157,5 +251,11 @@ func (m *Manager) handleWebRequest(req *admin.RpcReq) *admin.RpcResp {
 		resp.Error = err.Error()
 	}
 
+	if known && !unauditedMethods[req.Method] {
+		if err = audit.Add(caller, req.Method, req.Params, resp.Error); err != nil {
+			log.Println("Add audit record error:", err)
+		}
+	}
+
 	return resp
 }
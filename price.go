@@ -0,0 +1,47 @@
+package nconnect
+
+import (
+	"log"
+	"time"
+
+	"github.com/nknorg/nconnect/util"
+	ts "github.com/nknorg/nkn-tuna-session"
+)
+
+// refreshTunaPrice gets tuna max price from url periodically, and applies it to tuna session
+// client after bounding it by price floor, ceil and max change. The new price takes effect when
+// tuna exits are created or rotated.
+func (nc *nconnect) refreshTunaPrice() {
+	interval := time.Duration(nc.opts.TunaPriceRefreshInterval) * time.Second
+	if interval <= 0 {
+		return
+	}
+
+	for !nc.serverTunnel.IsClosed() {
+		time.Sleep(interval)
+
+		price, err := util.GetRemotePrice(nc.tunaPriceURL)
+		if err != nil {
+			log.Println("Get remote price error:", err)
+			continue
+		}
+		last := nc.opts.GetTunaMaxPrice()
+		price, err = util.ClampPrice(price, last, nc.opts.TunaPriceFloor, nc.opts.TunaPriceCeil, nc.opts.TunaPriceMaxChange)
+		if err != nil {
+			log.Println("Clamp remote price error:", err)
+			continue
+		}
+		if price == last {
+			continue
+		}
+
+		if c := nc.serverTunnel.TunaSessionClient(); c != nil {
+			if err = c.SetConfig(&ts.Config{TunaMaxPrice: price}); err != nil {
+				log.Println("Set tuna max price error:", err)
+				continue
+			}
+		}
+		nc.opts.SetTunaMaxPrice(price)
+		log.Printf("Update dynamic price from %s to %s", last, price)
+	}
+}
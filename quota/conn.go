@@ -0,0 +1,68 @@
+package quota
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Conn accounts traffic of a client connection to a store, and enforces the client limit on it.
+// Traffic is counted by client state without store lock, and added to usage by FlushEvery.
+type Conn struct {
+	net.Conn
+	tuna    bool         // whether conn is a tuna session
+	st      *clientState // resolved once, checked without store lock
+	limiter *rate.Limiter
+}
+
+// NewConn wraps conn of client, tuna is whether conn is a tuna session.
+func NewConn(conn net.Conn, s *Store, client string, tuna bool) *Conn {
+	s.Lock()
+	st := s.state(client, time.Now())
+	s.Unlock()
+	return &Conn{Conn: conn, tuna: tuna, st: st, limiter: st.limiter}
+}
+
+func (c *Conn) Read(b []byte) (int, error) {
+	if c.st.exceeded.Load() {
+		return 0, ErrQuotaExceeded
+	}
+	n, err := c.Conn.Read(b)
+	c.st.count(n, 0, c.tuna)
+	if werr := c.wait(n); werr != nil && err == nil {
+		err = werr
+	}
+	return n, err
+}
+
+func (c *Conn) Write(b []byte) (int, error) {
+	if c.st.exceeded.Load() {
+		return 0, ErrQuotaExceeded
+	}
+	if err := c.wait(len(b)); err != nil {
+		return 0, err
+	}
+	n, err := c.Conn.Write(b)
+	c.st.count(0, n, c.tuna)
+	return n, err
+}
+
+// wait blocks until the rate limiter allows n bytes.
+func (c *Conn) wait(n int) error {
+	if c.limiter == nil {
+		return nil
+	}
+	for n > 0 {
+		m := n
+		if burst := c.limiter.Burst(); m > burst {
+			m = burst
+		}
+		if err := c.limiter.WaitN(context.Background(), m); err != nil {
+			return err
+		}
+		n -= m
+	}
+	return nil
+}
@@ -0,0 +1,433 @@
+package quota
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nknorg/nconnect/config"
+	"golang.org/x/time/rate"
+)
+
+const (
+	dateFormat   = "2006-01-02"
+	monthFormat  = "2006-01"
+	keepDays     = 62 // days of usage kept, enough for this and last month
+	minRateBurst = 64 * 1024
+)
+
+var (
+	ErrQuotaExceeded = errors.New("client traffic quota exceeded")
+	ErrNoUsage       = errors.New("no usage of client")
+)
+
+// ClientUsage is the traffic of a client in this day and month, with its limit.
+type ClientUsage struct {
+	Client       string `json:"client"`
+	Today        uint64 `json:"today"` // bytes of both directions
+	Month        uint64 `json:"month"`
+	RateLimit    int64  `json:"rateLimit,omitempty"` // KB/s
+	DailyQuota   int64  `json:"dailyQuota,omitempty"`
+	MonthlyQuota int64  `json:"monthlyQuota,omitempty"`
+	Exceeded     bool   `json:"exceeded"`
+}
+
+type clientUsage struct {
+	Days map[string]uint64     `json:"days"`           // bytes by date
+	Tuna map[string]*TunaUsage `json:"tuna,omitempty"` // traffic through tuna by date
+}
+
+type nodeUsage struct {
+	Days map[string]*TunaUsage `json:"days"`
+}
+
+// usageFile is the persisted format of usage.
+type usageFile struct {
+	Clients map[string]*clientUsage `json:"clients"`
+	Nodes   map[string]*nodeUsage   `json:"nodes,omitempty"` // traffic by tuna node
+}
+
+// Store accounts traffic by client and tuna node, enforces client limits, and persists usage to a file.
+type Store struct {
+	sync.Mutex
+	path      string
+	limits    []config.ClientLimit
+	clients   map[string]*clientUsage
+	nodes     map[string]*nodeUsage
+	tunaNodes []TunaNode              // tuna nodes currently in use
+	states    map[string]*clientState // limit and traffic counters of clients seen since start
+	dirty     bool
+}
+
+// clientState is the limit of a client resolved once, with its traffic of today and this month
+// counted as it's added, so connections check the quota without taking the store lock.
+type clientState struct {
+	limit    *config.ClientLimit // nil if unlimited
+	limiter  *rate.Limiter       // shared by all connections of client, nil if no rate limit
+	date     string              // date today and month are counted for, changed with store lock
+	today    atomic.Uint64
+	month    atomic.Uint64
+	exceeded atomic.Bool
+
+	// traffic counted by connections and packets, not added to usage yet
+	bytes           atomic.Uint64 // traffic not through tuna, both directions
+	tunaIn, tunaOut atomic.Uint64
+}
+
+// count counts traffic of client without store lock, it's added to usage by flush. The quota
+// is checked with the traffic counted, so it's enforced before flush.
+func (st *clientState) count(in, out int, tuna bool) {
+	if in < 0 {
+		in = 0
+	}
+	if out < 0 {
+		out = 0
+	}
+	if in+out == 0 {
+		return
+	}
+	if tuna {
+		st.tunaIn.Add(uint64(in))
+		st.tunaOut.Add(uint64(out))
+	} else {
+		st.bytes.Add(uint64(in + out))
+	}
+	if st.limit != nil {
+		pending := st.bytes.Load() + st.tunaIn.Load() + st.tunaOut.Load()
+		if exceeded(st.limit, st.today.Load()+pending, st.month.Load()+pending) {
+			st.exceeded.Store(true)
+		}
+	}
+}
+
+// NewStore loads usage from path if it exists.
+func NewStore(path string, limits []config.ClientLimit) (*Store, error) {
+	s := &Store{
+		path:    path,
+		limits:  limits,
+		clients: make(map[string]*clientUsage),
+		nodes:   make(map[string]*nodeUsage),
+		states:  make(map[string]*clientState),
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return s, nil
+		}
+		return nil, err
+	}
+	if len(b) > 0 {
+		f := &usageFile{}
+		if err = json.Unmarshal(b, f); err != nil {
+			return nil, err
+		}
+		if f.Clients != nil {
+			s.clients = f.Clients
+		}
+		if f.Nodes != nil {
+			s.nodes = f.Nodes
+		}
+	}
+
+	return s, nil
+}
+
+// state returns the state of client with counters of now. It should be called with lock.
+func (s *Store) state(client string, now time.Time) *clientState {
+	st, ok := s.states[client]
+	if !ok {
+		st = &clientState{limit: s.limit(client)}
+		if st.limit != nil && st.limit.RateLimit > 0 {
+			bytesPerSecond := int(st.limit.RateLimit) << 10
+			burst := bytesPerSecond
+			if burst < minRateBurst {
+				burst = minRateBurst
+			}
+			st.limiter = rate.NewLimiter(rate.Limit(bytesPerSecond), burst)
+		}
+		s.states[client] = st
+	}
+	if date := now.Format(dateFormat); st.date != date {
+		st.date = date
+		today, month := s.usage(client, now)
+		st.today.Store(today)
+		st.month.Store(month)
+		st.exceeded.Store(exceeded(st.limit, today, month))
+	}
+	return st
+}
+
+// limit returns the limit of client matched by address patterns, nil if client is unlimited.
+func (s *Store) limit(client string) *config.ClientLimit {
+	var limit *config.ClientLimit
+	for i := range s.limits {
+		matched, err := regexp.MatchString(s.limits[i].Addr, client)
+		if err != nil {
+			log.Println("Client limit regexp match error:", err)
+			continue
+		}
+		if matched {
+			limit = &s.limits[i]
+			break
+		}
+	}
+
+	return limit
+}
+
+// usage returns traffic of client today and this month. It should be called with lock.
+func (s *Store) usage(client string, now time.Time) (today, month uint64) {
+	u, ok := s.clients[client]
+	if !ok {
+		return 0, 0
+	}
+	date, prefix := now.Format(dateFormat), now.Format(monthFormat)
+	for d, n := range u.Days {
+		if d == date {
+			today = n
+		}
+		if strings.HasPrefix(d, prefix) {
+			month += n
+		}
+	}
+	return today, month
+}
+
+func exceeded(limit *config.ClientLimit, today, month uint64) bool {
+	if limit == nil {
+		return false
+	}
+	if limit.DailyQuota > 0 && today >= uint64(limit.DailyQuota)<<20 {
+		return true
+	}
+	if limit.MonthlyQuota > 0 && month >= uint64(limit.MonthlyQuota)<<20 {
+		return true
+	}
+	return false
+}
+
+// Allow returns ErrQuotaExceeded if client has used up its daily or monthly quota.
+func (s *Store) Allow(client string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.state(client, time.Now()).exceeded.Load() {
+		return ErrQuotaExceeded
+	}
+	return nil
+}
+
+// Add accounts n bytes of traffic to client.
+func (s *Store) Add(client string, n int) {
+	s.add(client, n, 0, false)
+}
+
+// AddTuna accounts traffic of client through tuna, in is bytes from client and out is bytes to
+// client. The traffic is shared equally by tuna nodes in use to estimate their cost.
+func (s *Store) AddTuna(client string, in, out int) {
+	s.add(client, in, out, true)
+}
+
+func (s *Store) add(client string, in, out int, tuna bool) {
+	s.Lock()
+	defer s.Unlock()
+	s.addLocked(client, in, out, tuna, time.Now())
+}
+
+// addLocked accounts traffic of client, it should be called with lock.
+func (s *Store) addLocked(client string, in, out int, tuna bool, now time.Time) {
+	if in < 0 {
+		in = 0
+	}
+	if out < 0 {
+		out = 0
+	}
+	if in+out == 0 {
+		return
+	}
+
+	date := now.Format(dateFormat)
+	u, ok := s.clients[client]
+	if !ok {
+		u = &clientUsage{Days: make(map[string]uint64)}
+		s.clients[client] = u
+	}
+	if _, ok := u.Days[date]; !ok {
+		oldest := now.AddDate(0, 0, -keepDays).Format(dateFormat)
+		for d := range u.Days {
+			if d < oldest {
+				delete(u.Days, d)
+			}
+		}
+		for d := range u.Tuna {
+			if d < oldest {
+				delete(u.Tuna, d)
+			}
+		}
+	}
+	u.Days[date] += uint64(in + out)
+	s.dirty = true
+
+	st := s.state(client, now)
+	today := st.today.Add(uint64(in + out))
+	month := st.month.Add(uint64(in + out))
+	pending := st.bytes.Load() + st.tunaIn.Load() + st.tunaOut.Load()
+	st.exceeded.Store(exceeded(st.limit, today+pending, month+pending))
+
+	if !tuna {
+		return
+	}
+	if u.Tuna == nil {
+		u.Tuna = make(map[string]*TunaUsage)
+	}
+	tu, ok := u.Tuna[date]
+	if !ok {
+		tu = &TunaUsage{}
+		u.Tuna[date] = tu
+	}
+	tu.add(uint64(in), uint64(out), 0)
+	n := len(s.tunaNodes)
+	for _, node := range s.tunaNodes {
+		cost := node.cost(in, out, n)
+		tu.Cost += cost
+		s.nodeDay(node.Addr, date, now).add(uint64(in/n), uint64(out/n), cost)
+	}
+}
+
+// Limiter returns the rate limiter shared by all connections of client, nil if client has no rate limit.
+func (s *Store) Limiter(client string) *rate.Limiter {
+	s.Lock()
+	defer s.Unlock()
+	return s.state(client, time.Now()).limiter
+}
+
+// AllowPacket accounts a tuna udp packet of n bytes from or to client, and returns false if the
+// packet should be dropped because of client limit.
+func (s *Store) AllowPacket(client string, n int, fromClient bool) bool {
+	s.Lock()
+	st := s.state(client, time.Now())
+	s.Unlock()
+	if st.exceeded.Load() {
+		return false
+	}
+	if st.limiter != nil && !st.limiter.AllowN(time.Now(), n) {
+		return false
+	}
+	if fromClient {
+		st.count(n, 0, true)
+	} else {
+		st.count(0, n, true)
+	}
+	return true
+}
+
+// flush adds traffic counted by connections and packets to usage, and updates quota state of
+// clients at date change. It should be called with lock.
+func (s *Store) flush() {
+	now := time.Now()
+	for client, st := range s.states {
+		s.state(client, now)
+		s.addLocked(client, int(st.bytes.Swap(0)), 0, false, now)
+		s.addLocked(client, int(st.tunaIn.Swap(0)), int(st.tunaOut.Swap(0)), true, now)
+	}
+}
+
+// FlushEvery adds traffic counted by connections to usage every interval, it never returns.
+func (s *Store) FlushEvery(interval time.Duration) {
+	for {
+		time.Sleep(interval)
+		s.Lock()
+		s.flush()
+		s.Unlock()
+	}
+}
+
+// Usage returns usage of client, or all clients if client is empty.
+func (s *Store) Usage(client string) []*ClientUsage {
+	s.Lock()
+	defer s.Unlock()
+	s.flush()
+
+	now := time.Now()
+	res := make([]*ClientUsage, 0)
+	for c := range s.clients {
+		if client != "" && c != client {
+			continue
+		}
+		u := &ClientUsage{Client: c}
+		u.Today, u.Month = s.usage(c, now)
+		if limit := s.state(c, now).limit; limit != nil {
+			u.RateLimit = limit.RateLimit
+			u.DailyQuota = limit.DailyQuota
+			u.MonthlyQuota = limit.MonthlyQuota
+			u.Exceeded = exceeded(limit, u.Today, u.Month)
+		}
+		res = append(res, u)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Client < res[j].Client })
+
+	return res
+}
+
+// Reset clears usage of client, or all clients if client is empty, and saves it.
+func (s *Store) Reset(client string) error {
+	s.Lock()
+	s.flush()
+	if client == "" {
+		s.clients = make(map[string]*clientUsage)
+		for _, st := range s.states {
+			st.date = "" // recount
+		}
+	} else if _, ok := s.clients[client]; ok {
+		delete(s.clients, client)
+		if st, ok := s.states[client]; ok {
+			st.date = ""
+		}
+	} else {
+		s.Unlock()
+		return ErrNoUsage
+	}
+	s.dirty = true
+	s.Unlock()
+
+	return s.Save()
+}
+
+// Save writes usage to file if it's changed.
+func (s *Store) Save() error {
+	s.Lock()
+	defer s.Unlock()
+	s.flush()
+
+	if !s.dirty {
+		return nil
+	}
+	b, err := json.MarshalIndent(&usageFile{Clients: s.clients, Nodes: s.nodes}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = os.WriteFile(s.path, b, 0600); err != nil {
+		return err
+	}
+	s.dirty = false
+
+	return nil
+}
+
+// SaveEvery saves usage every interval, it never returns.
+func (s *Store) SaveEvery(interval time.Duration) {
+	for {
+		time.Sleep(interval)
+		if err := s.Save(); err != nil {
+			log.Println("Save client usage error:", err)
+		}
+	}
+}
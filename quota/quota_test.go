@@ -0,0 +1,119 @@
+package quota
+
+import (
+	"io"
+	"net"
+	"path/filepath"
+	"testing"
+
+	"github.com/nknorg/nconnect/config"
+	"github.com/stretchr/testify/require"
+)
+
+// go test -v -run=TestStore
+func TestStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "usage.json")
+	limits := []config.ClientLimit{{Addr: "^limited", DailyQuota: 1}}
+	s, err := NewStore(path, limits)
+	require.NoError(t, err)
+
+	require.NoError(t, s.Allow("limited.client"))
+	s.Add("limited.client", 1<<20)
+	s.Add("free.client", 1<<20)
+	require.Equal(t, ErrQuotaExceeded, s.Allow("limited.client"))
+	require.NoError(t, s.Allow("free.client"))
+	require.False(t, s.AllowPacket("limited.client", 100, true))
+	require.True(t, s.AllowPacket("free.client", 100, true))
+
+	usage := s.Usage("")
+	require.Len(t, usage, 2)
+	require.Equal(t, "free.client", usage[0].Client)
+	require.Equal(t, uint64(1<<20+100), usage[0].Today)
+	require.True(t, usage[1].Exceeded)
+
+	require.NoError(t, s.Save())
+	s, err = NewStore(path, limits)
+	require.NoError(t, err)
+	require.Equal(t, ErrQuotaExceeded, s.Allow("limited.client"))
+
+	require.NoError(t, s.Reset("limited.client"))
+	require.NoError(t, s.Allow("limited.client"))
+	require.Equal(t, ErrNoUsage, s.Reset("limited.client"))
+}
+
+// go test -v -run=TestConn
+func TestConn(t *testing.T) {
+	s, err := NewStore(filepath.Join(t.TempDir(), "usage.json"), []config.ClientLimit{{Addr: ".*", RateLimit: 1024}})
+	require.NoError(t, err)
+
+	left, right := net.Pipe()
+	defer right.Close()
+	c := NewConn(left, s, "client", false)
+	go func() {
+		right.Write([]byte("hello"))
+		io.ReadFull(right, make([]byte, 3))
+	}()
+	_, err = io.ReadFull(c, make([]byte, 5))
+	require.NoError(t, err)
+	_, err = c.Write([]byte("bye"))
+	require.NoError(t, err)
+	require.Equal(t, uint64(8), s.Usage("client")[0].Today)
+}
+
+// go test -v -run=TestConnQuota
+func TestConnQuota(t *testing.T) {
+	s, err := NewStore(filepath.Join(t.TempDir(), "usage.json"), []config.ClientLimit{{Addr: ".*", DailyQuota: 1}})
+	require.NoError(t, err)
+
+	left, right := net.Pipe()
+	defer right.Close()
+	go io.Copy(io.Discard, right)
+	c := NewConn(left, s, "client", false)
+
+	// traffic counted by the connection is enforced before it's added to usage
+	_, err = c.Write(make([]byte, 1<<20))
+	require.NoError(t, err)
+	_, err = c.Write([]byte("bye"))
+	require.Equal(t, ErrQuotaExceeded, err)
+	require.Equal(t, ErrQuotaExceeded, s.Allow("client"))
+	require.Equal(t, uint64(1<<20), s.Usage("client")[0].Today)
+}
+
+// go test -v -run=TestConnExceeded
+func TestConnExceeded(t *testing.T) {
+	s, err := NewStore(filepath.Join(t.TempDir(), "usage.json"), []config.ClientLimit{{Addr: ".*", DailyQuota: 1}})
+	require.NoError(t, err)
+
+	left, right := net.Pipe()
+	defer right.Close()
+	c := NewConn(left, s, "client", false)
+
+	// traffic of other connections of client counts to the quota of c
+	s.Add("client", 1<<20)
+	_, err = c.Write([]byte("bye"))
+	require.Equal(t, ErrQuotaExceeded, err)
+	_, err = c.Read(make([]byte, 1))
+	require.Equal(t, ErrQuotaExceeded, err)
+}
+
+// go test -v -run=TestTunaUsage
+func TestTunaUsage(t *testing.T) {
+	s, err := NewStore(filepath.Join(t.TempDir(), "usage.json"), nil)
+	require.NoError(t, err)
+
+	s.SetTunaNodes([]TunaNode{{Addr: "1.1.1.1:1", InPrice: 0.01, OutPrice: 0.02}, {Addr: "2.2.2.2:2", InPrice: 0.01, OutPrice: 0.02}})
+	s.AddTuna("client", 2<<20, 4<<20)
+	s.Add("client", 100)
+
+	r := s.Report(7)
+	require.Len(t, r.Days, 1)
+	require.Equal(t, uint64(6<<20+100), r.Days[0].Bytes)
+	require.Equal(t, uint64(2<<20), r.Days[0].In)
+	require.InDelta(t, 0.1, r.Days[0].Cost, 1e-9)
+	require.Len(t, r.Clients, 1)
+	require.Len(t, r.Nodes, 2)
+	require.True(t, r.Nodes[0].InUse)
+	require.Equal(t, uint64(2<<20), r.Nodes[0].Days[0].Out)
+	require.InDelta(t, 0.05, r.Nodes[1].Days[0].Cost, 1e-9)
+	require.GreaterOrEqual(t, r.AvgDaily.Cost, 0.1)
+}
@@ -0,0 +1,223 @@
+package quota
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/nknorg/tuna"
+)
+
+// TunaUsage is the traffic through tuna and its estimated cost.
+type TunaUsage struct {
+	In   uint64  `json:"in"`   // bytes from client to server
+	Out  uint64  `json:"out"`  // bytes from server to client
+	Cost float64 `json:"cost"` // NKN
+}
+
+func (u *TunaUsage) add(in, out uint64, cost float64) {
+	u.In += in
+	u.Out += out
+	u.Cost += cost
+}
+
+// TunaNode is a tuna service node in use with its price.
+type TunaNode struct {
+	Addr     string  `json:"addr"`     // public address of the node
+	InPrice  float64 `json:"inPrice"`  // NKN/MB of traffic from client to server
+	OutPrice float64 `json:"outPrice"` // NKN/MB of traffic from server to client
+}
+
+// cost returns cost of the node's share of traffic, when traffic is shared by n nodes.
+func (node *TunaNode) cost(in, out, n int) float64 {
+	return (node.InPrice*float64(in) + node.OutPrice*float64(out)) / tuna.TrafficUnit / float64(n)
+}
+
+// SetTunaNodes sets tuna nodes in use, tuna traffic added later is accounted to them.
+func (s *Store) SetTunaNodes(nodes []TunaNode) {
+	s.Lock()
+	s.tunaNodes = nodes
+	s.Unlock()
+}
+
+// TunaNodes returns tuna nodes in use.
+func (s *Store) TunaNodes() []TunaNode {
+	s.Lock()
+	defer s.Unlock()
+	return s.tunaNodes
+}
+
+// nodeDay returns usage of node at date, and prunes old usage of node if it's a new day. It
+// should be called with lock.
+func (s *Store) nodeDay(node, date string, now time.Time) *TunaUsage {
+	u, ok := s.nodes[node]
+	if !ok {
+		u = &nodeUsage{Days: make(map[string]*TunaUsage)}
+		s.nodes[node] = u
+	}
+	tu, ok := u.Days[date]
+	if !ok {
+		oldest := now.AddDate(0, 0, -keepDays).Format(dateFormat)
+		for d := range u.Days {
+			if d < oldest {
+				delete(u.Days, d)
+			}
+		}
+		tu = &TunaUsage{}
+		u.Days[date] = tu
+	}
+	return tu
+}
+
+// DailyUsage is the traffic in a day.
+type DailyUsage struct {
+	Date  string `json:"date"`
+	Bytes uint64 `json:"bytes,omitempty"` // all traffic of clients, including traffic not through tuna
+	TunaUsage
+}
+
+// ClientReport is the daily traffic of a client.
+type ClientReport struct {
+	Client string        `json:"client"`
+	Days   []*DailyUsage `json:"days"`
+}
+
+// NodeReport is the daily traffic through a tuna node.
+type NodeReport struct {
+	Node  string        `json:"node"`
+	InUse bool          `json:"inUse"`
+	Days  []*DailyUsage `json:"days"`
+}
+
+// Report is the traffic of recent days, total and by client and tuna node.
+type Report struct {
+	Days     []*DailyUsage   `json:"days"`
+	Clients  []*ClientReport `json:"clients"`
+	Nodes    []*NodeReport   `json:"nodes"`
+	AvgDaily TunaUsage       `json:"avgDaily"` // average daily tuna traffic and cost of the days
+}
+
+// Report returns traffic of recent days, including today. Days without traffic are omitted.
+func (s *Store) Report(days int) *Report {
+	if days <= 0 {
+		days = 1
+	}
+	if days > keepDays {
+		days = keepDays
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	s.flush()
+
+	now := time.Now()
+	oldest := now.AddDate(0, 0, 1-days).Format(dateFormat)
+	total := make(map[string]*DailyUsage)
+	totalDay := func(date string) *DailyUsage {
+		d, ok := total[date]
+		if !ok {
+			d = &DailyUsage{Date: date}
+			total[date] = d
+		}
+		return d
+	}
+
+	r := &Report{
+		Days:    make([]*DailyUsage, 0),
+		Clients: make([]*ClientReport, 0, len(s.clients)),
+		Nodes:   make([]*NodeReport, 0, len(s.nodes)),
+	}
+
+	for client, u := range s.clients {
+		cr := &ClientReport{Client: client, Days: make([]*DailyUsage, 0)}
+		for date, n := range u.Days {
+			if date < oldest {
+				continue
+			}
+			d := &DailyUsage{Date: date, Bytes: n}
+			if tu, ok := u.Tuna[date]; ok {
+				d.TunaUsage = *tu
+			}
+			cr.Days = append(cr.Days, d)
+
+			td := totalDay(date)
+			td.Bytes += d.Bytes
+			td.add(d.In, d.Out, d.Cost)
+		}
+		if len(cr.Days) > 0 {
+			sortDays(cr.Days)
+			r.Clients = append(r.Clients, cr)
+		}
+	}
+	sort.Slice(r.Clients, func(i, j int) bool { return r.Clients[i].Client < r.Clients[j].Client })
+
+	inUse := make(map[string]bool, len(s.tunaNodes))
+	for _, node := range s.tunaNodes {
+		inUse[node.Addr] = true
+	}
+	for node, u := range s.nodes {
+		nr := &NodeReport{Node: node, InUse: inUse[node], Days: make([]*DailyUsage, 0)}
+		for date, tu := range u.Days {
+			if date >= oldest {
+				nr.Days = append(nr.Days, &DailyUsage{Date: date, TunaUsage: *tu})
+			}
+		}
+		if len(nr.Days) > 0 || nr.InUse {
+			sortDays(nr.Days)
+			r.Nodes = append(r.Nodes, nr)
+		}
+	}
+	sort.Slice(r.Nodes, func(i, j int) bool { return r.Nodes[i].Node < r.Nodes[j].Node })
+
+	for _, d := range total {
+		r.Days = append(r.Days, d)
+	}
+	sortDays(r.Days)
+	r.AvgDaily = average(r.Days, now)
+
+	return r
+}
+
+// average returns average daily tuna usage from the first day to yesterday, days without traffic
+// are counted as zero. Today's usage is scaled to a whole day if there is no usage before today.
+func average(days []*DailyUsage, now time.Time) TunaUsage {
+	var sum TunaUsage
+	if len(days) == 0 {
+		return sum
+	}
+
+	today := now.Format(dateFormat)
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	var n float64
+	if days[0].Date != today {
+		for _, d := range days {
+			if d.Date != today {
+				sum.add(d.In, d.Out, d.Cost)
+			}
+		}
+		first, err := time.ParseInLocation(dateFormat, days[0].Date, now.Location())
+		if err != nil {
+			return TunaUsage{}
+		}
+		n = math.Round(midnight.Sub(first).Hours() / 24)
+	} else {
+		sum = days[0].TunaUsage
+		n = float64(now.Sub(midnight)) / float64(24*time.Hour)
+		if n < 1.0/24 { // avoid extrapolating from too short time
+			n = 1.0 / 24
+		}
+	}
+	if n <= 0 {
+		return TunaUsage{}
+	}
+
+	return TunaUsage{
+		In:   uint64(float64(sum.In) / n),
+		Out:  uint64(float64(sum.Out) / n),
+		Cost: sum.Cost / n,
+	}
+}
+
+func sortDays(days []*DailyUsage) {
+	sort.Slice(days, func(i, j int) bool { return days[i].Date < days[j].Date })
+}
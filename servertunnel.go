@@ -0,0 +1,220 @@
+package nconnect
+
+import (
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nknorg/nconnect/quota"
+	tunnel "github.com/nknorg/nkn-tunnel"
+	"github.com/nknorg/tuna"
+)
+
+const (
+	usageFile               = "usage.json"
+	usageSaveInterval       = time.Minute
+	usageFlushInterval      = time.Second // traffic counted by connections is added to usage at this interval
+	tunaNodesUpdateInterval = 10 * time.Second
+)
+
+// startServerTunnel does the same as serverTunnel.Start, except that traffic of every session
+// is accounted and limited by the NKN address of its client. nkn-tunnel has no hook to wrap
+// accepted connections, so only the accept loop is kept here, dialing and piping stay as is.
+func (nc *nconnect) startServerTunnel() error {
+	t := nc.serverTunnel
+
+	listeners := []net.Listener{t.MultiClient()}
+	tsClient := t.TunaSessionClient()
+	if tsClient != nil {
+		listeners = append(listeners, tsClient)
+	}
+
+	errChan := make(chan error, len(listeners))
+	for _, listener := range listeners {
+		go func(listener net.Listener) {
+			for {
+				fromConn, err := listener.Accept()
+				if err != nil {
+					errChan <- err
+					return
+				}
+				if nc.opts.Verbose {
+					log.Println("Accept from", fromConn.RemoteAddr())
+				}
+				go nc.pipeServerConn(fromConn, t.ToAddr(), listener == tsClient)
+			}
+		}(listener)
+	}
+
+	if nc.tunnelConfig.UDP {
+		if tsClient != nil {
+			fromUDPConn, err := tsClient.ListenUDP()
+			if err != nil {
+				return err
+			}
+			go nc.pipeServerUDP(t, fromUDPConn)
+		} else {
+			return tunnel.ErrUDPNotSupported
+		}
+	}
+
+	err := <-errChan
+	if t.IsClosed() {
+		return nil
+	}
+	t.Close()
+
+	return err
+}
+
+func (nc *nconnect) pipeServerConn(fromConn net.Conn, to string, tuna bool) {
+	// tuna sessions are accepted even if tuna is disabled by low balance, clients started
+	// before keep dialing tuna sessions until they restart
+	client := fromConn.RemoteAddr().String()
+	if err := nc.usage.Allow(client); err != nil {
+		log.Printf("Reject session from %v: %v\n", client, err)
+		fromConn.Close()
+		return
+	}
+
+	toConn, err := net.DialTimeout("tcp", to, time.Duration(nc.opts.DialTimeout)*time.Millisecond)
+	if err != nil {
+		log.Println(err)
+		fromConn.Close()
+		return
+	}
+
+	a, b := quota.NewConn(fromConn, nc.usage, client, tuna), toConn
+	go func() {
+		io.Copy(a, b)
+		a.Close()
+	}()
+	go func() {
+		io.Copy(b, a)
+		b.Close()
+	}()
+}
+
+type udpConn interface {
+	ReadFrom(b []byte) (n int, addr net.Addr, err error)
+	WriteTo(b []byte, addr net.Addr) (n int, err error)
+}
+
+// pipeServerUDP pipes udp packets between tuna udp session and ss server, a udp connection to
+// ss server is dialed for every remote session.
+func (nc *nconnect) pipeServerUDP(t *tunnel.Tunnel, fromUDPConn udpConn) {
+	var lock sync.Mutex
+	toUDPConns := make(map[string]*net.UDPConn)
+	idleTime := time.Duration(nc.opts.UDPIdleTime) * time.Second
+
+	msg := make([]byte, tuna.MaxUDPBufferSize)
+	for !t.IsClosed() {
+		n, fromAddr, err := fromUDPConn.ReadFrom(msg)
+		if err != nil {
+			log.Println("fromUDPConn.ReadFrom err:", err)
+			break
+		}
+
+		// udp session address is client address and session id joined by ':'
+		client := fromAddr.String()
+		if i := strings.LastIndex(client, ":"); i >= 0 {
+			client = client[:i]
+		}
+		if !nc.usage.AllowPacket(client, n, true) {
+			continue
+		}
+
+		lock.Lock()
+		toUDPConn, ok := toUDPConns[fromAddr.String()]
+		if !ok {
+			toUDPConn, err = dialUDP(t.ToAddr())
+			if err != nil {
+				lock.Unlock()
+				log.Println("dial udp err:", err)
+				continue
+			}
+			toUDPConns[fromAddr.String()] = toUDPConn
+		}
+		lock.Unlock()
+
+		if _, err = toUDPConn.Write(msg[:n]); err != nil {
+			log.Println("toUDPConn.Write err:", err)
+			continue
+		}
+
+		if !ok { // new dialed udp connection, start reverse data pipe
+			go func(fromAddr net.Addr, toUDPConn *net.UDPConn) {
+				defer func() {
+					lock.Lock()
+					delete(toUDPConns, fromAddr.String())
+					lock.Unlock()
+					toUDPConn.Close()
+				}()
+
+				msg := make([]byte, tuna.MaxUDPBufferSize)
+				for !t.IsClosed() {
+					if idleTime > 0 {
+						toUDPConn.SetReadDeadline(time.Now().Add(idleTime))
+					}
+					n, _, err := toUDPConn.ReadFrom(msg)
+					if err != nil {
+						if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+							log.Println("toUDPConn.ReadFrom err:", err)
+						}
+						return
+					}
+					if !nc.usage.AllowPacket(client, n, false) {
+						continue
+					}
+					if _, err = fromUDPConn.WriteTo(msg[:n], fromAddr); err != nil {
+						log.Println("fromUDPConn.WriteTo err:", err)
+						return
+					}
+				}
+			}(fromAddr, toUDPConn)
+		}
+	}
+}
+
+func dialUDP(addr string) (*net.UDPConn, error) {
+	a, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return net.DialUDP("udp", nil, a)
+}
+
+// updateTunaNodes keeps tuna nodes in use and their prices up to date for usage accounting.
+func (nc *nconnect) updateTunaNodes() {
+	for !nc.serverTunnel.IsClosed() {
+		if pubAddrs := nc.serverTunnel.TunaPubAddrs(); pubAddrs != nil {
+			nodes := make([]quota.TunaNode, 0, len(pubAddrs.Addrs))
+			for _, addr := range pubAddrs.Addrs {
+				if len(addr.IP) == 0 {
+					continue
+				}
+				inPrice, err := strconv.ParseFloat(addr.InPrice, 64)
+				if err != nil {
+					log.Println("Parse tuna in price error:", err)
+					continue
+				}
+				outPrice, err := strconv.ParseFloat(addr.OutPrice, 64)
+				if err != nil {
+					log.Println("Parse tuna out price error:", err)
+					continue
+				}
+				nodes = append(nodes, quota.TunaNode{
+					Addr:     net.JoinHostPort(addr.IP, strconv.Itoa(int(addr.Port))),
+					InPrice:  inPrice,
+					OutPrice: outPrice,
+				})
+			}
+			nc.usage.SetTunaNodes(nodes)
+		}
+		time.Sleep(tunaNodesUpdateInterval)
+	}
+}
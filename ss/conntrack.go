@@ -0,0 +1,179 @@
+package ss
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var ErrConnNotFound = errors.New("connection not found")
+
+// ConnInfo is a proxied TCP relay or UDP NAT entry.
+type ConnInfo struct {
+	ID            uint64    `json:"id"`
+	Network       string    `json:"network"` // tcp or udp
+	Source        string    `json:"source"`
+	Destination   string    `json:"destination"`
+	Tunnel        string    `json:"tunnel,omitempty"` // local tunnel address the connection goes through, empty on server
+	BytesSent     uint64    `json:"bytesSent"`        // bytes from source to destination
+	BytesReceived uint64    `json:"bytesReceived"`    // bytes from destination to source
+	StartTime     time.Time `json:"startTime"`
+}
+
+type trackedConn struct {
+	sent     uint64 // accessed atomically, first in struct to be 64-bit aligned on 32-bit platforms
+	received uint64 // accessed atomically
+	info     ConnInfo
+	closers  []io.Closer
+}
+
+// Connection tracking table
+var conntrack = struct {
+	sync.RWMutex
+	nextID uint64
+	conns  map[uint64]*trackedConn
+}{conns: make(map[uint64]*trackedConn)}
+
+// track adds a connection to the table, closers are closed when the connection is killed.
+func track(network, source, destination, tunnel string, closers ...io.Closer) *trackedConn {
+	t := &trackedConn{
+		info: ConnInfo{
+			Network:     network,
+			Source:      source,
+			Destination: destination,
+			Tunnel:      tunnel,
+			StartTime:   time.Now(),
+		},
+		closers: closers,
+	}
+
+	conntrack.Lock()
+	conntrack.nextID++
+	t.info.ID = conntrack.nextID
+	conntrack.conns[t.info.ID] = t
+	conntrack.Unlock()
+
+	return t
+}
+
+func (t *trackedConn) untrack() {
+	conntrack.Lock()
+	delete(conntrack.conns, t.info.ID)
+	conntrack.Unlock()
+}
+
+func (t *trackedConn) close() {
+	for _, c := range t.closers {
+		c.Close()
+	}
+}
+
+// Connections returns all proxied connections ordered by ID.
+func Connections() []*ConnInfo {
+	conntrack.RLock()
+	conns := make([]*ConnInfo, 0, len(conntrack.conns))
+	for _, t := range conntrack.conns {
+		info := t.info
+		info.BytesSent = atomic.LoadUint64(&t.sent)
+		info.BytesReceived = atomic.LoadUint64(&t.received)
+		conns = append(conns, &info)
+	}
+	conntrack.RUnlock()
+
+	sort.Slice(conns, func(i, j int) bool { return conns[i].ID < conns[j].ID })
+
+	return conns
+}
+
+// KillConnection closes the connection with id.
+func KillConnection(id uint64) error {
+	conntrack.Lock()
+	t, ok := conntrack.conns[id]
+	delete(conntrack.conns, id)
+	conntrack.Unlock()
+
+	if !ok {
+		return ErrConnNotFound
+	}
+	t.close()
+
+	return nil
+}
+
+// countConn counts bytes read from the source side of a relay as sent, and bytes written to it as received.
+type countConn struct {
+	net.Conn
+	t *trackedConn
+}
+
+func (c *countConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	atomic.AddUint64(&c.t.sent, uint64(n))
+	return n, err
+}
+
+func (c *countConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	atomic.AddUint64(&c.t.received, uint64(n))
+	return n, err
+}
+
+// countPacketConn counts bytes written to the destination side of a NAT entry as sent, and bytes
+// read from it as received. The entry is removed from the table when it's closed.
+type countPacketConn struct {
+	net.PacketConn
+	t *trackedConn
+}
+
+func trackPacketConn(pc net.PacketConn, source, destination, tunnel string) net.PacketConn {
+	return &countPacketConn{PacketConn: pc, t: track("udp", source, destination, tunnel, pc)}
+}
+
+func (c *countPacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	n, err := c.PacketConn.WriteTo(b, addr)
+	atomic.AddUint64(&c.t.sent, uint64(n))
+	return n, err
+}
+
+func (c *countPacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	n, addr, err := c.PacketConn.ReadFrom(b)
+	atomic.AddUint64(&c.t.received, uint64(n))
+	return n, addr, err
+}
+
+func (c *countPacketConn) Close() error {
+	c.t.untrack()
+	return c.PacketConn.Close()
+}
+
+// countDestConn counts bytes written to the destination side of a connection as sent, and bytes
+// read from it as received. The connection is removed from the table when it's closed.
+type countDestConn struct {
+	net.Conn
+	t *trackedConn
+}
+
+func trackConn(c net.Conn, source, destination, tunnel string) net.Conn {
+	return &countDestConn{Conn: c, t: track("tcp", source, destination, tunnel, c)}
+}
+
+func (c *countDestConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	atomic.AddUint64(&c.t.sent, uint64(n))
+	return n, err
+}
+
+func (c *countDestConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	atomic.AddUint64(&c.t.received, uint64(n))
+	return n, err
+}
+
+func (c *countDestConn) Close() error {
+	c.t.untrack()
+	return c.Conn.Close()
+}
@@ -0,0 +1,42 @@
+package ss
+
+import (
+	"io"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// go test -v -run=TestConntrack
+func TestConntrack(t *testing.T) {
+	left, right := net.Pipe()
+	tc := track("tcp", "127.0.0.1:1000", "10.0.86.3:22", "127.0.0.1:2000", left, right)
+	defer tc.untrack()
+
+	c := &countConn{Conn: left, t: tc}
+	go func() {
+		right.Write([]byte("hello"))
+		io.ReadFull(right, make([]byte, 3))
+	}()
+	_, err := io.ReadFull(c, make([]byte, 5))
+	require.NoError(t, err)
+	_, err = c.Write([]byte("bye"))
+	require.NoError(t, err)
+
+	var info *ConnInfo
+	for _, conn := range Connections() {
+		if conn.ID == tc.info.ID {
+			info = conn
+		}
+	}
+	require.NotNil(t, info)
+	require.Equal(t, "10.0.86.3:22", info.Destination)
+	require.Equal(t, uint64(5), info.BytesSent)
+	require.Equal(t, uint64(3), info.BytesReceived)
+
+	require.NoError(t, KillConnection(info.ID))
+	_, err = left.Read(make([]byte, 1))
+	require.Error(t, err)
+	require.Equal(t, ErrConnNotFound, KillConnection(info.ID))
+}
@@ -0,0 +1,155 @@
+package ss
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/shadowsocks/go-shadowsocks2/socks"
+)
+
+// Direct path for userspace network stacks: connections are sent to the tunnel picked for
+// their targets without going through the local SOCKS proxy. The target address header is
+// still written because the server needs it to know where to connect. With raw protocol,
+// which servers accepting it take from any client, nothing else is added; servers only
+// accepting the cipher still get shadowsocks framing.
+
+var ErrClientNotStarted = errors.New("shadowsocks client is not started")
+
+var direct struct {
+	sync.RWMutex
+	streamShadow func(net.Conn) net.Conn
+	packetShadow func(net.PacketConn) net.PacketConn
+	dialTunnel   func(addr string) (net.Conn, error)
+}
+
+func setClientShadow(streamShadow func(net.Conn) net.Conn, packetShadow func(net.PacketConn) net.PacketConn) {
+	direct.Lock()
+	direct.streamShadow = streamShadow
+	direct.packetShadow = packetShadow
+	direct.Unlock()
+}
+
+// SetTunnelDialer sets the function Dial uses to connect to the tunnel listening on a local
+// address, so it can dial the tunnel's remote side directly instead of its local listener.
+// Dial connects to the local listener if it's not set.
+func SetTunnelDialer(dial func(addr string) (net.Conn, error)) {
+	direct.Lock()
+	direct.dialTunnel = dial
+	direct.Unlock()
+}
+
+// Dial connects to target through the tunnel picked for it. Source is only used for
+// connection tracking.
+func Dial(source, target string) (net.Conn, error) {
+	tgt := socks.ParseAddr(target)
+	if tgt == nil {
+		return nil, fmt.Errorf("invalid target address %q", target)
+	}
+
+	direct.RLock()
+	shadow, dialTunnel := direct.streamShadow, direct.dialTunnel
+	direct.RUnlock()
+	if shadow == nil {
+		return nil, ErrClientNotStarted
+	}
+
+	server := getClient(target)
+	if server == "" {
+		return nil, fmt.Errorf("no tunnel for target %v", target)
+	}
+
+	var rc net.Conn
+	var err error
+	if dialTunnel != nil {
+		rc, err = dialTunnel(server)
+	} else {
+		rc, err = net.Dial("tcp", server)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to server %v: %v", server, err)
+	}
+
+	if tc, ok := rc.(*net.TCPConn); ok && config.TCPCork.Load() {
+		timedCork(tc, 10*time.Millisecond)
+	}
+	rc = shadow(rc)
+
+	if _, err = rc.Write(tgt); err != nil {
+		rc.Close()
+		return nil, fmt.Errorf("failed to send target address: %v", err)
+	}
+
+	logf("proxy %s <-> %s <-> %s", source, server, target)
+	return trackConn(rc, source, target, server), nil
+}
+
+// packetConn sends packets to their targets through the tunnels picked for them, and
+// strips the source address header of packets from targets.
+type packetConn struct {
+	net.PacketConn
+	buf []byte // read buffer, packet conns are read by one goroutine
+}
+
+// ListenPacket returns a packet conn which sends packets to their targets through the
+// tunnels picked for them. Source and target of the first packet are only used for
+// connection tracking.
+func ListenPacket(source, target string) (net.PacketConn, error) {
+	direct.RLock()
+	shadow := direct.packetShadow
+	direct.RUnlock()
+	if shadow == nil {
+		return nil, ErrClientNotStarted
+	}
+
+	pc, err := net.ListenPacket("udp", "")
+	if err != nil {
+		return nil, err
+	}
+	pc = trackPacketConn(shadow(pc), source, target, getClient(target))
+	return &packetConn{PacketConn: pc, buf: make([]byte, udpBufSize)}, nil
+}
+
+func (c *packetConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	tgt := socks.ParseAddr(addr.String())
+	if tgt == nil {
+		return 0, fmt.Errorf("invalid target address %q", addr)
+	}
+	server := getClient(addr.String())
+	if server == "" {
+		return 0, fmt.Errorf("no tunnel for target %v", addr)
+	}
+	srvAddr, err := net.ResolveUDPAddr("udp", server)
+	if err != nil {
+		return 0, err
+	}
+
+	buf := make([]byte, len(tgt)+len(b))
+	copy(buf, tgt)
+	copy(buf[len(tgt):], b)
+	if _, err = c.PacketConn.WriteTo(buf, srvAddr); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (c *packetConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	buf := c.buf
+	for {
+		n, _, err := c.PacketConn.ReadFrom(buf)
+		if err != nil {
+			return 0, nil, err
+		}
+		src := socks.SplitAddr(buf[:n])
+		if src == nil {
+			continue
+		}
+		addr, err := net.ResolveUDPAddr("udp", src.String())
+		if err != nil {
+			continue
+		}
+		return copy(b, buf[len(src):n]), addr, nil
+	}
+}
@@ -0,0 +1,161 @@
+package ss
+
+import (
+	"io"
+	"net"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nknorg/nconnect/arch"
+	"github.com/shadowsocks/go-shadowsocks2/core"
+	"github.com/stretchr/testify/require"
+)
+
+const testPayloadSize = 16 * 1024
+
+var testProxy struct {
+	sync.Once
+	socksAddr string
+	tcpEcho   string
+	udpEcho   string
+}
+
+func freeAddr(t testing.TB) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	return l.Addr().String()
+}
+
+// startTestProxy starts echo servers, a shadowsocks server accepting raw protocol and a
+// client routing all targets to the server, like a tunnel with both sides on localhost. The
+// SOCKS proxy of the client uses the cipher and the direct path uses raw protocol.
+func startTestProxy(t testing.TB) {
+	testProxy.Do(func() {
+		tl, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		go func() {
+			for {
+				c, err := tl.Accept()
+				if err != nil {
+					return
+				}
+				go func() {
+					io.Copy(c, c)
+					c.Close()
+				}()
+			}
+		}()
+		testProxy.tcpEcho = tl.Addr().String()
+
+		ul, err := net.ListenPacket("udp", "127.0.0.1:0")
+		require.NoError(t, err)
+		go func() {
+			buf := make([]byte, udpBufSize)
+			for {
+				n, addr, err := ul.ReadFrom(buf)
+				if err != nil {
+					return
+				}
+				ul.WriteTo(buf[:n], addr)
+			}
+		}()
+		testProxy.udpEcho = ul.LocalAddr().String()
+
+		// client and server in one process share the salt filter, which would take
+		// client salts as repeated
+		os.Setenv("SHADOWSOCKS_SF_CAPACITY", "-1")
+		ciph, err := core.PickCipher("chacha20-ietf-poly1305", nil, "password")
+		require.NoError(t, err)
+
+		config.UDPTimeout.Store(int64(time.Minute))
+		server := freeAddr(t)
+		go tcpRemote(server, acceptRawStream(ciph.StreamConn))
+		go udpRemote(server, acceptRawPacket(ciph.PacketConn))
+
+		routes.Lock()
+		routes.DefaultClient = server
+		routes.Unlock()
+		setClientShadow(rawStream, rawPacket)
+
+		testProxy.socksAddr = freeAddr(t)
+		go socksLocal(testProxy.socksAddr, server, ciph.StreamConn)
+
+		time.Sleep(100 * time.Millisecond)
+	})
+}
+
+func echo(t testing.TB, c net.Conn, payload []byte) {
+	_, err := c.Write(payload)
+	require.NoError(t, err)
+	_, err = io.ReadFull(c, payload)
+	require.NoError(t, err)
+}
+
+// go test -v -run=TestDial
+func TestDial(t *testing.T) {
+	startTestProxy(t)
+
+	c, err := Dial("10.0.86.2:1000", testProxy.tcpEcho)
+	require.NoError(t, err)
+	defer c.Close()
+
+	payload := []byte("hello")
+	echo(t, c, payload)
+	require.Equal(t, "hello", string(payload))
+}
+
+// go test -v -run=TestListenPacket
+func TestListenPacket(t *testing.T) {
+	startTestProxy(t)
+
+	pc, err := ListenPacket("10.0.86.2:1000", testProxy.udpEcho)
+	require.NoError(t, err)
+	defer pc.Close()
+
+	target, err := net.ResolveUDPAddr("udp", testProxy.udpEcho)
+	require.NoError(t, err)
+	_, err = pc.WriteTo([]byte("hello"), target)
+	require.NoError(t, err)
+
+	buf := make([]byte, 64)
+	pc.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, addr, err := pc.ReadFrom(buf)
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(buf[:n]))
+	require.Equal(t, target.String(), addr.String())
+}
+
+// benchmarkTun sends data of one TCP session through the handler the TUN device passes
+// sessions to, so only the steady state of the path is measured, not connecting.
+func benchmarkTun(b *testing.B, conf *arch.TunConfig) {
+	startTestProxy(b)
+	tcpHandler, _, err := arch.NewTunHandlers(conf)
+	require.NoError(b, err)
+	target, err := net.ResolveTCPAddr("tcp", testProxy.tcpEcho)
+	require.NoError(b, err)
+
+	// the stack side of the session, like a TCP connection of the TUN device
+	c, stackConn := net.Pipe()
+	defer c.Close()
+	require.NoError(b, tcpHandler.Handle(stackConn, target))
+
+	payload := make([]byte, testPayloadSize)
+	b.SetBytes(testPayloadSize)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		echo(b, c, payload)
+	}
+}
+
+// go test -run=none -bench=Tun ./ss
+func BenchmarkSocksTun(b *testing.B) {
+	startTestProxy(b)
+	benchmarkTun(b, &arch.TunConfig{SocksAddr: testProxy.socksAddr})
+}
+
+func BenchmarkDirectTun(b *testing.B) {
+	benchmarkTun(b, &arch.TunConfig{Dial: Dial, ListenPacket: ListenPacket})
+}
@@ -0,0 +1,148 @@
+package ss
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/nknorg/nconnect/util"
+	"github.com/shadowsocks/go-shadowsocks2/socks"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+)
+
+// ICMP echo through UDP relay: a client sends the echo request message as a UDP packet to
+// port icmpEchoPort of the destination, the server pings the destination and sends the
+// echo reply message back from the same address. Port 0 is never a valid UDP destination,
+// so servers which don't support it just fail to relay the packet.
+
+const (
+	icmpEchoPort    = 0
+	icmpEchoTimeout = 5 * time.Second
+
+	// maxICMPEchoes is the most echo requests waiting for replies on a server, higher than
+	// the limit of a client's TUN device since a server relays echoes of all its clients.
+	maxICMPEchoes = 256
+)
+
+var errNotEchoRequest = errors.New("not an ICMP echo request")
+
+var icmpEchoes = util.NewLimiter(maxICMPEchoes)
+
+// ICMPEcho sends ICMP echo request msg to dst through the tunnel picked for it, and returns
+// the echo reply message pinged by the server. Source is only used for connection tracking.
+func ICMPEcho(source, dst net.IP, msg []byte) ([]byte, error) {
+	target := &net.UDPAddr{IP: dst, Port: icmpEchoPort}
+	pc, err := ListenPacket(source.String(), target.String())
+	if err != nil {
+		return nil, err
+	}
+	defer pc.Close()
+
+	if _, err = pc.WriteTo(msg, target); err != nil {
+		return nil, err
+	}
+
+	pc.SetReadDeadline(time.Now().Add(icmpEchoTimeout + time.Second)) // server waits icmpEchoTimeout after it gets the request
+	buf := make([]byte, udpBufSize)
+	for {
+		n, addr, err := pc.ReadFrom(buf)
+		if err != nil {
+			return nil, err
+		}
+		if addr.String() == target.String() {
+			return buf[:n], nil
+		}
+	}
+}
+
+// goICMPEchoRemote runs icmpEchoRemote in a new goroutine. It returns false and drops the
+// request if maxICMPEchoes requests are waiting for replies. msg is copied.
+func goICMPEchoRemote(c net.PacketConn, raddr net.Addr, dst net.IP, msg []byte) bool {
+	msg = append([]byte(nil), msg...)
+	return icmpEchoes.TryGo(func() { icmpEchoRemote(c, raddr, dst, msg) })
+}
+
+// icmpEchoRemote pings dst with echo request msg, and sends the echo reply to the client
+// at raddr through c.
+func icmpEchoRemote(c net.PacketConn, raddr net.Addr, dst net.IP, msg []byte) {
+	reply, err := icmpEcho(dst, msg, icmpEchoTimeout)
+	if err != nil {
+		if err, ok := err.(net.Error); !ok || !err.Timeout() {
+			logf("ICMP echo to %v error: %v", dst, err)
+		}
+		return
+	}
+
+	srcAddr := socks.ParseAddr((&net.UDPAddr{IP: dst, Port: icmpEchoPort}).String())
+	if _, err = c.WriteTo(append(srcAddr, reply...), raddr); err != nil {
+		logf("UDP remote write error: %v", err)
+	}
+}
+
+// icmpEcho sends echo request msg to dst and returns the echo reply message. Unprivileged
+// ICMP sockets are used if the system allows them, otherwise raw sockets which need root.
+func icmpEcho(dst net.IP, msg []byte, timeout time.Duration) ([]byte, error) {
+	if dst.To4() == nil {
+		return nil, fmt.Errorf("ICMP echo to %v is not supported, only IPv4 is supported", dst)
+	}
+	req, err := icmp.ParseMessage(ipv4.ICMPTypeEcho.Protocol(), msg)
+	if err != nil {
+		return nil, err
+	}
+	echo, ok := req.Body.(*icmp.Echo)
+	if !ok || req.Type != ipv4.ICMPTypeEcho {
+		return nil, errNotEchoRequest
+	}
+
+	var addr net.Addr = &net.UDPAddr{IP: dst}
+	raw := false
+	c, err := icmp.ListenPacket("udp4", "0.0.0.0")
+	if err != nil {
+		addr, raw = &net.IPAddr{IP: dst}, true
+		c, err = icmp.ListenPacket("ip4:icmp", "0.0.0.0")
+		if err != nil {
+			return nil, err
+		}
+	}
+	defer c.Close()
+
+	if _, err = c.WriteTo(msg, addr); err != nil {
+		return nil, err
+	}
+
+	c.SetReadDeadline(time.Now().Add(timeout))
+	buf := make([]byte, udpBufSize)
+	for {
+		n, peer, err := c.ReadFrom(buf)
+		if err != nil {
+			return nil, err
+		}
+		if !addrIP(peer).Equal(dst) {
+			continue
+		}
+		resp, err := icmp.ParseMessage(ipv4.ICMPTypeEcho.Protocol(), buf[:n])
+		if err != nil || resp.Type != ipv4.ICMPTypeEchoReply {
+			continue
+		}
+		// unprivileged sockets replace echo ID with their own and only get their replies,
+		// raw sockets get all ICMP packets
+		r, ok := resp.Body.(*icmp.Echo)
+		if !ok || r.Seq != echo.Seq || (raw && r.ID != echo.ID) {
+			continue
+		}
+		r.ID = echo.ID
+		return resp.Marshal(nil)
+	}
+}
+
+func addrIP(addr net.Addr) net.IP {
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		return a.IP
+	case *net.IPAddr:
+		return a.IP
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+package ss
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+)
+
+// go test -v -run=TestICMPEcho
+func TestICMPEcho(t *testing.T) {
+	if c, err := icmp.ListenPacket("udp4", "0.0.0.0"); err == nil {
+		c.Close()
+	} else if c, err = icmp.ListenPacket("ip4:icmp", "0.0.0.0"); err == nil {
+		c.Close()
+	} else {
+		t.Skip("ICMP sockets are not allowed:", err)
+	}
+	startTestProxy(t)
+
+	req := &icmp.Message{Type: ipv4.ICMPTypeEcho, Body: &icmp.Echo{ID: 1234, Seq: 7, Data: []byte("hello")}}
+	msg, err := req.Marshal(nil)
+	require.NoError(t, err)
+
+	reply, err := ICMPEcho(net.ParseIP("10.0.86.2"), net.ParseIP("127.0.0.1"), msg)
+	require.NoError(t, err)
+	resp, err := icmp.ParseMessage(ipv4.ICMPTypeEcho.Protocol(), reply)
+	require.NoError(t, err)
+	require.Equal(t, ipv4.ICMPTypeEchoReply, resp.Type)
+	require.Equal(t, &icmp.Echo{ID: 1234, Seq: 7, Data: []byte("hello")}, resp.Body)
+}
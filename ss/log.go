@@ -9,7 +9,7 @@ import (
 var logger = log.New(os.Stderr, "", log.Lshortfile|log.LstdFlags)
 
 func logf(f string, v ...interface{}) {
-	if config.Verbose {
+	if config.Verbose.Load() {
 		logger.Output(2, fmt.Sprintf(f, v...))
 	}
 }
@@ -19,7 +19,7 @@ type logHelper struct {
 }
 
 func (l *logHelper) Write(p []byte) (n int, err error) {
-	if config.Verbose {
+	if config.Verbose.Load() {
 		logger.Printf("%s%s\n", l.prefix, p)
 		return len(p), nil
 	}
@@ -1,6 +1,8 @@
 package ss
 
 import (
+	"net"
+	"sort"
 	"strings"
 	"sync"
 )
@@ -9,6 +11,12 @@ var routes struct {
 	sync.RWMutex
 	TargetToClient map[string]string // map target ip to local tunnel port
 	DefaultClient  string            // the default client for the targets are not in TargetToClient map
+	cidrToClient   []*cidrClient     // tunnels for target CIDRs, longest prefix first
+}
+
+type cidrClient struct {
+	ipNet  *net.IPNet
+	client string
 }
 
 func getClient(target string) string {
@@ -19,6 +27,13 @@ func getClient(target string) string {
 	if server, ok := routes.TargetToClient[tgtIp[0]]; ok {
 		return server
 	}
+	if ip := net.ParseIP(tgtIp[0]); ip != nil {
+		for _, c := range routes.cidrToClient {
+			if c.ipNet.Contains(ip) {
+				return c.client
+			}
+		}
+	}
 	return routes.DefaultClient
 }
 
@@ -27,3 +42,23 @@ func UpdateTargetToClient(targetToClient map[string]string) {
 	defer routes.Unlock()
 	routes.TargetToClient = targetToClient
 }
+
+// UpdateCIDRToClient sets the local tunnel ports for targets in CIDRs, which are used for
+// targets not in TargetToClient map. Invalid CIDRs are ignored.
+func UpdateCIDRToClient(cidrToClient map[string]string) {
+	clients := make([]*cidrClient, 0, len(cidrToClient))
+	for cidr, client := range cidrToClient {
+		if _, ipNet, err := net.ParseCIDR(cidr); err == nil {
+			clients = append(clients, &cidrClient{ipNet: ipNet, client: client})
+		}
+	}
+	sort.Slice(clients, func(i, j int) bool {
+		onesI, _ := clients[i].ipNet.Mask.Size()
+		onesJ, _ := clients[j].ipNet.Mask.Size()
+		return onesI > onesJ
+	})
+
+	routes.Lock()
+	defer routes.Unlock()
+	routes.cidrToClient = clients
+}
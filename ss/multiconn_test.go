@@ -0,0 +1,35 @@
+package ss
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// go test -v -run=TestGetClient
+func TestGetClient(t *testing.T) {
+	routes.Lock()
+	targetToClient, defaultClient, cidrToClient := routes.TargetToClient, routes.DefaultClient, routes.cidrToClient
+	routes.Unlock()
+	defer func() {
+		routes.Lock()
+		routes.TargetToClient, routes.DefaultClient, routes.cidrToClient = targetToClient, defaultClient, cidrToClient
+		routes.Unlock()
+	}()
+
+	UpdateTargetToClient(map[string]string{"10.0.86.3": "127.0.0.1:1001"})
+	UpdateCIDRToClient(map[string]string{
+		"192.168.0.0/16": "127.0.0.1:1002",
+		"192.168.1.0/24": "127.0.0.1:1003",
+		"invalid":        "127.0.0.1:1004",
+	})
+	routes.Lock()
+	routes.DefaultClient = "127.0.0.1:1000"
+	routes.Unlock()
+
+	require.Equal(t, "127.0.0.1:1001", getClient("10.0.86.3:22"))
+	require.Equal(t, "127.0.0.1:1003", getClient("192.168.1.10:22"))
+	require.Equal(t, "127.0.0.1:1002", getClient("192.168.2.10:22"))
+	require.Equal(t, "127.0.0.1:1000", getClient("8.8.8.8:53"))
+	require.Equal(t, "127.0.0.1:1000", getClient("example.com:80"))
+}
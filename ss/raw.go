@@ -0,0 +1,241 @@
+package ss
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// Raw protocol: NKN tunnels are already end to end encrypted, so the shadowsocks AEAD layer
+// only costs CPU. Raw connections and packets start with rawMagic instead of a salt, then
+// carry the target address header and payload in plain. Servers accepting the raw protocol
+// tell it apart from the cipher by the magic, so old clients keep working, and reply to
+// each client with the protocol it uses.
+
+var rawMagic = []byte("nConnect-raw/v1\x00")
+
+var errNotRawPacket = errors.New("not a raw protocol packet")
+
+// rawStream is the client side stream shadow of raw protocol.
+func rawStream(c net.Conn) net.Conn {
+	return &rawStreamConn{Conn: c}
+}
+
+type rawStreamConn struct {
+	net.Conn
+	sent bool
+}
+
+func (c *rawStreamConn) Write(b []byte) (int, error) {
+	if c.sent {
+		return c.Conn.Write(b)
+	}
+	c.sent = true
+	n, err := c.Conn.Write(append(append([]byte(nil), rawMagic...), b...))
+	if n -= len(rawMagic); n < 0 {
+		n = 0
+	}
+	return n, err
+}
+
+// rawPacket is the client side packet shadow of raw protocol.
+func rawPacket(pc net.PacketConn) net.PacketConn {
+	return &rawPacketConn{PacketConn: pc, buf: make([]byte, udpBufSize)}
+}
+
+type rawPacketConn struct {
+	net.PacketConn
+	sync.Mutex
+	buf []byte
+}
+
+func (c *rawPacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	c.Lock()
+	defer c.Unlock()
+	if len(rawMagic)+len(b) > len(c.buf) {
+		return 0, io.ErrShortBuffer
+	}
+	n := copy(c.buf, rawMagic)
+	n += copy(c.buf[n:], b)
+	_, err := c.PacketConn.WriteTo(c.buf[:n], addr)
+	return len(b), err
+}
+
+func (c *rawPacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	for {
+		n, addr, err := c.PacketConn.ReadFrom(b)
+		if err != nil {
+			return n, addr, err
+		}
+		if bytes.HasPrefix(b[:n], rawMagic) {
+			return copy(b, b[len(rawMagic):n]), addr, nil
+		}
+		logf("UDP read from %v error: %v", addr, errNotRawPacket)
+	}
+}
+
+// acceptRawStream returns the server side stream shadow accepting both raw protocol and
+// connections shadowed by shadow.
+func acceptRawStream(shadow func(net.Conn) net.Conn) func(net.Conn) net.Conn {
+	return func(c net.Conn) net.Conn {
+		return &acceptRawConn{Conn: c, shadow: shadow}
+	}
+}
+
+// acceptRawConn detects the protocol when it's first used. Servers read the target address
+// before writing anything, so it doesn't block writes.
+type acceptRawConn struct {
+	net.Conn
+	shadow func(net.Conn) net.Conn
+	once   sync.Once
+	conn   net.Conn
+	err    error
+}
+
+func (c *acceptRawConn) detect() error {
+	c.once.Do(func() {
+		b := make([]byte, len(rawMagic))
+		if _, c.err = io.ReadFull(c.Conn, b); c.err != nil {
+			return
+		}
+		if bytes.Equal(b, rawMagic) {
+			c.conn = c.Conn
+		} else {
+			c.conn = c.shadow(&prefixConn{Conn: c.Conn, prefix: b})
+		}
+	})
+	return c.err
+}
+
+func (c *acceptRawConn) Read(b []byte) (int, error) {
+	if err := c.detect(); err != nil {
+		return 0, err
+	}
+	return c.conn.Read(b)
+}
+
+func (c *acceptRawConn) Write(b []byte) (int, error) {
+	if err := c.detect(); err != nil {
+		return 0, err
+	}
+	return c.conn.Write(b)
+}
+
+func (c *acceptRawConn) WriteTo(w io.Writer) (int64, error) {
+	if err := c.detect(); err != nil {
+		return 0, err
+	}
+	return io.Copy(w, c.conn)
+}
+
+func (c *acceptRawConn) ReadFrom(r io.Reader) (int64, error) {
+	if err := c.detect(); err != nil {
+		return 0, err
+	}
+	return io.Copy(c.conn, r)
+}
+
+// prefixConn reads prefix before the rest of the connection.
+type prefixConn struct {
+	net.Conn
+	prefix []byte
+}
+
+func (c *prefixConn) Read(b []byte) (int, error) {
+	if len(c.prefix) > 0 {
+		n := copy(b, c.prefix)
+		c.prefix = c.prefix[n:]
+		return n, nil
+	}
+	return c.Conn.Read(b)
+}
+
+// acceptRawPacket returns the server side packet shadow accepting both raw protocol and
+// packets shadowed by shadow. Replies to a client use the protocol of its last packet.
+func acceptRawPacket(shadow func(net.PacketConn) net.PacketConn) func(net.PacketConn) net.PacketConn {
+	return func(pc net.PacketConn) net.PacketConn {
+		feed := &feedPacketConn{PacketConn: pc}
+		return &acceptRawPacketConn{
+			PacketConn: pc,
+			raw:        rawPacket(pc),
+			shadow:     shadow(feed),
+			feed:       feed,
+			rawPeers:   make(map[string]time.Time),
+		}
+	}
+}
+
+// acceptRawPacketConn is read by one goroutine at a time, like the listener of udpRemote.
+type acceptRawPacketConn struct {
+	net.PacketConn
+	raw    net.PacketConn
+	shadow net.PacketConn
+	feed   *feedPacketConn
+
+	sync.Mutex
+	rawPeers map[string]time.Time // last time raw packets were received, by client address
+}
+
+func (c *acceptRawPacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	for {
+		n, addr, err := c.PacketConn.ReadFrom(b)
+		if err != nil {
+			return n, addr, err
+		}
+		if bytes.HasPrefix(b[:n], rawMagic) {
+			c.setRaw(addr, true)
+			return copy(b, b[len(rawMagic):n]), addr, nil
+		}
+
+		c.feed.pkt, c.feed.addr = b[:n], addr
+		n, addr, err = c.shadow.ReadFrom(b)
+		if err != nil {
+			logf("UDP read from %v error: %v", addr, err)
+			continue
+		}
+		c.setRaw(addr, false)
+		return n, addr, nil
+	}
+}
+
+func (c *acceptRawPacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	c.Lock()
+	_, raw := c.rawPeers[addr.String()]
+	c.Unlock()
+	if raw {
+		return c.raw.WriteTo(b, addr)
+	}
+	return c.shadow.WriteTo(b, addr)
+}
+
+func (c *acceptRawPacketConn) setRaw(addr net.Addr, raw bool) {
+	c.Lock()
+	defer c.Unlock()
+	if !raw {
+		delete(c.rawPeers, addr.String())
+		return
+	}
+	now := time.Now()
+	if _, ok := c.rawPeers[addr.String()]; !ok {
+		for k, t := range c.rawPeers {
+			if now.Sub(t) > udpTimeout() {
+				delete(c.rawPeers, k)
+			}
+		}
+	}
+	c.rawPeers[addr.String()] = now
+}
+
+// feedPacketConn returns the packet it's fed on read, and writes to the underlying conn.
+type feedPacketConn struct {
+	net.PacketConn
+	pkt  []byte
+	addr net.Addr
+}
+
+func (c *feedPacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	return copy(b, c.pkt), c.addr, nil
+}
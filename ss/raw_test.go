@@ -0,0 +1,57 @@
+package ss
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/shadowsocks/go-shadowsocks2/core"
+	"github.com/shadowsocks/go-shadowsocks2/socks"
+	"github.com/stretchr/testify/require"
+)
+
+// go test -v -run=TestRawProtocol
+func TestRawProtocol(t *testing.T) {
+	startTestProxy(t)
+
+	ciph, err := core.PickCipher("chacha20-ietf-poly1305", nil, "password")
+	require.NoError(t, err)
+	server := freeAddr(t)
+	go tcpRemote(server, acceptRawStream(ciph.StreamConn))
+	go udpRemote(server, acceptRawPacket(ciph.PacketConn))
+	time.Sleep(100 * time.Millisecond)
+
+	// servers accepting raw protocol work with both raw and aead clients
+	for _, shadow := range []func(net.Conn) net.Conn{rawStream, ciph.StreamConn} {
+		c, err := net.Dial("tcp", server)
+		require.NoError(t, err)
+		c = shadow(c)
+		_, err = c.Write(socks.ParseAddr(testProxy.tcpEcho))
+		require.NoError(t, err)
+
+		payload := []byte("hello")
+		echo(t, c, payload)
+		require.Equal(t, "hello", string(payload))
+		c.Close()
+	}
+
+	serverAddr, err := net.ResolveUDPAddr("udp", server)
+	require.NoError(t, err)
+	for _, shadow := range []func(net.PacketConn) net.PacketConn{rawPacket, ciph.PacketConn} {
+		pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+		require.NoError(t, err)
+		pc = shadow(pc)
+
+		tgt := socks.ParseAddr(testProxy.udpEcho)
+		_, err = pc.WriteTo(append(tgt, "hello"...), serverAddr)
+		require.NoError(t, err)
+
+		buf := make([]byte, 64)
+		pc.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, _, err := pc.ReadFrom(buf)
+		require.NoError(t, err)
+		require.Equal(t, tgt.String(), socks.SplitAddr(buf[:n]).String())
+		require.Equal(t, "hello", string(buf[len(tgt):n]))
+		pc.Close()
+	}
+}
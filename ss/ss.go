@@ -5,6 +5,7 @@ import (
 	"errors"
 	"net/url"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/shadowsocks/go-shadowsocks2/core"
@@ -20,6 +21,7 @@ type Config struct {
 	Socks      string
 	RedirTCP   string
 	RedirTCP6  string
+	TproxyUDP  string
 	TCPTun     string
 	UDPTun     string
 	UDPSocks   bool
@@ -30,28 +32,42 @@ type Config struct {
 	Verbose    bool
 	UDPTimeout time.Duration
 	TCPCork    bool
+	Raw        bool // client: use raw protocol instead of cipher, server: accept raw protocol too
+	DirectRaw  bool // client: use raw protocol on the direct path even if Raw is false
 
 	TargetToClient map[string]string // map target ip to local tunnel port
 	DefaultClient  string            // the default client for the targets are not in Target2Client map
 }
 
+// config is shared by everything started in the process, the latest Start call sets it.
 var config struct {
-	Verbose    bool
-	UDPTimeout time.Duration
-	TCPCork    bool
+	Verbose    atomic.Bool
+	UDPTimeout atomic.Int64 // time.Duration
+	TCPCork    atomic.Bool
+}
+
+func udpTimeout() time.Duration {
+	return time.Duration(config.UDPTimeout.Load())
 }
 
+// Start starts the client and server of flags and blocks until one of them fails. It can be
+// called more than once in a process, like for a client and a server, but routes are shared
+// by all clients, so only one set of client routes is in use.
 func Start(flags *Config) error {
 	if flags.Client == "" && flags.Server == "" {
 		return errors.New("at least one of client/server mode should be used")
 	}
 
-	config.Verbose = flags.Verbose
-	config.UDPTimeout = flags.UDPTimeout
-	config.TCPCork = flags.TCPCork
+	config.Verbose.Store(flags.Verbose)
+	config.UDPTimeout.Store(int64(flags.UDPTimeout))
+	config.TCPCork.Store(flags.TCPCork)
 
-	routes.TargetToClient = flags.TargetToClient
-	routes.DefaultClient = flags.DefaultClient
+	if flags.Client != "" {
+		routes.Lock()
+		routes.TargetToClient = flags.TargetToClient
+		routes.DefaultClient = flags.DefaultClient
+		routes.Unlock()
+	}
 
 	var key []byte
 	if flags.Key != "" {
@@ -83,6 +99,15 @@ func Start(flags *Config) error {
 		if err != nil {
 			return err
 		}
+		streamShadow, packetShadow := ciph.StreamConn, ciph.PacketConn
+		if flags.Raw {
+			streamShadow, packetShadow = rawStream, rawPacket
+		}
+		if flags.Raw || flags.DirectRaw {
+			setClientShadow(rawStream, rawPacket)
+		} else {
+			setClientShadow(streamShadow, packetShadow)
+		}
 
 		if flags.Plugin != "" {
 			addr, err = startPlugin(flags.Plugin, flags.PluginOpts, addr, false)
@@ -95,7 +120,7 @@ func Start(flags *Config) error {
 			for _, tun := range strings.Split(flags.UDPTun, ",") {
 				p := strings.Split(tun, "=")
 				go func() {
-					sendErr(udpLocal(p[0], udpAddr, p[1], ciph.PacketConn), errChan)
+					sendErr(udpLocal(p[0], udpAddr, p[1], packetShadow), errChan)
 				}()
 			}
 		}
@@ -104,32 +129,40 @@ func Start(flags *Config) error {
 			for _, tun := range strings.Split(flags.TCPTun, ",") {
 				p := strings.Split(tun, "=")
 				go func() {
-					sendErr(tcpTun(p[0], addr, p[1], ciph.StreamConn), errChan)
+					sendErr(tcpTun(p[0], addr, p[1], streamShadow), errChan)
 				}()
 			}
 		}
 
 		if flags.Socks != "" {
-			socks.UDPEnabled = flags.UDPSocks
+			if flags.UDPSocks {
+				socks.UDPEnabled = true
+			}
 			go func() {
-				sendErr(socksLocal(flags.Socks, addr, ciph.StreamConn), errChan)
+				sendErr(socksLocal(flags.Socks, addr, streamShadow), errChan)
 			}()
 			if flags.UDPSocks {
 				go func() {
-					sendErr(udpSocksLocal(flags.Socks, udpAddr, ciph.PacketConn), errChan)
+					sendErr(udpSocksLocal(flags.Socks, udpAddr, packetShadow), errChan)
 				}()
 			}
 		}
 
 		if flags.RedirTCP != "" {
 			go func() {
-				sendErr(redirLocal(flags.RedirTCP, addr, ciph.StreamConn), errChan)
+				sendErr(redirLocal(flags.RedirTCP, addr, streamShadow), errChan)
 			}()
 		}
 
 		if flags.RedirTCP6 != "" {
 			go func() {
-				sendErr(redir6Local(flags.RedirTCP6, addr, ciph.StreamConn), errChan)
+				sendErr(redir6Local(flags.RedirTCP6, addr, streamShadow), errChan)
+			}()
+		}
+
+		if flags.TproxyUDP != "" {
+			go func() {
+				sendErr(udpTproxyLocal(flags.TproxyUDP, packetShadow), errChan)
 			}()
 		}
 	}
@@ -161,14 +194,19 @@ func Start(flags *Config) error {
 			return err
 		}
 
+		streamShadow, packetShadow := ciph.StreamConn, ciph.PacketConn
+		if flags.Raw {
+			streamShadow, packetShadow = acceptRawStream(streamShadow), acceptRawPacket(packetShadow)
+		}
+
 		if flags.UDP {
 			go func() {
-				sendErr(udpRemote(udpAddr, ciph.PacketConn), errChan)
+				sendErr(udpRemote(udpAddr, packetShadow), errChan)
 			}()
 		}
 		if flags.TCP {
 			go func() {
-				sendErr(tcpRemote(addr, ciph.StreamConn), errChan)
+				sendErr(tcpRemote(addr, streamShadow), errChan)
 			}()
 		}
 	}
@@ -68,7 +68,7 @@ func tcpLocal(addr, server string, shadow func(net.Conn) net.Conn, getAddr func(
 				return
 			}
 
-			server = getClient(tgt.String())
+			server := getClient(tgt.String())
 			rc, err := net.Dial("tcp", server)
 			if err != nil {
 				logf("failed to connect to server %v: %v", server, err)
@@ -77,7 +77,7 @@ func tcpLocal(addr, server string, shadow func(net.Conn) net.Conn, getAddr func(
 
 			defer rc.Close()
 			tc := rc.(*net.TCPConn)
-			if config.TCPCork {
+			if config.TCPCork.Load() {
 				timedCork(tc, 10*time.Millisecond)
 			}
 			rc = shadow(rc)
@@ -88,7 +88,9 @@ func tcpLocal(addr, server string, shadow func(net.Conn) net.Conn, getAddr func(
 			}
 
 			logf("proxy %s <-> %s <-> %s", c.RemoteAddr(), server, tgt)
-			err = relay(rc, c)
+			t := track("tcp", c.RemoteAddr().String(), tgt.String(), server, c, rc)
+			defer t.untrack()
+			err = relay(rc, &countConn{Conn: c, t: t})
 			if err != nil {
 				if err, ok := err.(net.Error); ok && err.Timeout() {
 					return // ignore i/o timeout
@@ -139,7 +141,9 @@ func tcpRemote(addr string, shadow func(net.Conn) net.Conn) error {
 			defer rc.Close()
 
 			logf("proxy %s <-> %s", c.RemoteAddr(), tgt)
-			err = relay(sc, rc)
+			t := track("tcp", c.RemoteAddr().String(), tgt.String(), "", c, rc)
+			defer t.untrack()
+			err = relay(&countConn{Conn: sc, t: t}, rc)
 			if err != nil {
 				if err, ok := err.(net.Error); ok && err.Timeout() {
 					return // ignore i/o timeout
@@ -42,7 +42,7 @@ func udpLocal(laddr, server, target string, shadow func(net.PacketConn) net.Pack
 	}
 	defer c.Close()
 
-	nm := newNATmap(config.UDPTimeout)
+	nm := newNATmap(udpTimeout())
 	buf := make([]byte, udpBufSize)
 	copy(buf, tgt)
 
@@ -62,7 +62,7 @@ func udpLocal(laddr, server, target string, shadow func(net.PacketConn) net.Pack
 				continue
 			}
 
-			pc = shadow(pc)
+			pc = trackPacketConn(shadow(pc), raddr.String(), target, server)
 			nm.Add(raddr, c, pc, relayClient)
 		}
 
@@ -82,7 +82,7 @@ func udpSocksLocal(laddr, server string, shadow func(net.PacketConn) net.PacketC
 	}
 	defer c.Close()
 
-	nm := newNATmap(config.UDPTimeout)
+	nm := newNATmap(udpTimeout())
 	buf := make([]byte, udpBufSize)
 
 	for {
@@ -92,6 +92,13 @@ func udpSocksLocal(laddr, server string, shadow func(net.PacketConn) net.PacketC
 			continue
 		}
 
+		dest := socks.Addr(buf[3:])
+		server = getClient(dest.String())
+		if server == "" {
+			// logf("UDP target address error: invalid target address: %q", dest)
+			continue
+		}
+
 		pc := nm.Get(raddr.String())
 		if pc == nil {
 			pc, err = net.ListenPacket("udp", "")
@@ -100,16 +107,10 @@ func udpSocksLocal(laddr, server string, shadow func(net.PacketConn) net.PacketC
 				continue
 			}
 			// logf("UDP socks tunnel %s <-> %s <-> %s", laddr, server, socks.Addr(buf[3:]))
-			pc = shadow(pc)
+			pc = trackPacketConn(shadow(pc), raddr.String(), dest.String(), server)
 			nm.Add(raddr, c, pc, socksClient)
 		}
 
-		dest := socks.Addr(buf[3:])
-		server = getClient(dest.String())
-		if server == "" {
-			// logf("UDP target address error: invalid target address: %q", dest)
-			continue
-		}
 		srvAddr, err := net.ResolveUDPAddr("udp", server)
 		if err != nil {
 			return fmt.Errorf("UDP server address error: %v", err)
@@ -132,7 +133,7 @@ func udpRemote(addr string, shadow func(net.PacketConn) net.PacketConn) error {
 	defer c.Close()
 	c = shadow(c)
 
-	nm := newNATmap(config.UDPTimeout)
+	nm := newNATmap(udpTimeout())
 	buf := make([]byte, udpBufSize)
 
 	logf("listening UDP on %s", addr)
@@ -157,6 +158,13 @@ func udpRemote(addr string, shadow func(net.PacketConn) net.PacketConn) error {
 
 		payload := buf[len(tgtAddr):n]
 
+		if tgtUDPAddr.Port == icmpEchoPort {
+			if !goICMPEchoRemote(c, raddr, tgtUDPAddr.IP, payload) {
+				logf("too many ICMP echo requests, drop the one from %v to %v", raddr, tgtUDPAddr.IP)
+			}
+			continue
+		}
+
 		pc := nm.Get(raddr.String())
 		if pc == nil {
 			pc, err = net.ListenPacket("udp", "")
@@ -165,6 +173,7 @@ func udpRemote(addr string, shadow func(net.PacketConn) net.PacketConn) error {
 				continue
 			}
 
+			pc = trackPacketConn(pc, raddr.String(), tgtAddr.String(), "")
 			nm.Add(raddr, c, pc, remoteServer)
 		}
 
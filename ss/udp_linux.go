@@ -0,0 +1,106 @@
+package ss
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/shadowsocks/go-shadowsocks2/socks"
+	"github.com/txthinking/brook/tproxy"
+)
+
+// replyConn sends packets to the user from the original destination of TPROXY packets.
+type replyConn struct {
+	*net.UDPConn
+}
+
+func (c replyConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	return c.Write(b)
+}
+
+const maxReadErrorDelay = time.Second
+
+// Listen on laddr for TPROXY redirected UDP packets, encrypt and send to server to reach
+// their original destinations.
+func udpTproxyLocal(laddr string, shadow func(net.PacketConn) net.PacketConn) error {
+	addr, err := net.ResolveUDPAddr("udp", laddr)
+	if err != nil {
+		return fmt.Errorf("UDP tproxy address error: %v", err)
+	}
+	c, err := tproxy.ListenUDP("udp", addr)
+	if err != nil {
+		return fmt.Errorf("UDP tproxy listen error: %v", err)
+	}
+	defer c.Close()
+
+	logf("UDP tproxy %s", laddr)
+	nm := newNATmap(udpTimeout())
+	buf := make([]byte, udpBufSize)
+	oob := make([]byte, 1024)
+	var delay time.Duration // backoff of read errors
+	for {
+		n, raddr, dst, err := tproxy.ReadFromUDP(c, oob, buf[socks.MaxAddrLen:])
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return err
+			}
+			if delay == 0 {
+				delay = 5 * time.Millisecond
+			} else if delay *= 2; delay > maxReadErrorDelay {
+				delay = maxReadErrorDelay
+			}
+			logf("UDP tproxy read error: %v, retrying in %v", err, delay)
+			time.Sleep(delay)
+			continue
+		}
+		delay = 0
+		if dst == nil {
+			continue
+		}
+
+		tgt := socks.ParseAddr(dst.String())
+		server := getClient(dst.String())
+		if tgt == nil || server == "" {
+			continue
+		}
+		srvAddr, err := net.ResolveUDPAddr("udp", server)
+		if err != nil {
+			logf("UDP server address error: %v", err)
+			continue
+		}
+
+		key := raddr.String() + "-" + dst.String()
+		pc := nm.Get(key)
+		if pc == nil {
+			rc, err := tproxy.DialUDP("udp", dst, raddr)
+			if err != nil {
+				logf("UDP tproxy reply socket error: %v", err)
+				continue
+			}
+			pc, err = net.ListenPacket("udp", "")
+			if err != nil {
+				rc.Close()
+				logf("UDP local listen error: %v", err)
+				continue
+			}
+			pc = trackPacketConn(shadow(pc), raddr.String(), dst.String(), server)
+			nm.Set(key, pc)
+			go func() {
+				timedCopy(replyConn{rc}, raddr, pc, nm.timeout, relayClient)
+				if pc := nm.Del(key); pc != nil {
+					pc.Close()
+				}
+				rc.Close()
+			}()
+		}
+
+		start := socks.MaxAddrLen - len(tgt)
+		copy(buf[start:], tgt)
+		_, err = pc.WriteTo(buf[start:socks.MaxAddrLen+n], srvAddr)
+		if err != nil {
+			logf("UDP local write error: %v", err)
+			continue
+		}
+	}
+}
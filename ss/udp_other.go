@@ -0,0 +1,12 @@
+// +build !linux
+
+package ss
+
+import (
+	"errors"
+	"net"
+)
+
+func udpTproxyLocal(laddr string, shadow func(net.PacketConn) net.PacketConn) error {
+	return errors.New("UDP tproxy not supported")
+}
@@ -0,0 +1,107 @@
+package tests
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nknorg/nconnect/ss"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/proxy"
+)
+
+const protocolPayloadSize = 32 * 1024
+
+var protocolProxy struct {
+	sync.Once
+	aeadSocks string
+	rawSocks  string
+	err       error
+}
+
+// startProtocolProxy starts a shadowsocks server accepting both internal protocols with a
+// raw client, and an aead client of the same server, so the protocols can be compared
+// without NKN tunnels. Targets are the TCP server started by TestMain.
+func startProtocolProxy() error {
+	protocolProxy.Do(func() {
+		// The salt filter is created on first use and shared by the aead client and server
+		// in this process, where it would reject the client's salts as replays.
+		os.Setenv("SHADOWSOCKS_SF_CAPACITY", "-1")
+
+		var addrs []string
+		for i := 0; i < 3; i++ {
+			port, protocolProxy.err = getFreePort(port + 1)
+			if protocolProxy.err != nil {
+				return
+			}
+			addrs = append(addrs, fmt.Sprintf("127.0.0.1:%v", port))
+		}
+		server := addrs[0]
+		protocolProxy.rawSocks, protocolProxy.aeadSocks = addrs[1], addrs[2]
+
+		// Start calls share ss state of the process, so the raw client is started with the
+		// server, and only the aead client needs another call
+		configs := []*ss.Config{
+			{Server: server, Client: server, Socks: protocolProxy.rawSocks, Raw: true},
+			{Client: server, Socks: protocolProxy.aeadSocks},
+		}
+		errs := make(chan error, len(configs))
+		for _, conf := range configs {
+			conf.DefaultClient = server
+			conf.TCP = true
+			conf.Cipher = "chacha20-ietf-poly1305"
+			conf.Password = "password"
+			go func(conf *ss.Config) {
+				errs <- ss.Start(conf)
+			}(conf)
+		}
+		time.Sleep(100 * time.Millisecond)
+
+		select {
+		case protocolProxy.err = <-errs:
+		default:
+		}
+	})
+	return protocolProxy.err
+}
+
+func benchmarkProtocol(b *testing.B, raw bool) {
+	require.NoError(b, startProtocolProxy())
+	socksAddr := protocolProxy.aeadSocks
+	if raw {
+		socksAddr = protocolProxy.rawSocks
+	}
+
+	// the TCP server logs every read, which is not what is measured
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	dialer, err := proxy.SOCKS5("tcp", socksAddr, nil, proxy.Direct)
+	require.NoError(b, err)
+	c, err := dialer.Dial("tcp", "127.0.0.1"+tcpPort)
+	require.NoError(b, err)
+	defer c.Close()
+
+	payload := make([]byte, protocolPayloadSize)
+	b.SetBytes(protocolPayloadSize)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err = c.Write(payload)
+		require.NoError(b, err)
+		_, err = io.ReadFull(c, payload)
+		require.NoError(b, err)
+	}
+}
+
+// go test -run=none -bench=Protocol .
+func BenchmarkAEADProtocol(b *testing.B) {
+	benchmarkProtocol(b, false)
+}
+
+func BenchmarkRawProtocol(b *testing.B) {
+	benchmarkProtocol(b, true)
+}
@@ -0,0 +1,25 @@
+package util
+
+// Limiter runs functions in new goroutines, at most as many at a time as its capacity.
+// Functions started when it's full are dropped instead of waiting, so work arriving faster
+// than it finishes, like a ping flood, doesn't start a goroutine for each request.
+type Limiter chan struct{}
+
+func NewLimiter(n int) Limiter {
+	return make(Limiter, n)
+}
+
+// TryGo runs f in a new goroutine and returns true, or returns false without running f if
+// the limiter is full.
+func (l Limiter) TryGo(f func()) bool {
+	select {
+	case l <- struct{}{}:
+	default:
+		return false
+	}
+	go func() {
+		defer func() { <-l }()
+		f()
+	}()
+	return true
+}
@@ -0,0 +1,78 @@
+package util
+
+import (
+	"strings"
+	"sync"
+)
+
+// LogBuffer keeps the latest log lines in memory and sends new lines to
+// subscribers. It's used as an extra writer of the standard logger.
+type LogBuffer struct {
+	sync.Mutex
+	maxLines    int
+	lines       []string
+	partial     string
+	subscribers map[chan string]struct{}
+}
+
+func NewLogBuffer(maxLines int) *LogBuffer {
+	return &LogBuffer{
+		maxLines:    maxLines,
+		lines:       make([]string, 0, maxLines),
+		subscribers: make(map[chan string]struct{}),
+	}
+}
+
+func (b *LogBuffer) Write(p []byte) (int, error) {
+	b.Lock()
+	defer b.Unlock()
+
+	s := b.partial + string(p)
+	lines := strings.Split(s, "\n")
+	b.partial = lines[len(lines)-1]
+	for _, line := range lines[:len(lines)-1] {
+		if len(b.lines) == b.maxLines {
+			copy(b.lines, b.lines[1:])
+			b.lines = b.lines[:len(b.lines)-1]
+		}
+		b.lines = append(b.lines, line)
+
+		for c := range b.subscribers {
+			select {
+			case c <- line:
+			default: // drop lines for slow subscribers rather than blocking logging
+			}
+		}
+	}
+
+	return len(p), nil
+}
+
+// Lines returns at most n latest lines, all buffered lines if n <= 0.
+func (b *LogBuffer) Lines(n int) []string {
+	b.Lock()
+	defer b.Unlock()
+
+	if n <= 0 || n > len(b.lines) {
+		n = len(b.lines)
+	}
+	lines := make([]string, n)
+	copy(lines, b.lines[len(b.lines)-n:])
+
+	return lines
+}
+
+// Subscribe returns a channel receiving new lines until Unsubscribe is called.
+func (b *LogBuffer) Subscribe() chan string {
+	c := make(chan string, 128)
+	b.Lock()
+	b.subscribers[c] = struct{}{}
+	b.Unlock()
+	return c
+}
+
+func (b *LogBuffer) Unsubscribe(c chan string) {
+	b.Lock()
+	delete(b.subscribers, c)
+	b.Unlock()
+}
@@ -1,7 +1,9 @@
 package util
 
 import (
+	"bytes"
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"log"
 	"net/http"
@@ -11,6 +13,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/nknorg/nkn/v2/common"
 	"github.com/nknorg/tuna"
 )
 
@@ -115,3 +118,86 @@ func GetRemotePrice(url string) (string, error) {
 	}
 	return price, nil
 }
+
+// ClampPrice bounds each part of tuna price (a single value or "in,out") by floor and ceil, and
+// limits its change from last price to ratio maxChange. Empty bound or zero ratio means no limit.
+func ClampPrice(price, last, floor, ceil string, maxChange float64) (string, error) {
+	p, err := parsePrice(price)
+	if err != nil {
+		return "", err
+	}
+	l, err := parsePrice(last)
+	if err != nil {
+		return "", err
+	}
+	f, err := parsePrice(floor)
+	if err != nil {
+		return "", err
+	}
+	c, err := parsePrice(ceil)
+	if err != nil {
+		return "", err
+	}
+
+	for i := range p {
+		if maxChange > 0 && l != nil {
+			delta := common.Fixed64(float64(l[i]) * maxChange)
+			if p[i] > l[i]+delta {
+				p[i] = l[i] + delta
+			} else if p[i] < l[i]-delta {
+				p[i] = l[i] - delta
+			}
+		}
+		if f != nil && p[i] < f[i] {
+			p[i] = f[i]
+		}
+		if c != nil && p[i] > c[i] {
+			p[i] = c[i]
+		}
+	}
+
+	if p[0] == p[1] {
+		return formatPrice(p[0]), nil
+	}
+	return formatPrice(p[0]) + "," + formatPrice(p[1]), nil
+}
+
+func formatPrice(p common.Fixed64) string {
+	s := p.String()
+	if strings.Contains(s, ".") {
+		s = strings.TrimRight(strings.TrimRight(s, "0"), ".")
+	}
+	return s
+}
+
+// parsePrice parses tuna price into in and out price, nil if s is empty.
+func parsePrice(s string) ([]common.Fixed64, error) {
+	if len(s) == 0 {
+		return nil, nil
+	}
+	in, out, err := tuna.ParsePrice(s)
+	if err != nil {
+		return nil, err
+	}
+	return []common.Fixed64{in, out}, nil
+}
+
+// PostJSON posts v as json to url.
+func PostJSON(url string, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	client := http.Client{
+		Timeout: 10 * time.Second,
+	}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("post %v: %v", url, resp.Status)
+	}
+	return nil
+}
@@ -2,7 +2,9 @@ package util
 
 import (
 	"log"
+	"reflect"
 	"testing"
+	"time"
 
 	ts "github.com/nknorg/nkn-tuna-session"
 )
@@ -21,3 +23,72 @@ func TestGetFreePort(t *testing.T) {
 	}
 	log.Println(port)
 }
+
+// go test -v -run=TestLogBuffer
+func TestLogBuffer(t *testing.T) {
+	b := NewLogBuffer(3)
+	c := b.Subscribe()
+	defer b.Unsubscribe(c)
+
+	b.Write([]byte("a\nb\nc"))
+	b.Write([]byte("\nd\n"))
+
+	if lines := b.Lines(0); !reflect.DeepEqual(lines, []string{"b", "c", "d"}) {
+		t.Fatalf("got lines %v", lines)
+	}
+	if lines := b.Lines(1); !reflect.DeepEqual(lines, []string{"d"}) {
+		t.Fatalf("got lines %v", lines)
+	}
+	for _, want := range []string{"a", "b", "c", "d"} {
+		if got := <-c; got != want {
+			t.Fatalf("got line %v, want %v", got, want)
+		}
+	}
+}
+
+// go test -v -run=TestClampPrice
+func TestClampPrice(t *testing.T) {
+	tests := []struct {
+		price, last, floor, ceil string
+		maxChange                float64
+		want                     string
+	}{
+		{"0.02", "", "", "", 0, "0.02"},
+		{"0.02", "", "", "0.01", 0, "0.01"},
+		{"0.0001", "", "0.001", "0.01", 0, "0.001"},
+		{"0.05", "0.01", "", "", 0.5, "0.015"},
+		{"0.001", "0.01", "", "", 0.5, "0.005"},
+		{"0.02,0.05", "", "", "0.03", 0, "0.02,0.03"},
+	}
+	for _, tt := range tests {
+		got, err := ClampPrice(tt.price, tt.last, tt.floor, tt.ceil, tt.maxChange)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Fatalf("ClampPrice(%v, %v, %v, %v, %v) = %v, want %v", tt.price, tt.last, tt.floor, tt.ceil, tt.maxChange, got, tt.want)
+		}
+	}
+	if _, err := ClampPrice("abc", "", "", "", 0); err == nil {
+		t.Fatal("expect error for invalid price")
+	}
+}
+
+// go test -v -run=TestLimiter
+func TestLimiter(t *testing.T) {
+	l := NewLimiter(1)
+	release := make(chan struct{})
+	if !l.TryGo(func() { <-release }) {
+		t.Fatal("function is dropped")
+	}
+	if l.TryGo(func() {}) {
+		t.Fatal("function should be dropped when the limiter is full")
+	}
+	close(release)
+
+	done := make(chan struct{})
+	for !l.TryGo(func() { close(done) }) {
+		time.Sleep(time.Millisecond)
+	}
+	<-done
+}
@@ -13,6 +13,9 @@ const methods = {
   setAcceptAddress: { method: 'setAcceptAddress' },
   sendToken: { method: 'sendToken' },
   nknPing: { method: 'nknPing' },
+  assignIp: { method: 'assignIp' },
+  approveRoutes: { method: 'approveRoutes' },
+  diagnose: { method: 'diagnose' },
 }
 
 var rpc = {};
@@ -91,4 +94,16 @@ export async function sendToken(address, amount) {
 
 export async function nknPing(address) {
   return rpc.nknPing(rpcAddr, {address});
+}
+
+export async function assignIp(address, ip) {
+  return rpc.assignIp(rpcAddr, {address, ip});
+}
+
+export async function approveRoutes(address, routes) {
+  return rpc.approveRoutes(rpcAddr, {address, routes});
+}
+
+export async function diagnose(from, to) {
+  return rpc.diagnose(rpcAddr, {from, to});
 }
\ No newline at end of file
@@ -16,7 +16,13 @@ const methods = {
   getSeed: { method: 'getSeed' },
   setSeed: { method: 'setSeed' },
   setTunaConfig: { method: 'setTunaConfig' },
-  getLog: { method: 'getLog' }
+  getLog: { method: 'getLog' },
+  getConnections: { method: 'getConnections' },
+  killConnection: { method: 'killConnection' },
+  getClientUsage: { method: 'getClientUsage' },
+  resetClientUsage: { method: 'resetClientUsage' },
+  getUsage: { method: 'getUsage' },
+  getAuditLog: { method: 'getAuditLog' }
 }
 
 var rpc = {};
@@ -132,3 +138,27 @@ export async function setTunaConfig(tunaConfig) {
 export async function getLog() {
   return rpc.getLog(rpcAddr);
 }
+
+export async function getConnections() {
+  return rpc.getConnections(rpcAddr);
+}
+
+export async function killConnection(id) {
+  return rpc.killConnection(rpcAddr, { id });
+}
+
+export async function getClientUsage(client) {
+  return rpc.getClientUsage(rpcAddr, { client });
+}
+
+export async function resetClientUsage(client) {
+  return rpc.resetClientUsage(rpcAddr, { client });
+}
+
+export async function getUsage(days) {
+  return rpc.getUsage(rpcAddr, { days });
+}
+
+export async function getAuditLog(filter) {
+  return rpc.getAuditLog(rpcAddr, filter);
+}